@@ -0,0 +1,117 @@
+package pprofsv
+
+import "math/bits"
+
+// bitset is a fixed-size set of small non-negative integers, packed into
+// 64-bit words so that set operations are word-parallel.
+type bitset []uint64
+
+func newBitset(n int) bitset {
+	return make(bitset, (n+63)/64)
+}
+
+func (b bitset) set(i int) {
+	b[i/64] |= 1 << (uint(i) % 64)
+}
+
+func (b bitset) unset(i int) {
+	b[i/64] &^= 1 << (uint(i) % 64)
+}
+
+func (b bitset) has(i int) bool {
+	return b[i/64]&(1<<(uint(i)%64)) != 0
+}
+
+// or merges o into b and returns true if b changed.
+func (b bitset) or(o bitset) bool {
+	changed := false
+	for i := range b {
+		merged := b[i] | o[i]
+		if merged != b[i] {
+			b[i] = merged
+			changed = true
+		}
+	}
+	return changed
+}
+
+// andNot clears every element of o from b.
+func (b bitset) andNot(o bitset) {
+	for i := range b {
+		b[i] &^= o[i]
+	}
+}
+
+// forEach calls f for every element in b in ascending order.
+func (b bitset) forEach(f func(i int)) {
+	for w, word := range b {
+		for word != 0 {
+			f(w*64 + bits.TrailingZeros64(word))
+			word &= word - 1
+		}
+	}
+}
+
+func (b bitset) clone() bitset {
+	c := make(bitset, len(b))
+	copy(c, b)
+	return c
+}
+
+// and keeps in b only the elements also in o.
+func (b bitset) and(o bitset) {
+	for i := range b {
+		b[i] &= o[i]
+	}
+}
+
+// empty returns true if b has no element.
+func (b bitset) empty() bool {
+	for _, word := range b {
+		if word != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// intersects returns true if b and o share at least one element.
+func (b bitset) intersects(o bitset) bool {
+	for i := range b {
+		if b[i]&o[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// subsetOf returns true if every element of b is also in o.
+func (b bitset) subsetOf(o bitset) bool {
+	for i := range b {
+		if b[i]&^o[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// complement returns the set of elements in [0, n) that are not in b.
+func (b bitset) complement(n int) bitset {
+	c := newBitset(n)
+	for i := range c {
+		c[i] = ^b[i]
+	}
+	if n%64 != 0 {
+		c[len(c)-1] &= 1<<(uint(n)%64) - 1
+	}
+	return c
+}
+
+func (b bitset) equal(o bitset) bool {
+	for i := range b {
+		if b[i] != o[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,604 @@
+// Command pprofsv verifies state-transition assertions on pprof profiles.
+//
+// Usage:
+//
+//	pprofsv check [flags] profile.pb.gz...
+//	pprofsv list-functions [flags] profile.pb.gz...
+//	pprofsv dump-stacks [flags] profile.pb.gz...
+//	pprofsv query [flags] profile.pb.gz reachable|next FROM TO [SKIPPED...]
+//	pprofsv graph [flags] profile.pb.gz...
+//	pprofsv conform [flags] -model model.yaml profile.pb.gz...
+//	pprofsv infer [flags] profile.pb.gz...
+//	pprofsv diff [flags] old.pb.gz new.pb.gz
+//
+// When several profiles are given, they are merged into one before
+// verification, so a transition seen in any of them counts. Besides pprof
+// profiles, text goroutine dumps (from a panic, SIGQUIT or the goroutine
+// profile with debug=2) are accepted, with the goroutine states as the
+// "state" label, e.g. -labels 'state=~.*Lock', and so are the text outputs
+// of perf script and of the flame graph tools' folded (collapsed) stacks.
+// Execution traces (from runtime/trace or go test -trace), which are the
+// only inputs the before, eventually and never-after assertions can be
+// checked on, are accepted once converted into pprof profiles with the
+// trace2pprof command of the github.com/gaukas/pprofsv/trace module.
+//
+// Every subcommand accepts -pattern and -prefix, which select the functions
+// to build the Verifier with and the prefix to prepend to function names,
+// -labels, which keeps only the samples matching a label selector such
+// as "role=server", and -granularity, which collapses the functions into
+// their receiver types, packages or source files.
+// The check subcommand also accepts -spec to load a spec file and any
+// number of -a flags with inline assertions such as
+// "reachable DeepFunc deepFuncLv5". The graph subcommand renders the
+// reduced call graph in DOT, Mermaid or JSON with -format, highlighting the
+// witnesses of any -a assertions. The conform subcommand compares the
+// observed transitions with a state machine declared in a YAML/JSON model
+// file. The infer subcommand mines a candidate spec or model from the
+// observed transitions, to be reviewed and approved as a baseline; unlike
+// the other subcommands, it keeps the profiles apart to bound the observed
+// shares across them. The diff subcommand reports the functions, direct
+// paths and reachability that changed between two profiles, and the
+// direct paths whose weight changed. Run a subcommand with -h for details.
+//
+// The exit status is 0 on success, 1 if an assertion or query does not
+// hold, a transition violates the model or the call graphs differ, and 2
+// on any other error.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/gaukas/pprofsv"
+	"github.com/google/pprof/profile"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	exitOK     = 0
+	exitFailed = 1
+	exitError  = 2
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// errFailed signals that an assertion or query did not hold. Its details
+// have already been printed.
+var errFailed = errors.New("failed")
+
+type command struct {
+	name     string
+	usage    string
+	run      func(c *commandContext, args []string) error
+	setFlags func(fs *flag.FlagSet, c *commandContext)
+}
+
+// commandContext holds the flags shared by all subcommands and the output
+// streams.
+type commandContext struct {
+	pattern     string
+	prefix      string
+	labels      string
+	granularity string
+
+	specFile   string
+	assertions assertionFlags
+
+	format     string
+	sampleType string
+
+	modelFile string
+
+	tolerance   float64
+	minSamples  int
+	packageName string
+	varName     string
+
+	minShareChange float64
+
+	stdout io.Writer
+	stderr io.Writer
+}
+
+var commands = []command{
+	{
+		name:  "check",
+		usage: "check [flags] profile.pb.gz...",
+		run:   runCheck,
+		setFlags: func(fs *flag.FlagSet, c *commandContext) {
+			fs.StringVar(&c.specFile, "spec", "", "`file` with a YAML/JSON spec; its pattern, prefix, labels and granularity apply unless overridden")
+			fs.Var(&c.assertions, "a", "inline `assertion`, e.g. \"reachable A B\" (repeatable)")
+		},
+	},
+	{
+		name:  "list-functions",
+		usage: "list-functions [flags] profile.pb.gz...",
+		run:   runListFunctions,
+	},
+	{
+		name:  "dump-stacks",
+		usage: "dump-stacks [flags] profile.pb.gz...",
+		run:   runDumpStacks,
+	},
+	{
+		name:  "query",
+		usage: "query [flags] profile.pb.gz reachable|next FROM TO [SKIPPED...]",
+		run:   runQuery,
+	},
+	{
+		name:  "graph",
+		usage: "graph [flags] profile.pb.gz...",
+		run:   runGraph,
+		setFlags: func(fs *flag.FlagSet, c *commandContext) {
+			fs.StringVar(&c.format, "format", "dot", "output `format`: dot, mermaid or json")
+			fs.StringVar(&c.sampleType, "sample-type", "", "sample `type` to weigh edges with, e.g. samples or cpu")
+			fs.Var(&c.assertions, "a", "`assertion` whose witness to highlight, e.g. \"reachable A B\" (repeatable)")
+		},
+	},
+	{
+		name:  "conform",
+		usage: "conform [flags] -model model.yaml profile.pb.gz...",
+		run:   runConform,
+		setFlags: func(fs *flag.FlagSet, c *commandContext) {
+			fs.StringVar(&c.modelFile, "model", "", "`file` with a YAML/JSON model of the allowed transitions")
+		},
+	},
+	{
+		name:  "infer",
+		usage: "infer [flags] profile.pb.gz...",
+		run:   runInfer,
+		setFlags: func(fs *flag.FlagSet, c *commandContext) {
+			fs.StringVar(&c.format, "format", "spec", "output `format`: spec (YAML), model (YAML) or go")
+			fs.StringVar(&c.sampleType, "sample-type", "", "sample `type` to bound the shares in, e.g. samples or cpu")
+			fs.Float64Var(&c.tolerance, "tolerance", 0.05, "`margin` to widen the observed shares by")
+			fs.IntVar(&c.minSamples, "min-samples", 0, "drop the transitions observed in fewer than `n` samples")
+			fs.StringVar(&c.packageName, "package", "main", "`name` of the package of the Go code")
+			fs.StringVar(&c.varName, "name", "spec", "`name` of the variable of the Go code")
+		},
+	},
+	{
+		name:  "diff",
+		usage: "diff [flags] old.pb.gz new.pb.gz",
+		run:   runDiff,
+		setFlags: func(fs *flag.FlagSet, c *commandContext) {
+			fs.StringVar(&c.sampleType, "sample-type", "", "sample `type` to weigh edges with, e.g. samples or cpu")
+			fs.Float64Var(&c.minShareChange, "min-share-change", 0.05, "only report the weight changes whose share changed by at least `delta`")
+		},
+	},
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return exitError
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != args[0] {
+			continue
+		}
+
+		c := &commandContext{stdout: stdout, stderr: stderr}
+		fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+		fs.SetOutput(stderr)
+		fs.Usage = func() {
+			fmt.Fprintf(stderr, "usage: pprofsv %s\n", cmd.usage)
+			fs.PrintDefaults()
+		}
+		fs.StringVar(&c.pattern, "pattern", "", "regular `expression` selecting the functions to verify")
+		fs.StringVar(&c.prefix, "prefix", "", "`prefix` prepended to every function name")
+		fs.StringVar(&c.labels, "labels", "", "label `selector` the samples must match, e.g. \"role=server\"")
+		fs.StringVar(&c.granularity, "granularity", "", "collapse functions into `nodes`: function, receiver, package or file")
+		if cmd.setFlags != nil {
+			cmd.setFlags(fs, c)
+		}
+		if err := fs.Parse(args[1:]); err != nil {
+			return exitError
+		}
+
+		err := cmd.run(c, fs.Args())
+		switch {
+		case err == nil:
+			return exitOK
+		case errors.Is(err, errFailed):
+			return exitFailed
+		default:
+			fmt.Fprintf(stderr, "pprofsv %s: %v\n", cmd.name, err)
+			return exitError
+		}
+	}
+
+	fmt.Fprintf(stderr, "pprofsv: unknown command %q\n", args[0])
+	usage(stderr)
+	return exitError
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "\tpprofsv %s\n", cmd.usage)
+	}
+}
+
+type assertionFlags []pprofsv.Assertion
+
+func (a *assertionFlags) String() string {
+	return fmt.Sprint(*a)
+}
+
+func (a *assertionFlags) Set(s string) error {
+	assertion, err := pprofsv.ParseAssertion(s)
+	if err != nil {
+		return err
+	}
+	*a = append(*a, assertion)
+	return nil
+}
+
+// loadProfile reads a pprof profile or, failing that, a text goroutine dump,
+// perf script output or folded stacks.
+func loadProfile(name string) (*profile.Profile, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	pprof, err := profile.ParseData(data)
+	if err == nil {
+		return pprof, nil
+	}
+	for _, parse := range []func(io.Reader) (*profile.Profile, error){
+		pprofsv.ParseGoroutineDump,
+		pprofsv.ParsePerfScript,
+		pprofsv.ParseFolded,
+	} {
+		if pprof, textErr := parse(bytes.NewReader(data)); textErr == nil {
+			return pprof, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: %w", name, err)
+}
+
+// verifier loads and merges the named profiles and builds a Verifier with
+// the pattern, prefix, labels and granularity flags.
+func (c *commandContext) verifier(profileNames ...string) (*pprofsv.Verifier, error) {
+	if len(profileNames) == 0 {
+		return nil, errors.New("expected at least one profile")
+	}
+
+	pprofs := make([]*profile.Profile, 0, len(profileNames))
+	for _, name := range profileNames {
+		pprof, err := loadProfile(name)
+		if err != nil {
+			return nil, err
+		}
+		pprofs = append(pprofs, pprof)
+	}
+	p := pprofsv.NewProfileFromMany(pprofs...)
+
+	labels, err := pprofsv.ParseLabelSelector(c.labels)
+	if err != nil {
+		return nil, err
+	}
+
+	granularity, err := pprofsv.ParseGranularity(c.granularity)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := p.Verifier(c.pattern, pprofsv.WithLabelSelector(labels), pprofsv.WithGranularity(granularity))
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, fmt.Errorf("no call stacks match pattern %q", c.pattern)
+	}
+	v.SetFunctionPrefix(c.prefix)
+	return v, nil
+}
+
+// trimPrefix removes every occurrence of the function prefix from s.
+func (c *commandContext) trimPrefix(s string) string {
+	if c.prefix == "" {
+		return s
+	}
+	return strings.ReplaceAll(s, c.prefix, "")
+}
+
+func runCheck(c *commandContext, args []string) error {
+	assertions := []pprofsv.Assertion(c.assertions)
+	if c.specFile != "" {
+		spec, err := pprofsv.LoadSpecFile(c.specFile)
+		if err != nil {
+			return err
+		}
+		if c.pattern == "" {
+			c.pattern = spec.Pattern
+		}
+		if c.prefix == "" {
+			c.prefix = spec.Prefix
+		}
+		if c.labels == "" {
+			c.labels = spec.Labels
+		}
+		if c.granularity == "" {
+			c.granularity = string(spec.Granularity)
+		}
+		assertions = append(spec.Assertions, assertions...)
+	}
+	if len(assertions) == 0 {
+		return errors.New("no assertions given, use -spec or -a")
+	}
+
+	v, err := c.verifier(args...)
+	if err != nil {
+		return err
+	}
+
+	report := pprofsv.Evaluate(v, assertions)
+	for _, result := range report.Results {
+		fmt.Fprintln(c.stdout, c.trimPrefix(result.String()))
+	}
+
+	failures := len(report.Failures())
+	fmt.Fprintf(c.stdout, "%d passed, %d failed\n", len(report.Results)-failures, failures)
+	if failures > 0 {
+		return errFailed
+	}
+	return nil
+}
+
+func runListFunctions(c *commandContext, args []string) error {
+	v, err := c.verifier(args...)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range v.Functions() {
+		fmt.Fprintln(c.stdout, c.trimPrefix(name))
+	}
+	return nil
+}
+
+func runDumpStacks(c *commandContext, args []string) error {
+	v, err := c.verifier(args...)
+	if err != nil {
+		return err
+	}
+
+	for i, callStack := range v.DumpCallstack() {
+		if i > 0 {
+			fmt.Fprintln(c.stdout)
+		}
+		for _, name := range callStack {
+			fmt.Fprintf(c.stdout, "\t%s\n", c.trimPrefix(name))
+		}
+	}
+	return nil
+}
+
+func runQuery(c *commandContext, args []string) error {
+	if len(args) < 4 {
+		return errors.New("expected a profile, a query and two functions")
+	}
+
+	v, err := c.verifier(args[0])
+	if err != nil {
+		return err
+	}
+
+	from, to, skipped := args[2], args[3], args[4:]
+	switch args[1] {
+	case "reachable":
+		witness, err := v.CheckReachablePath(from, to, skipped...)
+		if err != nil {
+			return err
+		}
+		if witness == nil {
+			fmt.Fprintln(c.stdout, "false")
+			return errFailed
+		}
+		fmt.Fprintln(c.stdout, "true")
+		fmt.Fprintln(c.stdout, c.trimPrefix(witness.String()))
+	case "next":
+		if len(skipped) > 0 {
+			return errors.New("next does not take skipped functions")
+		}
+		kind, err := v.CheckNextKind(from, to)
+		if err != nil {
+			return err
+		}
+		if kind == 0 {
+			fmt.Fprintln(c.stdout, "false")
+			return errFailed
+		}
+		fmt.Fprintf(c.stdout, "true (%s)\n", kind)
+	default:
+		return fmt.Errorf("unknown query %q", args[1])
+	}
+	return nil
+}
+
+func runGraph(c *commandContext, args []string) error {
+	v, err := c.verifier(args...)
+	if err != nil {
+		return err
+	}
+
+	opts := []pprofsv.ExportOption{pprofsv.WithSampleType(c.sampleType)}
+	for _, result := range pprofsv.Evaluate(v, c.assertions).Results {
+		if result.Error != "" {
+			return errors.New(result.Error)
+		}
+		opts = append(opts, pprofsv.WithHighlight(result.Witness))
+	}
+
+	switch c.format {
+	case "dot":
+		return v.ExportDOT(c.stdout, opts...)
+	case "mermaid":
+		return v.ExportMermaid(c.stdout, opts...)
+	case "json":
+		return v.ExportJSON(c.stdout, opts...)
+	default:
+		return fmt.Errorf("unknown format %q", c.format)
+	}
+}
+
+func runConform(c *commandContext, args []string) error {
+	if c.modelFile == "" {
+		return errors.New("no model given, use -model")
+	}
+	model, err := pprofsv.LoadModelFile(c.modelFile)
+	if err != nil {
+		return err
+	}
+
+	v, err := c.verifier(args...)
+	if err != nil {
+		return err
+	}
+
+	report, err := v.Conformance(model)
+	if err != nil {
+		return err
+	}
+
+	for _, violation := range report.Violations {
+		fmt.Fprintf(c.stdout, "VIOLATION %s -%s-> %s (%d samples)\n", violation.From, violation.Kind, violation.To, len(violation.Samples))
+	}
+	for _, gap := range report.Gaps {
+		fmt.Fprintf(c.stdout, "GAP %s\n", gap)
+	}
+	for _, state := range report.Unreached {
+		fmt.Fprintf(c.stdout, "UNREACHED %s\n", state)
+	}
+	fmt.Fprintf(c.stdout, "%d violations, %d gaps, %d unreached\n", len(report.Violations), len(report.Gaps), len(report.Unreached))
+
+	if !report.Conforms() {
+		return errFailed
+	}
+	return nil
+}
+
+func runInfer(c *commandContext, args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected at least one profile")
+	}
+
+	labels, err := pprofsv.ParseLabelSelector(c.labels)
+	if err != nil {
+		return err
+	}
+
+	profiles := make([]*pprofsv.Profile, 0, len(args))
+	for _, name := range args {
+		pprof, err := loadProfile(name)
+		if err != nil {
+			return err
+		}
+		profiles = append(profiles, pprofsv.NewProfile(pprof).WithLabels(labels))
+	}
+
+	opts := []pprofsv.InferOption{
+		pprofsv.WithShareSampleType(c.sampleType),
+		pprofsv.WithShareTolerance(c.tolerance),
+		pprofsv.WithMinSamples(c.minSamples),
+	}
+	if c.prefix != "" {
+		opts = append(opts, pprofsv.WithInferredPrefix(c.prefix))
+	}
+
+	switch c.format {
+	case "spec", "go":
+		spec, err := pprofsv.InferSpec(c.pattern, profiles, opts...)
+		if err != nil {
+			return err
+		}
+		spec.Labels = c.labels
+		if c.format == "go" {
+			return spec.WriteGo(c.stdout, c.packageName, c.varName)
+		}
+		return writeYAML(c.stdout, spec)
+	case "model":
+		model, err := pprofsv.InferModel(c.pattern, profiles, opts...)
+		if err != nil {
+			return err
+		}
+		return writeYAML(c.stdout, model)
+	default:
+		return fmt.Errorf("unknown format %q", c.format)
+	}
+}
+
+func writeYAML(w io.Writer, v any) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func runDiff(c *commandContext, args []string) error {
+	if len(args) != 2 {
+		return errors.New("expected an old and a new profile")
+	}
+
+	oldVerifier, err := c.verifier(args[0])
+	if err != nil {
+		return err
+	}
+	newVerifier, err := c.verifier(args[1])
+	if err != nil {
+		return err
+	}
+
+	report, err := pprofsv.Diff(oldVerifier, newVerifier,
+		pprofsv.WithDiffSampleType(c.sampleType),
+		pprofsv.WithMinShareChange(c.minShareChange),
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range report.AddedFunctions {
+		fmt.Fprintf(c.stdout, "+ function %s\n", c.trimPrefix(name))
+	}
+	for _, name := range report.RemovedFunctions {
+		fmt.Fprintf(c.stdout, "- function %s\n", c.trimPrefix(name))
+	}
+	for _, edge := range report.AddedEdges {
+		fmt.Fprintf(c.stdout, "+ edge %s -%s-> %s\n", c.trimPrefix(edge.From), edge.Kind, c.trimPrefix(edge.To))
+	}
+	for _, edge := range report.RemovedEdges {
+		fmt.Fprintf(c.stdout, "- edge %s -%s-> %s\n", c.trimPrefix(edge.From), edge.Kind, c.trimPrefix(edge.To))
+	}
+	for _, pair := range report.NewlyReachable {
+		fmt.Fprintf(c.stdout, "+ reachable %s\n", c.trimPrefix(pair.String()))
+	}
+	for _, pair := range report.NoLongerReachable {
+		fmt.Fprintf(c.stdout, "- reachable %s\n", c.trimPrefix(pair.String()))
+	}
+	for _, change := range report.WeightChanges {
+		fmt.Fprintf(c.stdout, "~ share %s -> %s: %.3f -> %.3f (%+.3f)\n",
+			c.trimPrefix(change.From), c.trimPrefix(change.To), change.OldShare, change.NewShare, change.NewShare-change.OldShare)
+	}
+	fmt.Fprintf(c.stdout, "%d/%d functions, %d/%d edges, %d/%d reachable pairs added/removed, %d weight changes\n",
+		len(report.AddedFunctions), len(report.RemovedFunctions),
+		len(report.AddedEdges), len(report.RemovedEdges),
+		len(report.NewlyReachable), len(report.NoLongerReachable),
+		len(report.WeightChanges))
+
+	if !report.Unchanged() {
+		return errFailed
+	}
+	return nil
+}
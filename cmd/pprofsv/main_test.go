@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gaukas/pprofsv"
+	"github.com/google/pprof/profile"
+)
+
+const (
+	testProfile = "../../testdata/pprof.profile"
+	testPrefix  = "github.com/gaukas/pprofsv/dummy.(*Dummy)."
+)
+
+func TestRun(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		args     []string
+		exitCode int
+		contains string
+	}{
+		{
+			name:     "NoCommand",
+			args:     nil,
+			exitCode: exitError,
+		},
+		{
+			name:     "UnknownCommand",
+			args:     []string{"verify"},
+			exitCode: exitError,
+		},
+		{
+			name:     "CheckSpec",
+			args:     []string{"check", "-spec", "../../testdata/dummy.yaml", testProfile},
+			exitCode: exitOK,
+			contains: "11 passed, 0 failed",
+		},
+		{
+			name:     "CheckMerged",
+			args:     []string{"check", "-spec", "../../testdata/dummy.yaml", testProfile, testProfile},
+			exitCode: exitOK,
+			contains: "11 passed, 0 failed",
+		},
+		{
+			name:     "CheckNoProfile",
+			args:     []string{"check", "-spec", "../../testdata/dummy.yaml"},
+			exitCode: exitError,
+		},
+		{
+			name:     "CheckInlineFailed",
+			args:     []string{"check", "-pattern", "dummy", "-prefix", testPrefix, "-a", "reachable DeepFunc deepFuncLv5", "-a", "next DeepFunc deepFuncLv5", testProfile},
+			exitCode: exitFailed,
+			contains: "FAIL next(DeepFunc, deepFuncLv5)",
+		},
+		{
+			name:     "CheckShareFailed",
+			args:     []string{"check", "-pattern", "dummy", "-prefix", testPrefix, "-a", "share BranchFunc branchA 0.9 - cpu", testProfile},
+			exitCode: exitFailed,
+			contains: "FAIL share(BranchFunc, branchA, min=0.9, sample-type=cpu): observed 0.4",
+		},
+		{
+			name:     "CheckUnmatchedLabels",
+			args:     []string{"check", "-spec", "../../testdata/dummy.yaml", "-labels", "role=server", testProfile},
+			exitCode: exitError,
+		},
+		{
+			name:     "CheckRecursionFailed",
+			args:     []string{"check", "-pattern", "dummy", "-prefix", testPrefix, "-a", "no-recursion recursiveFunc", testProfile},
+			exitCode: exitFailed,
+			contains: "FAIL no-recursion(recursiveFunc): counterexample recursiveFuncInnerA -call-> recursiveFuncInnerB -call-> recursiveFuncInnerA",
+		},
+		{
+			name:     "GraphDOT",
+			args:     []string{"graph", "-pattern", "dummy.*[Dd]eep", "-prefix", testPrefix, "-sample-type", "samples", "-a", "reachable DeepFunc deepFuncLv2", testProfile},
+			exitCode: exitOK,
+			contains: `n0 -> n1 [label="748", penwidth=4.99, color=red];`,
+		},
+		{
+			name:     "GraphMermaid",
+			args:     []string{"graph", "-pattern", "dummy.*[Dd]eep", "-prefix", testPrefix, "-format", "mermaid", testProfile},
+			exitCode: exitOK,
+			contains: `n0["DeepFunc"]`,
+		},
+		{
+			name:     "GraphUnknownFormat",
+			args:     []string{"graph", "-pattern", "dummy", "-format", "svg", testProfile},
+			exitCode: exitError,
+		},
+		{
+			name:     "Conform",
+			args:     []string{"conform", "-pattern", "dummy", "-model", "../../testdata/branch.model.yaml", testProfile},
+			exitCode: exitOK,
+			contains: "0 violations, 0 gaps, 0 unreached",
+		},
+		{
+			name:     "ConformViolation",
+			args:     []string{"conform", "-pattern", `\.(BranchFunc|branchA|branchAinner|final)$`, "-model", "../../testdata/branch.model.yaml", testProfile},
+			exitCode: exitError,
+		},
+		{
+			name:     "ConformNoModel",
+			args:     []string{"conform", testProfile},
+			exitCode: exitError,
+		},
+		{
+			name:     "InferSpec",
+			args:     []string{"infer", "-pattern", `\(\*Dummy\)\.([Bb]ranch|final)`, testProfile},
+			exitCode: exitOK,
+			contains: "prefix: github.com/gaukas/pprofsv/dummy.(*Dummy).\nassertions:\n  - kind: next\n    from: BranchFunc\n    to: branchA\n",
+		},
+		{
+			name:     "InferModel",
+			args:     []string{"infer", "-format", "model", "-pattern", `\(\*Dummy\)\.([Bb]ranch|final)`, testProfile},
+			exitCode: exitOK,
+			contains: "initial: BranchFunc",
+		},
+		{
+			name:     "InferGo",
+			args:     []string{"infer", "-format", "go", "-package", "baseline", "-name", "branchSpec", "-pattern", `\(\*Dummy\)\.([Bb]ranch|final)`, testProfile},
+			exitCode: exitOK,
+			contains: "var branchSpec = &pprofsv.Spec{",
+		},
+		{
+			name:     "InferUnknownFormat",
+			args:     []string{"infer", "-format", "xml", "-pattern", "dummy", testProfile},
+			exitCode: exitError,
+		},
+		{
+			name:     "CheckNoAssertions",
+			args:     []string{"check", testProfile},
+			exitCode: exitError,
+		},
+		{
+			name:     "CheckBadAssertion",
+			args:     []string{"check", "-a", "always A B", testProfile},
+			exitCode: exitError,
+		},
+		{
+			name:     "ListFunctions",
+			args:     []string{"list-functions", "-pattern", `dummy\.`, "-prefix", testPrefix, testProfile},
+			exitCode: exitOK,
+			contains: "\ndeepFuncLv5\n",
+		},
+		{
+			name:     "DumpStacks",
+			args:     []string{"dump-stacks", "-pattern", `dummy\.`, "-prefix", testPrefix, testProfile},
+			exitCode: exitOK,
+			contains: "\tdeepFuncLv1\n\tDeepFunc\n",
+		},
+		{
+			name:     "QueryReachable",
+			args:     []string{"query", "-pattern", "dummy", "-prefix", testPrefix, testProfile, "reachable", "DeepFunc", "deepFuncLv2"},
+			exitCode: exitOK,
+			contains: "DeepFunc -call-> deepFuncLv1 -call-> deepFuncLv2",
+		},
+		{
+			name:     "QueryNotReachable",
+			args:     []string{"query", "-pattern", "dummy", "-prefix", testPrefix, testProfile, "reachable", "deepFuncLv2", "DeepFunc"},
+			exitCode: exitFailed,
+			contains: "false",
+		},
+		{
+			name:     "QueryNext",
+			args:     []string{"query", "-pattern", "dummy", "-prefix", testPrefix, testProfile, "next", "DeepFunc", "deepFuncLv1"},
+			exitCode: exitOK,
+			contains: "true (call)",
+		},
+		{
+			name:     "QueryGranularity",
+			args:     []string{"query", "-pattern", "dummy", "-prefix", "github.com/gaukas/pprofsv/", "-granularity", "package", testProfile, "next", "dummy_test", "dummy"},
+			exitCode: exitOK,
+			contains: "true (call)",
+		},
+		{
+			name:     "QueryUnknownGranularity",
+			args:     []string{"query", "-pattern", "dummy", "-granularity", "module", testProfile, "next", "DeepFunc", "deepFuncLv1"},
+			exitCode: exitError,
+		},
+		{
+			name:     "QueryUnknownFunction",
+			args:     []string{"query", "-pattern", "dummy", "-prefix", testPrefix, testProfile, "next", "DeepFunc", "deepFuncLv9"},
+			exitCode: exitError,
+		},
+		{
+			name:     "DiffSame",
+			args:     []string{"diff", "-pattern", "dummy", testProfile, testProfile},
+			exitCode: exitOK,
+			contains: "0/0 functions, 0/0 edges, 0/0 reachable pairs added/removed, 0 weight changes",
+		},
+		{
+			name:     "DiffOneProfile",
+			args:     []string{"diff", "-pattern", "dummy", testProfile},
+			exitCode: exitError,
+		},
+		{
+			name:     "CheckGoroutineDump",
+			args:     []string{"check", "-pattern", `^main\.|\.Read$`, "-labels", "state!=running", "-a", "reachable main.(*Server).readLocked internal/poll.(*FD).Read", "-a", "not-reachable main.(*Server).flush net.(*conn).Read", "../../testdata/goroutines.txt"},
+			exitCode: exitOK,
+			contains: "2 passed, 0 failed",
+		},
+		{
+			name:     "CheckFolded",
+			args:     []string{"check", "-pattern", ".", "-a", "reachable serve write", "-a", "not-reachable init handle", "../../testdata/stacks.folded"},
+			exitCode: exitOK,
+			contains: "2 passed, 0 failed",
+		},
+		{
+			name:     "CheckSpecLabels",
+			args:     []string{"check", "-spec", "../../testdata/envoy.yaml", "../../testdata/perf.script"},
+			exitCode: exitOK,
+			contains: "2 passed, 0 failed",
+		},
+		{
+			name:     "QueryPerfScript",
+			args:     []string{"query", "-pattern", "^Envoy::", "-prefix", "Envoy::", "-labels", "comm=envoy", "../../testdata/perf.script", "next", "Http::ConnectionManagerImpl::onData", "Buffer::OwnedImpl::add"},
+			exitCode: exitOK,
+		},
+		{
+			name:     "NotAProfile",
+			args:     []string{"list-functions", "../../testdata/dummy.yaml"},
+			exitCode: exitError,
+		},
+		{
+			name:     "MissingProfile",
+			args:     []string{"list-functions", "testdata/missing.profile"},
+			exitCode: exitError,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if exitCode := run(tc.args, &stdout, &stderr); exitCode != tc.exitCode {
+				t.Errorf("expected exit code %d, got %d\nstdout:\n%s\nstderr:\n%s", tc.exitCode, exitCode, stdout.String(), stderr.String())
+			}
+
+			if !strings.Contains(stdout.String(), tc.contains) {
+				t.Errorf("expected output to contain %q, got:\n%s", tc.contains, stdout.String())
+			}
+		})
+	}
+}
+
+func TestRunDiff(t *testing.T) {
+	pprof, err := loadProfile(testProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the new release never takes branch B
+	pprof.Sample = slices.DeleteFunc(pprof.Sample, func(sample *profile.Sample) bool {
+		for _, location := range sample.Location {
+			for _, line := range location.Line {
+				if line.Function.Name == testPrefix+"branchB" {
+					return true
+				}
+			}
+		}
+		return false
+	})
+	newProfile := filepath.Join(t.TempDir(), "new.profile")
+	file, err := os.Create(newProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pprof.Write(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if exitCode := run([]string{"diff", "-pattern", "dummy", "-prefix", testPrefix, testProfile, newProfile}, &stdout, &stderr); exitCode != exitFailed {
+		t.Errorf("expected exit code %d, got %d\nstderr:\n%s", exitFailed, exitCode, stderr.String())
+	}
+	for _, want := range []string{
+		"- reachable BranchFunc -> branchBinner\n",
+		"- edge BranchFunc -call-> branchB\n",
+		"~ share BranchFunc -> branchA: ",
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, stdout.String())
+		}
+	}
+}
+
+// TestRunTrace checks a profile converted from an execution trace, where
+// every sample is an event labeled with its goroutine and time.
+func TestRunTrace(t *testing.T) {
+	open := &profile.Function{ID: 1, Name: "conn.open"}
+	use := &profile.Function{ID: 2, Name: "conn.use"}
+	pprof := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "events", Unit: "count"}},
+		Function:   []*profile.Function{open, use},
+		Location: []*profile.Location{
+			{ID: 1, Line: []profile.Line{{Function: open}}},
+			{ID: 2, Line: []profile.Line{{Function: use}}},
+		},
+	}
+	// goroutine 1 opens, uses and opens again, from time 1 since the pprof
+	// format drops the numeric labels of 0
+	for i, location := range []*profile.Location{pprof.Location[0], pprof.Location[1], pprof.Location[0]} {
+		pprof.Sample = append(pprof.Sample, &profile.Sample{
+			Location: []*profile.Location{location},
+			Value:    []int64{1},
+			NumLabel: map[string][]int64{pprofsv.GoroutineIDLabel: {1}, pprofsv.TraceTimeLabel: {int64(i + 1)}},
+		})
+	}
+
+	name := filepath.Join(t.TempDir(), "trace.pb.gz")
+	file, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pprof.Write(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"check", "-pattern", "^conn\\.", "-prefix", "conn.", "-a", "before open use", "-a", "never-after open use", name}
+	if exitCode := run(args, &stdout, &stderr); exitCode != exitFailed {
+		t.Errorf("expected exit code %d, got %d\nstderr:\n%s", exitFailed, exitCode, stderr.String())
+	}
+	for _, want := range []string{
+		"PASS before(open, use)\n",
+		"FAIL never-after(open, use): counterexample goroutine ",
+		"1 passed, 1 failed",
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, stdout.String())
+		}
+	}
+}
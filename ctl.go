@@ -0,0 +1,590 @@
+package pprofsv
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Formula is a Computation Tree Logic (CTL) formula over the call graph
+// of a Verifier, where every function is a state and every direct path
+// is a transition.
+//
+// Formulas are written with the following grammar, from the lowest to the
+// highest precedence:
+//
+//	f -> g          implication (right-associative)
+//	f || g          disjunction, also f | g
+//	f && g          conjunction, also f & g
+//	!f              negation
+//	EX f, AX f      f holds in some/every next state
+//	EF f, AF f      f holds eventually on some/every path
+//	EG f, AG f      f holds globally on some/every path
+//	E[f U g]        f holds until g holds, on some path
+//	A[f U g]        f holds until g holds, on every path
+//	(f)             grouping
+//	true, false     constants
+//	name            atomic proposition: the state is the named function
+//	"name"          the same, for names with special characters
+//	/regexp/        atomic proposition: the state's function name matches
+//
+// Function names are resolved with the Verifier's function prefix, while
+// regular expressions are matched against full function names.
+//
+// Paths are maximal: they are either infinite or end at a function that
+// calls no other function (a terminal state). Hence at a terminal state,
+// EX f is false, AX f is vacuously true, AF f and EG f hold exactly when
+// f holds, and A[f U g] holds exactly when g holds.
+type Formula interface {
+	// String returns the formula in a fully parenthesized form which can
+	// be parsed back by ParseCTL.
+	String() string
+
+	eval(m *ctlModel) (bitset, error)
+}
+
+// ctlModel is the Kripke structure a Formula is evaluated on.
+type ctlModel struct {
+	n        int
+	succ     []bitset // succ[i] is the set of direct successors of state i
+	terminal bitset   // states without any successor
+
+	// resolve returns the states an atomic proposition holds in.
+	resolve func(a *ctlAtom) (bitset, error)
+}
+
+// all returns the set of all states.
+func (m *ctlModel) all() bitset {
+	return newBitset(m.n).complement(m.n)
+}
+
+// preExists returns the states with at least one successor in f.
+func (m *ctlModel) preExists(f bitset) bitset {
+	result := newBitset(m.n)
+	for i := 0; i < m.n; i++ {
+		if m.succ[i].intersects(f) {
+			result.set(i)
+		}
+	}
+	return result
+}
+
+// preForall returns the states whose successors are all in f.
+func (m *ctlModel) preForall(f bitset) bitset {
+	result := newBitset(m.n)
+	for i := 0; i < m.n; i++ {
+		if m.succ[i].subsetOf(f) {
+			result.set(i)
+		}
+	}
+	return result
+}
+
+// fixpoint iterates step from start until it stabilizes.
+func (m *ctlModel) fixpoint(start bitset, step func(z bitset) bitset) bitset {
+	z := start
+	for {
+		next := step(z)
+		if next.equal(z) {
+			return z
+		}
+		z = next
+	}
+}
+
+type ctlConst bool
+
+func (c ctlConst) String() string {
+	if c {
+		return "true"
+	}
+	return "false"
+}
+
+func (c ctlConst) eval(m *ctlModel) (bitset, error) {
+	if c {
+		return m.all(), nil
+	}
+	return newBitset(m.n), nil
+}
+
+type ctlAtom struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+func (a *ctlAtom) String() string {
+	if a.pattern != nil {
+		return "/" + strings.ReplaceAll(a.pattern.String(), "/", `\/`) + "/"
+	}
+	return fmt.Sprintf("%q", a.name)
+}
+
+func (a *ctlAtom) eval(m *ctlModel) (bitset, error) {
+	return m.resolve(a)
+}
+
+type ctlNot struct {
+	f Formula
+}
+
+func (n *ctlNot) String() string {
+	return "!" + n.f.String()
+}
+
+func (n *ctlNot) eval(m *ctlModel) (bitset, error) {
+	f, err := n.f.eval(m)
+	if err != nil {
+		return nil, err
+	}
+	return f.complement(m.n), nil
+}
+
+type ctlBinary struct {
+	op   string // "&&", "||" or "->"
+	l, r Formula
+}
+
+func (b *ctlBinary) String() string {
+	return fmt.Sprintf("(%s %s %s)", b.l, b.op, b.r)
+}
+
+func (b *ctlBinary) eval(m *ctlModel) (bitset, error) {
+	l, err := b.l.eval(m)
+	if err != nil {
+		return nil, err
+	}
+	r, err := b.r.eval(m)
+	if err != nil {
+		return nil, err
+	}
+
+	switch b.op {
+	case "&&":
+		l.and(r)
+	case "||":
+		l.or(r)
+	case "->":
+		l = l.complement(m.n)
+		l.or(r)
+	}
+	return l, nil
+}
+
+type ctlTemporal struct {
+	op string // "EX", "AX", "EF", "AF", "EG" or "AG"
+	f  Formula
+}
+
+func (t *ctlTemporal) String() string {
+	return fmt.Sprintf("%s %s", t.op, t.f)
+}
+
+func (t *ctlTemporal) eval(m *ctlModel) (bitset, error) {
+	f, err := t.f.eval(m)
+	if err != nil {
+		return nil, err
+	}
+
+	switch t.op {
+	case "EX":
+		return m.preExists(f), nil
+	case "AX":
+		return m.preForall(f), nil
+	case "EF":
+		// EF f = mu Z. f || EX Z
+		return m.fixpoint(f, func(z bitset) bitset {
+			next := m.preExists(z)
+			next.or(f)
+			return next
+		}), nil
+	case "AF":
+		// AF f = mu Z. f || (!terminal && AX Z)
+		return m.fixpoint(f, func(z bitset) bitset {
+			next := m.preForall(z)
+			next.andNot(m.terminal)
+			next.or(f)
+			return next
+		}), nil
+	case "EG":
+		// EG f = nu Z. f && (terminal || EX Z)
+		return m.fixpoint(f, func(z bitset) bitset {
+			next := m.preExists(z)
+			next.or(m.terminal)
+			next.and(f)
+			return next
+		}), nil
+	case "AG":
+		// AG f = nu Z. f && AX Z
+		return m.fixpoint(f, func(z bitset) bitset {
+			next := m.preForall(z)
+			next.and(f)
+			return next
+		}), nil
+	}
+	return nil, fmt.Errorf("unknown temporal operator %s", t.op)
+}
+
+type ctlUntil struct {
+	universal bool
+	l, r      Formula
+}
+
+func (u *ctlUntil) String() string {
+	q := "E"
+	if u.universal {
+		q = "A"
+	}
+	return fmt.Sprintf("%s[%s U %s]", q, u.l, u.r)
+}
+
+func (u *ctlUntil) eval(m *ctlModel) (bitset, error) {
+	l, err := u.l.eval(m)
+	if err != nil {
+		return nil, err
+	}
+	r, err := u.r.eval(m)
+	if err != nil {
+		return nil, err
+	}
+
+	if !u.universal {
+		// E[l U r] = mu Z. r || (l && EX Z)
+		return m.fixpoint(r, func(z bitset) bitset {
+			next := m.preExists(z)
+			next.and(l)
+			next.or(r)
+			return next
+		}), nil
+	}
+
+	// A[l U r] = mu Z. r || (l && !terminal && AX Z)
+	return m.fixpoint(r, func(z bitset) bitset {
+		next := m.preForall(z)
+		next.andNot(m.terminal)
+		next.and(l)
+		next.or(r)
+		return next
+	}), nil
+}
+
+// ParseCTL parses a CTL formula. See Formula for the syntax.
+func ParseCTL(s string) (Formula, error) {
+	tokens, err := tokenizeCTL(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &ctlParser{tokens: tokens}
+	f, err := p.parseImplies()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("ctl: unexpected %q", p.tokens[p.pos].text)
+	}
+	return f, nil
+}
+
+// MustParseCTL is like ParseCTL but panics if the formula cannot be parsed.
+func MustParseCTL(s string) Formula {
+	f, err := ParseCTL(s)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+type ctlTokenKind uint8
+
+const (
+	ctlTokenSymbol ctlTokenKind = iota // operators, brackets and keywords
+	ctlTokenName
+	ctlTokenRegexp
+)
+
+type ctlToken struct {
+	kind ctlTokenKind
+	text string
+}
+
+func isCTLNameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '$'
+}
+
+func tokenizeCTL(s string) ([]ctlToken, error) {
+	var tokens []ctlToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.HasPrefix(s[i:], "&&"), strings.HasPrefix(s[i:], "||"), strings.HasPrefix(s[i:], "->"):
+			tokens = append(tokens, ctlToken{ctlTokenSymbol, s[i : i+2]})
+			i += 2
+		case c == '&' || c == '|':
+			tokens = append(tokens, ctlToken{ctlTokenSymbol, string([]byte{c, c})})
+			i++
+		case strings.IndexByte("!()[]", c) >= 0:
+			tokens = append(tokens, ctlToken{ctlTokenSymbol, string(c)})
+			i++
+		case c == '"':
+			end := i + 1
+			for ; end < len(s) && s[end] != '"'; end++ {
+				if s[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(s) {
+				return nil, fmt.Errorf("ctl: unterminated string at offset %d", i)
+			}
+			var name string
+			if _, err := fmt.Sscanf(s[i:end+1], "%q", &name); err != nil {
+				return nil, fmt.Errorf("ctl: invalid string at offset %d: %w", i, err)
+			}
+			tokens = append(tokens, ctlToken{ctlTokenName, name})
+			i = end + 1
+		case c == '/':
+			var b strings.Builder
+			end := i + 1
+			for ; end < len(s) && s[end] != '/'; end++ {
+				if s[end] == '\\' && end+1 < len(s) && s[end+1] == '/' {
+					end++
+				}
+				b.WriteByte(s[end])
+			}
+			if end >= len(s) {
+				return nil, fmt.Errorf("ctl: unterminated regular expression at offset %d", i)
+			}
+			tokens = append(tokens, ctlToken{ctlTokenRegexp, b.String()})
+			i = end + 1
+		default:
+			end := i
+			for _, r := range s[i:] {
+				if !isCTLNameRune(r) {
+					break
+				}
+				end += len(string(r))
+			}
+			if end == i {
+				return nil, fmt.Errorf("ctl: unexpected %q at offset %d", s[i:i+1], i)
+			}
+			word := s[i:end]
+			switch word {
+			case "EX", "AX", "EF", "AF", "EG", "AG", "U", "true", "false":
+				tokens = append(tokens, ctlToken{ctlTokenSymbol, word})
+			case "E", "A":
+				if end < len(s) && s[end] == '[' {
+					tokens = append(tokens, ctlToken{ctlTokenSymbol, word})
+				} else {
+					tokens = append(tokens, ctlToken{ctlTokenName, word})
+				}
+			default:
+				tokens = append(tokens, ctlToken{ctlTokenName, word})
+			}
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+type ctlParser struct {
+	tokens []ctlToken
+	pos    int
+}
+
+// accept consumes the next token if it is the given symbol.
+func (p *ctlParser) accept(symbol string) bool {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == ctlTokenSymbol && p.tokens[p.pos].text == symbol {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *ctlParser) expect(symbol string) error {
+	if !p.accept(symbol) {
+		if p.pos < len(p.tokens) {
+			return fmt.Errorf("ctl: expected %q, got %q", symbol, p.tokens[p.pos].text)
+		}
+		return fmt.Errorf("ctl: expected %q, got end of formula", symbol)
+	}
+	return nil
+}
+
+func (p *ctlParser) parseImplies() (Formula, error) {
+	l, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.accept("->") {
+		r, err := p.parseImplies()
+		if err != nil {
+			return nil, err
+		}
+		return &ctlBinary{op: "->", l: l, r: r}, nil
+	}
+	return l, nil
+}
+
+func (p *ctlParser) parseOr() (Formula, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = &ctlBinary{op: "||", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *ctlParser) parseAnd() (Formula, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = &ctlBinary{op: "&&", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *ctlParser) parseUnary() (Formula, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, errors.New("ctl: unexpected end of formula")
+	}
+
+	token := p.tokens[p.pos]
+	p.pos++
+	switch token.kind {
+	case ctlTokenName:
+		return &ctlAtom{name: token.text}, nil
+	case ctlTokenRegexp:
+		pattern, err := regexp.Compile(token.text)
+		if err != nil {
+			return nil, fmt.Errorf("ctl: %w", err)
+		}
+		return &ctlAtom{pattern: pattern}, nil
+	}
+
+	switch token.text {
+	case "true":
+		return ctlConst(true), nil
+	case "false":
+		return ctlConst(false), nil
+	case "!":
+		f, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &ctlNot{f: f}, nil
+	case "(":
+		f, err := p.parseImplies()
+		if err != nil {
+			return nil, err
+		}
+		return f, p.expect(")")
+	case "EX", "AX", "EF", "AF", "EG", "AG":
+		f, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &ctlTemporal{op: token.text, f: f}, nil
+	case "E", "A":
+		if err := p.expect("["); err != nil {
+			return nil, err
+		}
+		l, err := p.parseImplies()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("U"); err != nil {
+			return nil, err
+		}
+		r, err := p.parseImplies()
+		if err != nil {
+			return nil, err
+		}
+		return &ctlUntil{universal: token.text == "A", l: l, r: r}, p.expect("]")
+	}
+	return nil, fmt.Errorf("ctl: unexpected %q", token.text)
+}
+
+// ctlModel builds the Kripke structure of the Verifier's call graph.
+func (v *Verifier) ctlModel() *ctlModel {
+	v.path.rw.RLock()
+	defer v.path.rw.RUnlock()
+
+	m := &ctlModel{
+		n:        v.path.n,
+		succ:     make([]bitset, v.path.n),
+		terminal: newBitset(v.path.n),
+	}
+	for i, successors := range v.path.directPaths {
+		m.succ[i] = successors.clone()
+		if successors.empty() {
+			m.terminal.set(i)
+		}
+	}
+
+	m.resolve = func(a *ctlAtom) (bitset, error) {
+		states := newBitset(m.n)
+		if a.pattern == nil {
+			pseudoId, err := v.lookup(a.name)
+			if err != nil {
+				return nil, err
+			}
+			states.set(pseudoId)
+			return states, nil
+		}
+
+		for id, pseudoId := range v.functionIdPseudoMap {
+			if a.pattern.MatchString(v.masterProfile.functionIdMap[id]) {
+				states.set(int(pseudoId))
+			}
+		}
+		return states, nil
+	}
+	return m
+}
+
+// CheckCTL checks whether the CTL formula f holds at function `from`.
+func (v *Verifier) CheckCTL(from string, f Formula) (bool, error) {
+	fromId, err := v.lookup(from)
+	if err != nil {
+		return false, err
+	}
+
+	states, err := f.eval(v.ctlModel())
+	if err != nil {
+		return false, err
+	}
+	return states.has(fromId), nil
+}
+
+// SatisfyingCTL returns the sorted full names of the functions at which
+// the CTL formula f holds.
+func (v *Verifier) SatisfyingCTL(f Formula) ([]string, error) {
+	states, err := f.eval(v.ctlModel())
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	states.forEach(func(i int) {
+		names = append(names, v.masterProfile.functionIdMap[v.pseudoFunctionIdMap[uint64(i)]])
+	})
+	sort.Strings(names)
+	return names, nil
+}
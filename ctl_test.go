@@ -0,0 +1,157 @@
+package pprofsv_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/gaukas/pprofsv"
+)
+
+func TestCTL(t *testing.T) {
+	verifier, err := pprofsv.NewProfile(syntheticProfile(
+		"serve;handshake;read;close",
+		"serve;handshake;write;close",
+		"serve;handshake;fail;panic",
+		"serve;loop;spin;loop",
+	)).Verifier("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		from    string
+		formula string
+		holds   bool
+	}{
+		{"serve", "EF close", true},
+		{"serve", "AF close", false},
+		{"handshake", "AX (read || write || fail)", true},
+		{"handshake", "EX read", true},
+		{"handshake", "EX close", false},
+		{"handshake", "E[!panic U close]", true},
+		{"handshake", "A[!panic U close]", false},
+		{"read", "A[!panic U close]", true},
+		{"serve", "AG !panic", false},
+		{"read", "AG !panic", true},
+		{"handshake", "EF /^(read|write)$/ && !EF serve", true},
+		// close is terminal: no next state, and every path ends there
+		{"close", "EX true", false},
+		{"close", "AX false", true},
+		{"close", "AF close && EG close", true},
+		{"close", "A[false U close]", true},
+		// loop <-> spin never terminates
+		{"loop", "EG (loop | spin)", true},
+		{"loop", "AG (loop | spin)", true},
+		{"loop", "AF close", false},
+		{"serve", "EX loop -> EG !close", true},
+		{"serve", "EG !close", true},
+	} {
+		holds, err := verifier.CheckCTL(tc.from, pprofsv.MustParseCTL(tc.formula))
+		if err != nil {
+			t.Errorf("%s at %s: %v", tc.formula, tc.from, err)
+		} else if holds != tc.holds {
+			t.Errorf("%s at %s should be %t", tc.formula, tc.from, tc.holds)
+		}
+	}
+
+	satisfying, err := verifier.SatisfyingCTL(pprofsv.MustParseCTL("EX close"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(satisfying, []string{"read", "write"}) {
+		t.Errorf("EX close should hold at [read write], got %v", satisfying)
+	}
+
+	if _, err := verifier.CheckCTL("serve", pprofsv.MustParseCTL("EF clsoe")); !errors.Is(err, pprofsv.ErrFunctionNotFound) {
+		t.Errorf("clsoe should not be found, got %v", err)
+	}
+}
+
+func TestCTLDummy(t *testing.T) {
+	pprof := loadProfile(t, "testdata/pprof.profile")
+
+	verifier, err := pprofsv.NewProfile(pprof).Verifier(`dummy\.`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier.SetFunctionPrefix("github.com/gaukas/pprofsv/dummy.(*Dummy).")
+
+	// every level of DeepFunc may also end in sleep
+	holds, err := verifier.CheckCTL("DeepFunc", pprofsv.MustParseCTL("AF final"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holds {
+		t.Errorf("DeepFunc should not reach final on every path")
+	}
+
+	// without sleep, every path from DeepFunc goes through deepFuncLv5 to final
+	deep, err := verifier.SubVerifier(`\.(DeepFunc|deepFuncLv[1-5]|final)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deep.SetFunctionPrefix("github.com/gaukas/pprofsv/dummy.(*Dummy).")
+
+	holds, err = deep.CheckCTL("DeepFunc", pprofsv.MustParseCTL("A[!final U deepFuncLv5] && AF final"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !holds {
+		t.Errorf("DeepFunc should reach deepFuncLv5 before final on every path")
+	}
+
+	// BranchFunc may take either branch
+	holds, err = verifier.CheckCTL("BranchFunc", pprofsv.MustParseCTL("EX branchA && EX branchB && AX (branchA || branchB)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !holds {
+		t.Errorf("BranchFunc should branch to exactly branchA and branchB")
+	}
+}
+
+func TestParseCTL(t *testing.T) {
+	for _, formula := range []string{
+		"a",
+		`"github.com/gaukas/pprofsv/dummy.(*Dummy).final"`,
+		"/^runtime\\./",
+		"!a && b || c -> d -> e",
+		"EX AX EF AF EG AG a",
+		"A[a U E[b U c]]",
+		"(a | b) & !(c)",
+		"A && E",
+	} {
+		f, err := pprofsv.ParseCTL(formula)
+		if err != nil {
+			t.Errorf("%s: %v", formula, err)
+			continue
+		}
+
+		// String must round-trip
+		g, err := pprofsv.ParseCTL(f.String())
+		if err != nil {
+			t.Errorf("%s: reparsing %s: %v", formula, f, err)
+		} else if g.String() != f.String() {
+			t.Errorf("%s: %s reparsed as %s", formula, f, g)
+		}
+	}
+
+	for _, formula := range []string{
+		"",
+		"a &&",
+		"(a",
+		"E[a U b",
+		"A[a b]",
+		"EX",
+		`"a`,
+		"/a",
+		"/(/",
+		"a b",
+		"a # b",
+	} {
+		if f, err := pprofsv.ParseCTL(formula); err == nil {
+			t.Errorf("%q should not parse, got %s", formula, f)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package pprofsv
+
+import (
+	"regexp"
+	"sort"
+)
+
+// StronglyConnectedComponents returns the strongly connected components of
+// the Verifier's call graph: the maximal sets of functions that can all
+// reach each other. Every function is in exactly one component, which is
+// a singleton unless the function is (mutually) recursive.
+//
+// Each component is sorted by full function name, and the components are
+// sorted by their first name.
+func (v *Verifier) StronglyConnectedComponents() [][]string {
+	return v.components(false)
+}
+
+// Cycles is like StronglyConnectedComponents, but only returns the
+// components containing a cycle, i.e., the sets of mutually recursive
+// functions and the directly recursive functions.
+func (v *Verifier) Cycles() [][]string {
+	return v.components(true)
+}
+
+func (v *Verifier) components(cyclicOnly bool) [][]string {
+	var components [][]string
+	for _, component := range v.path.StronglyConnectedComponents() {
+		if cyclicOnly && len(component) == 1 && !v.path.HasDirectPath(component[0], component[0]) {
+			continue
+		}
+		components = append(components, v.names(component))
+	}
+	sort.Slice(components, func(i, j int) bool {
+		return components[i][0] < components[j][0]
+	})
+	return components
+}
+
+// SelfLoops returns the sorted full names of the functions that directly
+// call themselves.
+func (v *Verifier) SelfLoops() []string {
+	var selfLoops []int
+	for i := 0; i < v.path.n; i++ {
+		if v.path.HasDirectPath(i, i) {
+			selfLoops = append(selfLoops, i)
+		}
+	}
+	return v.names(selfLoops)
+}
+
+// FindRecursion looks for a function whose full name matches the regular
+// expression and which lies on a cycle, i.e., that may call itself either
+// directly or through other functions. It returns the cycle as a Witness
+// from the function back to itself, or nil if there is no such function.
+//
+// If several functions match, the cycle of the first one by name is
+// returned.
+func (v *Verifier) FindRecursion(pattern string) (*Witness, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var recursive []int
+	for _, component := range v.path.StronglyConnectedComponents() {
+		if len(component) == 1 && !v.path.HasDirectPath(component[0], component[0]) {
+			continue
+		}
+		for _, pseudoId := range component {
+			if re.MatchString(v.name(pseudoId)) {
+				recursive = append(recursive, pseudoId)
+			}
+		}
+	}
+	if len(recursive) == 0 {
+		return nil, nil
+	}
+
+	first := recursive[0]
+	for _, pseudoId := range recursive[1:] {
+		if v.name(pseudoId) < v.name(first) {
+			first = pseudoId
+		}
+	}
+	return v.witness(v.path.FindPath(first, first)), nil
+}
+
+// name returns the full name of the function with the given pseudoID.
+func (v *Verifier) name(pseudoId int) string {
+	return v.masterProfile.functionIdMap[v.pseudoFunctionIdMap[uint64(pseudoId)]]
+}
+
+// names returns the sorted full names of the functions with the given
+// pseudoIDs.
+func (v *Verifier) names(pseudoIds []int) []string {
+	names := make([]string, 0, len(pseudoIds))
+	for _, pseudoId := range pseudoIds {
+		names = append(names, v.name(pseudoId))
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,74 @@
+package pprofsv_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/gaukas/pprofsv"
+)
+
+func TestVerifierCycles(t *testing.T) {
+	pprof := loadProfile(t, "testdata/pprof.profile")
+
+	verifier, err := pprofsv.NewProfile(pprof).Verifier("dummy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const prefix = "github.com/gaukas/pprofsv/dummy.(*Dummy)."
+	expected := [][]string{{prefix + "recursiveFuncInnerA", prefix + "recursiveFuncInnerB"}}
+	if cycles := verifier.Cycles(); !slices.EqualFunc(cycles, expected, slices.Equal[[]string]) {
+		t.Errorf("expected cycles %v, got %v", expected, cycles)
+	}
+
+	// every function is in exactly one component
+	count := 0
+	for _, component := range verifier.StronglyConnectedComponents() {
+		count += len(component)
+	}
+	if functions := len(verifier.Functions()); count != functions {
+		t.Errorf("components cover %d functions, expected %d", count, functions)
+	}
+
+	// a loop is not recursion
+	if selfLoops := verifier.SelfLoops(); len(selfLoops) != 0 {
+		t.Errorf("expected no self loops, got %v", selfLoops)
+	}
+	if witness, err := verifier.FindRecursion(`\.(LoopFunc|loopFuncInner)$`); err != nil || witness != nil {
+		t.Errorf("expected no recursion in LoopFunc, got %v, %v", witness, err)
+	}
+
+	witness, err := verifier.FindRecursion(`\.recursiveFuncInnerB$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if witness == nil {
+		t.Fatalf("expected recursion in recursiveFuncInnerB")
+	}
+	if len(witness.Functions) != 3 || witness.Functions[0] != witness.Functions[2] || witness.Functions[1] != prefix+"recursiveFuncInnerA" {
+		t.Errorf("unexpected witness %v", witness)
+	}
+
+	if _, err := verifier.FindRecursion(`(`); err == nil {
+		t.Errorf("expected an error for an invalid pattern")
+	}
+}
+
+func TestVerifierSelfLoops(t *testing.T) {
+	verifier, err := pprofsv.NewProfile(syntheticProfile("main;walk;walk;walk;visit", "main;visit")).Verifier("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if selfLoops := verifier.SelfLoops(); !slices.Equal(selfLoops, []string{"walk"}) {
+		t.Errorf("expected walk to be the only self loop, got %v", selfLoops)
+	}
+
+	witness, err := verifier.FindRecursion(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if witness == nil || witness.String() != "walk -call-> walk" {
+		t.Errorf("expected walk -call-> walk, got %v", witness)
+	}
+}
@@ -0,0 +1,267 @@
+package pprofsv
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// DiffReport is the difference between the call graphs of two Verifiers,
+// e.g. built with the same name pattern from the profiles of two releases.
+// Functions are identified by their full names, or, if several functions
+// of either Verifier share a name, e.g. in two binaries, by their
+// qualified FunctionKey.
+type DiffReport struct {
+	// AddedFunctions and RemovedFunctions are the functions that are only
+	// in the new and only in the old Verifier.
+	AddedFunctions   []string `yaml:"added-functions,omitempty" json:"added-functions,omitempty"`
+	RemovedFunctions []string `yaml:"removed-functions,omitempty" json:"removed-functions,omitempty"`
+
+	// AddedEdges and RemovedEdges are the direct paths that are only in
+	// the new and only in the old Verifier, weighed in that Verifier.
+	AddedEdges   []DiffEdge `yaml:"added-edges,omitempty" json:"added-edges,omitempty"`
+	RemovedEdges []DiffEdge `yaml:"removed-edges,omitempty" json:"removed-edges,omitempty"`
+
+	// NewlyReachable and NoLongerReachable are the pairs of functions, in
+	// both Verifiers, where the second one became reachable or stopped
+	// being reachable from the first one. Pairs involving an added or a
+	// removed function are not listed.
+	NewlyReachable    []FunctionPair `yaml:"newly-reachable,omitempty" json:"newly-reachable,omitempty"`
+	NoLongerReachable []FunctionPair `yaml:"no-longer-reachable,omitempty" json:"no-longer-reachable,omitempty"`
+
+	// WeightChanges are the direct paths in both Verifiers whose share
+	// changed. See WithMinShareChange.
+	WeightChanges []WeightChange `yaml:"weight-changes,omitempty" json:"weight-changes,omitempty"`
+
+	// SampleType is the sample type the weights are in, or empty if
+	// either Verifier has no sample values.
+	SampleType string `yaml:"sample-type,omitempty" json:"sample-type,omitempty"`
+}
+
+// DiffEdge is a direct path that was added or removed.
+type DiffEdge struct {
+	From   string   `yaml:"from" json:"from"`
+	To     string   `yaml:"to" json:"to"`
+	Kind   EdgeKind `yaml:"kind" json:"kind"`
+	Weight int64    `yaml:"weight,omitempty" json:"weight,omitempty"`
+}
+
+// FunctionPair is an ordered pair of functions.
+type FunctionPair struct {
+	From string `yaml:"from" json:"from"`
+	To   string `yaml:"to" json:"to"`
+}
+
+func (p FunctionPair) String() string {
+	return fmt.Sprintf("%s -> %s", p.From, p.To)
+}
+
+// WeightChange is a direct path whose weight changed. Shares are the
+// fractions of the weight of From that go through the direct path. See
+// Verifier.Share.
+type WeightChange struct {
+	From      string  `yaml:"from" json:"from"`
+	To        string  `yaml:"to" json:"to"`
+	OldWeight int64   `yaml:"old-weight" json:"old-weight"`
+	NewWeight int64   `yaml:"new-weight" json:"new-weight"`
+	OldShare  float64 `yaml:"old-share" json:"old-share"`
+	NewShare  float64 `yaml:"new-share" json:"new-share"`
+}
+
+// Unchanged returns true if no function, direct path or reachability
+// changed. Weight changes are ignored, since they are expected between any
+// two profiles.
+func (r *DiffReport) Unchanged() bool {
+	return len(r.AddedFunctions) == 0 && len(r.RemovedFunctions) == 0 &&
+		len(r.AddedEdges) == 0 && len(r.RemovedEdges) == 0 &&
+		len(r.NewlyReachable) == 0 && len(r.NoLongerReachable) == 0
+}
+
+// DiffOption configures Diff.
+type DiffOption func(*diffConfig)
+
+type diffConfig struct {
+	sampleType     string
+	minShareChange float64
+}
+
+// WithDiffSampleType weighs the direct paths with the named sample type
+// rather than the profiles' default one.
+func WithDiffSampleType(sampleType string) DiffOption {
+	return func(c *diffConfig) {
+		c.sampleType = sampleType
+	}
+}
+
+// WithMinShareChange only reports the weight changes whose share changed
+// by at least delta, e.g. 0.1 for 10 percentage points. By default, every
+// change is reported.
+func WithMinShareChange(delta float64) DiffOption {
+	return func(c *diffConfig) {
+		c.minShareChange = delta
+	}
+}
+
+// Diff compares the call graphs of two Verifiers, reporting the direct
+// paths that appeared or disappeared, how reachability changed between the
+// functions in both, and how the weights of the direct paths in both
+// changed.
+func Diff(oldVerifier, newVerifier *Verifier, opts ...DiffOption) (*DiffReport, error) {
+	var config diffConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	report := &DiffReport{}
+	oldT, newT := -1, -1
+	if oldVerifier.weighted && newVerifier.weighted {
+		var err error
+		if oldT, err = oldVerifier.sampleTypeIndex(config.sampleType); err != nil {
+			return nil, err
+		}
+		if newT, err = newVerifier.sampleTypeIndex(config.sampleType); err != nil {
+			return nil, err
+		}
+		report.SampleType = newVerifier.masterProfile.sampleTypes[newT].Type
+	}
+
+	shared := sharedNames(oldVerifier, newVerifier)
+	oldNames, newNames := oldVerifier.diffNames(shared), newVerifier.diffNames(shared)
+	oldIds, newIds := pseudoIds(oldNames), pseudoIds(newNames)
+	var common []string
+	for name := range newIds {
+		if _, ok := oldIds[name]; ok {
+			common = append(common, name)
+		} else {
+			report.AddedFunctions = append(report.AddedFunctions, name)
+		}
+	}
+	for name := range oldIds {
+		if _, ok := newIds[name]; !ok {
+			report.RemovedFunctions = append(report.RemovedFunctions, name)
+		}
+	}
+	sort.Strings(common)
+	sort.Strings(report.AddedFunctions)
+	sort.Strings(report.RemovedFunctions)
+
+	report.AddedEdges = diffEdges(newVerifier, oldVerifier, newNames, oldIds, newT)
+	report.RemovedEdges = diffEdges(oldVerifier, newVerifier, oldNames, newIds, oldT)
+
+	for _, from := range common {
+		oldReachable := oldVerifier.path.reachableFrom(oldIds[from])
+		newReachable := newVerifier.path.reachableFrom(newIds[from])
+		for _, to := range common {
+			wasReachable, isReachable := oldReachable.has(oldIds[to]), newReachable.has(newIds[to])
+			switch {
+			case isReachable && !wasReachable:
+				report.NewlyReachable = append(report.NewlyReachable, FunctionPair{From: from, To: to})
+			case wasReachable && !isReachable:
+				report.NoLongerReachable = append(report.NoLongerReachable, FunctionPair{From: from, To: to})
+			}
+		}
+	}
+
+	if report.SampleType == "" {
+		return report, nil
+	}
+	for _, from := range common {
+		oldFrom, newFrom := oldIds[from], newIds[from]
+		for _, to := range common {
+			oldTo, newTo := oldIds[to], newIds[to]
+			if !oldVerifier.path.HasDirectPath(oldFrom, oldTo) || !newVerifier.path.HasDirectPath(newFrom, newTo) {
+				continue
+			}
+
+			change := WeightChange{
+				From:      from,
+				To:        to,
+				OldWeight: oldVerifier.path.DirectPathValue(oldFrom, oldTo, oldT),
+				NewWeight: newVerifier.path.DirectPathValue(newFrom, newTo, newT),
+			}
+			if total := oldVerifier.nodeValues[oldFrom][oldT]; total != 0 {
+				change.OldShare = float64(change.OldWeight) / float64(total)
+			}
+			if total := newVerifier.nodeValues[newFrom][newT]; total != 0 {
+				change.NewShare = float64(change.NewWeight) / float64(total)
+			}
+
+			delta := math.Abs(change.NewShare - change.OldShare)
+			if change.OldWeight == change.NewWeight && delta == 0 || delta < config.minShareChange {
+				continue
+			}
+			report.WeightChanges = append(report.WeightChanges, change)
+		}
+	}
+	return report, nil
+}
+
+// diffEdges returns the direct paths of v, whose functions are identified
+// by names, that are not in other, whose pseudoIDs by name are otherIds,
+// sorted by their endpoints and weighed with sample type t if it is not
+// negative.
+func diffEdges(v, other *Verifier, names []string, otherIds map[string]int, t int) []DiffEdge {
+	var edges []DiffEdge
+	for from := 0; from < v.path.n; from++ {
+		for _, to := range v.path.successors(from) {
+			otherFrom, fromOk := otherIds[names[from]]
+			otherTo, toOk := otherIds[names[to]]
+			if fromOk && toOk && other.path.HasDirectPath(otherFrom, otherTo) {
+				continue
+			}
+
+			edge := DiffEdge{From: names[from], To: names[to], Kind: v.path.DirectPathKind(from, to)}
+			if t >= 0 {
+				edge.Weight = v.path.DirectPathValue(from, to, t)
+			}
+			edges = append(edges, edge)
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].From != edges[j].From {
+			return edges[i].From < edges[j].From
+		}
+		return edges[i].To < edges[j].To
+	})
+	return edges
+}
+
+// sharedNames returns the full names shared by several functions of
+// either Verifier.
+func sharedNames(verifiers ...*Verifier) map[string]bool {
+	shared := make(map[string]bool)
+	for _, v := range verifiers {
+		seen := make(map[string]bool, v.path.n)
+		for i := 0; i < v.path.n; i++ {
+			name := v.name(i)
+			if seen[name] {
+				shared[name] = true
+			}
+			seen[name] = true
+		}
+	}
+	return shared
+}
+
+// diffNames returns the names identifying the functions of v in a
+// DiffReport, by pseudoID: their full names, or their qualified
+// FunctionKey if their full name is shared.
+func (v *Verifier) diffNames(shared map[string]bool) []string {
+	names := make([]string, v.path.n)
+	for i := range names {
+		names[i] = v.name(i)
+		if shared[names[i]] {
+			names[i] = v.masterProfile.functionIdKeyMap[v.pseudoFunctionIdMap[uint64(i)]].String()
+		}
+	}
+	return names
+}
+
+// pseudoIds maps the names of functions to their pseudoIDs.
+func pseudoIds(names []string) map[string]int {
+	ids := make(map[string]int, len(names))
+	for i, name := range names {
+		ids[name] = i
+	}
+	return ids
+}
@@ -0,0 +1,146 @@
+package pprofsv_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/gaukas/pprofsv"
+	"github.com/google/pprof/profile"
+)
+
+func TestDiff(t *testing.T) {
+	oldVerifier, err := pprofsv.NewProfile(syntheticProfile("main;a;b", "main;a;b", "main;c")).Verifier("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newVerifier, err := pprofsv.NewProfile(syntheticProfile("main;a;b", "main;a;c", "main;d")).Verifier("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := pprofsv.Diff(oldVerifier, newVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Unchanged() {
+		t.Fatal("expected changes")
+	}
+	if report.SampleType != "samples" {
+		t.Errorf("expected sample type samples, got %q", report.SampleType)
+	}
+
+	if !slices.Equal(report.AddedFunctions, []string{"d"}) || len(report.RemovedFunctions) > 0 {
+		t.Errorf("expected function d to be added, got %v and %v removed", report.AddedFunctions, report.RemovedFunctions)
+	}
+
+	edges := func(edges []pprofsv.DiffEdge) []pprofsv.FunctionPair {
+		var pairs []pprofsv.FunctionPair
+		for _, edge := range edges {
+			pairs = append(pairs, pprofsv.FunctionPair{From: edge.From, To: edge.To})
+		}
+		return pairs
+	}
+	if got, want := edges(report.AddedEdges), []pprofsv.FunctionPair{{From: "a", To: "c"}, {From: "main", To: "d"}}; !slices.Equal(got, want) {
+		t.Errorf("expected added edges %v, got %v", want, got)
+	}
+	if got, want := edges(report.RemovedEdges), []pprofsv.FunctionPair{{From: "main", To: "c"}}; !slices.Equal(got, want) {
+		t.Errorf("expected removed edges %v, got %v", want, got)
+	}
+	if report.RemovedEdges[0].Weight != 1 || report.RemovedEdges[0].Kind != pprofsv.EdgeCall {
+		t.Errorf("unexpected removed edge %+v", report.RemovedEdges[0])
+	}
+
+	// main still reaches c through a, d is new so it is not listed
+	if want := []pprofsv.FunctionPair{{From: "a", To: "c"}}; !slices.Equal(report.NewlyReachable, want) {
+		t.Errorf("expected newly reachable %v, got %v", want, report.NewlyReachable)
+	}
+	if len(report.NoLongerReachable) > 0 {
+		t.Errorf("expected nothing to become unreachable, got %v", report.NoLongerReachable)
+	}
+
+	// main -> a keeps 2 out of 3 samples, a -> b drops from all to half
+	if len(report.WeightChanges) != 1 {
+		t.Fatalf("expected a single weight change, got %+v", report.WeightChanges)
+	}
+	if change := report.WeightChanges[0]; change.From != "a" || change.To != "b" || change.OldShare != 1 || change.NewShare != 0.5 {
+		t.Errorf("unexpected weight change %+v", change)
+	}
+
+	report, err = pprofsv.Diff(oldVerifier, newVerifier, pprofsv.WithMinShareChange(0.6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.WeightChanges) > 0 {
+		t.Errorf("expected no weight change above 0.6, got %+v", report.WeightChanges)
+	}
+
+	if _, err := pprofsv.Diff(oldVerifier, newVerifier, pprofsv.WithDiffSampleType("cpu")); err == nil {
+		t.Error("expected an error for an unknown sample type")
+	}
+
+	report, err = pprofsv.Diff(oldVerifier, oldVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Unchanged() || len(report.WeightChanges) > 0 {
+		t.Errorf("expected no difference with itself, got %+v", report)
+	}
+}
+
+func TestDiffSharedNames(t *testing.T) {
+	// f is in two binaries, and only the one in /bin/b is no longer called
+	mapped := func(stacks ...string) *pprofsv.Profile {
+		pprof := syntheticProfile(stacks...)
+		mappings := map[string]*profile.Mapping{
+			"/bin/a": {ID: 1, File: "/bin/a"},
+			"/bin/b": {ID: 2, File: "/bin/b"},
+		}
+		pprof.Mapping = []*profile.Mapping{mappings["/bin/a"], mappings["/bin/b"]}
+		for _, location := range pprof.Location {
+			location.Mapping = mappings["/bin/a"]
+		}
+		for _, sample := range pprof.Sample {
+			if len(sample.Location) > 1 && sample.Location[1].Line[0].Function.Name == "g" {
+				// g calls the f of /bin/b
+				sample.Location[0] = &profile.Location{
+					ID:      uint64(len(pprof.Location) + 1),
+					Mapping: mappings["/bin/b"],
+					Line:    sample.Location[0].Line,
+				}
+				pprof.Location = append(pprof.Location, sample.Location[0])
+			}
+		}
+		return pprofsv.NewProfile(pprof)
+	}
+	oldProfile := mapped("main;f", "main;g;f")
+	oldVerifier, err := oldProfile.Verifier("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newVerifier, err := mapped("main;f", "main;g").Verifier("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = oldProfile.Lookup("f")
+	var functionErr *pprofsv.FunctionError
+	if !errors.As(err, &functionErr) || len(functionErr.Candidates) != 2 {
+		t.Fatalf("f should be ambiguous, got %v", err)
+	}
+	fa, fb := functionErr.Candidates[0].String(), functionErr.Candidates[1].String()
+
+	report, err := pprofsv.Diff(oldVerifier, newVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(report.RemovedFunctions, []string{fb}) || len(report.AddedFunctions) > 0 {
+		t.Errorf("expected %s to be removed, got %v and %v added", fb, report.RemovedFunctions, report.AddedFunctions)
+	}
+	if len(report.RemovedEdges) != 1 || report.RemovedEdges[0].From != "g" || report.RemovedEdges[0].To != fb {
+		t.Errorf("expected g -> %s to be removed, got %+v", fb, report.RemovedEdges)
+	}
+	if len(report.AddedEdges) > 0 || len(report.NoLongerReachable) > 0 {
+		t.Errorf("expected main -> %s to be unchanged, got %+v and %v", fa, report.AddedEdges, report.NoLongerReachable)
+	}
+}
@@ -0,0 +1,177 @@
+package pprofsv
+
+// DominatorTree is the dominator (or post-dominator) tree of a Verifier's
+// call graph, rooted at a chosen function.
+//
+// In a dominator tree rooted at an entry function, function a dominates
+// function b if every path from the entry to b passes through a. In a
+// post-dominator tree rooted at an exit function, a post-dominates b if
+// every path from b to the exit passes through a. Every function
+// dominates itself.
+type DominatorTree struct {
+	v    *Verifier
+	root int
+
+	// idom[i] is the immediate (post-)dominator of the function with
+	// pseudoID i, or -1 if i is not connected to the root.
+	idom []int
+}
+
+// DominatorTree returns the dominator tree of the call graph rooted at the
+// entry function.
+func (v *Verifier) DominatorTree(entry string) (*DominatorTree, error) {
+	root, err := v.lookup(entry)
+	if err != nil {
+		return nil, err
+	}
+	return &DominatorTree{v: v, root: root, idom: v.path.Dominators(root)}, nil
+}
+
+// PostDominatorTree returns the post-dominator tree of the call graph
+// rooted at the exit function.
+func (v *Verifier) PostDominatorTree(exit string) (*DominatorTree, error) {
+	root, err := v.lookup(exit)
+	if err != nil {
+		return nil, err
+	}
+	return &DominatorTree{v: v, root: root, idom: v.path.PostDominators(root)}, nil
+}
+
+// Root returns the full name of the function the tree is rooted at.
+func (t *DominatorTree) Root() string {
+	return t.v.name(t.root)
+}
+
+// Dominates returns true if function a (post-)dominates function b. It
+// returns false if b is not connected to the root of the tree.
+func (t *DominatorTree) Dominates(a, b string) (bool, error) {
+	aId, err := t.v.lookup(a)
+	if err != nil {
+		return false, err
+	}
+
+	bId, err := t.v.lookup(b)
+	if err != nil {
+		return false, err
+	}
+
+	return dominates(t.idom, aId, bId), nil
+}
+
+// ImmediateDominator returns the full name of the closest strict
+// (post-)dominator of the function, or an empty string if the function is
+// the root or is not connected to it.
+func (t *DominatorTree) ImmediateDominator(function string) (string, error) {
+	id, err := t.v.lookup(function)
+	if err != nil {
+		return "", err
+	}
+
+	if id == t.root || t.idom[id] < 0 {
+		return "", nil
+	}
+	return t.v.name(t.idom[id]), nil
+}
+
+// Dominators returns the full names of all the (post-)dominators of the
+// function, from the function itself up to the root of the tree. It
+// returns nil if the function is not connected to the root.
+func (t *DominatorTree) Dominators(function string) ([]string, error) {
+	id, err := t.v.lookup(function)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.idom[id] < 0 {
+		return nil, nil
+	}
+
+	var dominators []string
+	for {
+		dominators = append(dominators, t.v.name(id))
+		if id == t.idom[id] {
+			return dominators, nil
+		}
+		id = t.idom[id]
+	}
+}
+
+// dominates walks up the tree from b, looking for a.
+func dominates(idom []int, a, b int) bool {
+	if idom[b] < 0 {
+		return false
+	}
+	for {
+		if b == a {
+			return true
+		}
+		if idom[b] == b {
+			return false
+		}
+		b = idom[b]
+	}
+}
+
+// Dominates returns true if every path to function b, from any function at
+// the root of a call stack (e.g. runtime.main or runtime.goexit), passes
+// through function a. Use DominatorTree to choose the entry function.
+func (v *Verifier) Dominates(a, b string) (bool, error) {
+	aId, err := v.lookup(a)
+	if err != nil {
+		return false, err
+	}
+
+	bId, err := v.lookup(b)
+	if err != nil {
+		return false, err
+	}
+
+	seen := newBitset(v.path.n)
+	var roots []int
+	for _, callStack := range v.callStacks {
+		root := int(v.functionIdPseudoMap[callStack[len(callStack)-1]])
+		if !seen.has(root) {
+			seen.set(root)
+			roots = append(roots, root)
+		}
+	}
+
+	v.path.rw.RLock()
+	defer v.path.rw.RUnlock()
+	return dominates(v.path.dominators(roots, false), aId, bId), nil
+}
+
+// MustPassThrough checks that every path from function `from` to function
+// `to` passes through function `via`. It returns nil if the property
+// holds, including when `to` is not reachable from `from` at all, or a
+// path from `from` to `to` avoiding `via` as a Witness of the violation.
+//
+// Unlike skipped functions in CheckReachable, `via` must not be filtered
+// out by the Verifier.
+func (v *Verifier) MustPassThrough(from, to, via string) (*Witness, error) {
+	fromId, err := v.lookup(from)
+	if err != nil {
+		return nil, err
+	}
+
+	toId, err := v.lookup(to)
+	if err != nil {
+		return nil, err
+	}
+
+	viaId, err := v.lookup(via)
+	if err != nil {
+		return nil, err
+	}
+
+	if dominates(v.path.Dominators(fromId), viaId, toId) {
+		return nil, nil
+	}
+
+	pseudoPath := v.path.FindPath(fromId, toId, viaId)
+	if pseudoPath == nil {
+		// to is not reachable from from
+		return nil, nil
+	}
+	return v.witness(pseudoPath), nil
+}
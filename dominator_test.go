@@ -0,0 +1,118 @@
+package pprofsv_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/gaukas/pprofsv"
+)
+
+func TestVerifierDominators(t *testing.T) {
+	verifier, err := pprofsv.NewProfile(syntheticProfile(
+		"main;serve;authorize;writeResponse",
+		"main;serve;authorize;cache;writeResponse",
+		"main;serve;healthz;writeStatus",
+		"main;debug;writeResponse",
+	)).Verifier("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := verifier.DominatorTree("serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		a, b      string
+		dominates bool
+	}{
+		{"authorize", "writeResponse", true},
+		{"cache", "writeResponse", false},
+		{"serve", "writeStatus", true},
+		{"authorize", "writeStatus", false},
+		{"writeResponse", "writeResponse", true},
+		{"serve", "debug", false}, // not reachable from serve
+	} {
+		dominates, err := tree.Dominates(c.a, c.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dominates != c.dominates {
+			t.Errorf("Dominates(%s, %s) = %v, want %v", c.a, c.b, dominates, c.dominates)
+		}
+	}
+
+	if idom, err := tree.ImmediateDominator("writeResponse"); err != nil || idom != "authorize" {
+		t.Errorf("immediate dominator of writeResponse should be authorize, got %q, %v", idom, err)
+	}
+	if dominators, err := tree.Dominators("cache"); err != nil || !slices.Equal(dominators, []string{"cache", "authorize", "serve"}) {
+		t.Errorf("unexpected dominators of cache: %v, %v", dominators, err)
+	}
+
+	// from the roots of the call stacks, debug bypasses authorize
+	if dominates, err := verifier.Dominates("authorize", "writeResponse"); err != nil || dominates {
+		t.Errorf("authorize should not dominate writeResponse from main, got %v, %v", dominates, err)
+	}
+	if dominates, err := verifier.Dominates("main", "writeResponse"); err != nil || !dominates {
+		t.Errorf("main should dominate writeResponse, got %v, %v", dominates, err)
+	}
+
+	post, err := verifier.PostDominatorTree("writeResponse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dominates, err := post.Dominates("authorize", "serve"); err != nil || !dominates {
+		t.Errorf("authorize should post-dominate serve, got %v, %v", dominates, err)
+	}
+	if dominates, err := post.Dominates("authorize", "main"); err != nil || dominates {
+		t.Errorf("authorize should not post-dominate main, got %v, %v", dominates, err)
+	}
+
+	// must pass through
+	if witness, err := verifier.MustPassThrough("serve", "writeResponse", "authorize"); err != nil || witness != nil {
+		t.Errorf("serve -> writeResponse should pass through authorize, got %v, %v", witness, err)
+	}
+	witness, err := verifier.MustPassThrough("main", "writeResponse", "authorize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if witness.String() != "main -call-> debug -call-> writeResponse" {
+		t.Errorf("unexpected witness %v", witness)
+	}
+
+	// vacuously true
+	if witness, err := verifier.MustPassThrough("debug", "writeStatus", "authorize"); err != nil || witness != nil {
+		t.Errorf("debug -> writeStatus should pass through authorize vacuously, got %v, %v", witness, err)
+	}
+
+	if _, err := verifier.MustPassThrough("serve", "writeResponse", "login"); !errors.Is(err, pprofsv.ErrFunctionNotFound) {
+		t.Errorf("login should not be found, got %v", err)
+	}
+}
+
+func TestVerifierDominatorsDummy(t *testing.T) {
+	pprof := loadProfile(t, "testdata/pprof.profile")
+
+	verifier, err := pprofsv.NewProfile(pprof).Verifier("dummy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier.SetFunctionPrefix("github.com/gaukas/pprofsv/dummy.(*Dummy).")
+
+	if witness, err := verifier.MustPassThrough("DeepFunc", "deepFuncLv5", "deepFuncLv3"); err != nil || witness != nil {
+		t.Errorf("DeepFunc -> deepFuncLv5 should pass through deepFuncLv3, got %v, %v", witness, err)
+	}
+
+	// MultiFunc reaches final through any of its branches
+	witness, err := verifier.MustPassThrough("MultiFunc", "final", "multiFuncA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if witness == nil {
+		t.Errorf("MultiFunc -> final should not have to pass through multiFuncA")
+	} else if slices.Contains(witness.Functions, "github.com/gaukas/pprofsv/dummy.(*Dummy).multiFuncA") {
+		t.Errorf("witness %v should avoid multiFuncA", witness)
+	}
+}
@@ -0,0 +1,58 @@
+// Package transitions_test checks the transitions of a live Dummy rather
+// than of testdata/pprof.profile. It is a test binary of its own, so that
+// recording every allocation for CaptureHeap does not slow down the
+// benchmarks of package dummy.
+package transitions_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/gaukas/pprofsv/dummy"
+	"github.com/gaukas/pprofsv/pprofsvtest"
+)
+
+const dummyPrefix = "github.com/gaukas/pprofsv/dummy.(*Dummy)."
+
+func TestMain(m *testing.M) {
+	pprofsvtest.EnableHeap()
+	os.Exit(m.Run())
+}
+
+// TestDummyTransitions checks the transitions ending in an allocation.
+// The allocations of 8 bytes share blocks of the tiny allocator, and only
+// the first allocation of every block is recorded, so every transition is
+// taken a few times. MultiFunc allocates 4 times, and so is followed by
+// LoopFunc(1) for each of its allocations to start a block in some round.
+func TestDummyTransitions(t *testing.T) {
+	d := dummy.NewDummy()
+	p := pprofsvtest.CaptureHeap(t, func() {
+		for i := 0; i < 4; i++ {
+			d.BranchFunc(true)
+		}
+		for i := 0; i < 4; i++ {
+			d.DeepFunc()
+		}
+		for i := 0; i < 4; i++ {
+			d.MultiFunc()
+			d.LoopFunc(1)
+		}
+		for i := 0; i < 4; i++ {
+			d.RecursiveFunc(3)
+		}
+	})
+	v := pprofsvtest.Verifier(t, p, `dummy\.\(\*Dummy\)`, dummyPrefix)
+
+	pprofsvtest.AssertNext(t, v, "BranchFunc", "branchA")
+	pprofsvtest.AssertReachable(t, v, "BranchFunc", "alloc")
+	pprofsvtest.AssertNotNext(t, v, "BranchFunc", "branchAinner")
+
+	pprofsvtest.AssertReachable(t, v, "DeepFunc", "deepFuncLv5")
+	pprofsvtest.AssertNotNext(t, v, "DeepFunc", "deepFuncLv2")
+	pprofsvtest.AssertNotReachable(t, v, "deepFuncLv5", "DeepFunc")
+
+	pprofsvtest.AssertReachable(t, v, "MultiFunc", "final")
+	pprofsvtest.AssertNotReachable(t, v, "multiFuncA", "multiFuncB")
+
+	pprofsvtest.AssertReachable(t, v, "recursiveFuncInnerB", "recursiveFuncInnerA")
+}
@@ -0,0 +1,71 @@
+package pprofsv
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrFunctionNotFound means no function with the given name exists in
+	// the profile.
+	ErrFunctionNotFound = errors.New("function not found in profile")
+
+	// ErrFunctionFiltered means the function exists in the profile but was
+	// filtered out by the Verifier's name pattern.
+	ErrFunctionFiltered = errors.New("function filtered out by verifier")
+
+	// ErrAmbiguousFunction means more than one function in the profile
+	// goes by the given name.
+	ErrAmbiguousFunction = errors.New("ambiguous function name")
+
+	// ErrSampleTypeNotFound means the profile has no sample type with the
+	// given name.
+	ErrSampleTypeNotFound = errors.New("sample type not found in profile")
+
+	// ErrNoSampleValues means the Verifier was built from base call stacks
+	// rather than from the samples of its profile, so it has no sample
+	// values to weigh functions and edges with.
+	ErrNoSampleValues = errors.New("verifier has no sample values")
+
+	// ErrNoGoroutines means a goroutine dump holds no goroutine, e.g.
+	// because the input is not a goroutine dump at all.
+	ErrNoGoroutines = errors.New("no goroutine found in dump")
+
+	// ErrNoStacks means folded stacks, perf script output or an execution
+	// trace hold no stack, e.g. because the input is in another format.
+	ErrNoStacks = errors.New("no stack found")
+
+	// ErrNoTimeline means the samples of the Verifier have no goroutine
+	// and time labels, e.g. because they come from a pprof profile rather
+	// than from an execution trace, so the order in which functions were
+	// observed is unknown.
+	ErrNoTimeline = errors.New("verifier has no timeline")
+)
+
+// FunctionError records a failed function lookup. Err is one of
+// ErrFunctionNotFound, ErrFunctionFiltered or ErrAmbiguousFunction.
+type FunctionError struct {
+	Name string // full name, including the function prefix
+	Err  error
+
+	// Candidates lists the functions matching Name, set only if Err is
+	// ErrAmbiguousFunction. Any of their String forms can be used in
+	// place of Name to pick one.
+	Candidates []FunctionKey
+}
+
+func (e *FunctionError) Error() string {
+	if len(e.Candidates) > 0 {
+		candidates := make([]string, 0, len(e.Candidates))
+		for _, key := range e.Candidates {
+			candidates = append(candidates, key.String())
+		}
+		return fmt.Sprintf("function %s: %v, candidates: %s", e.Name, e.Err, strings.Join(candidates, ", "))
+	}
+	return fmt.Sprintf("function %s: %v", e.Name, e.Err)
+}
+
+func (e *FunctionError) Unwrap() error {
+	return e.Err
+}
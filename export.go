@@ -0,0 +1,272 @@
+package pprofsv
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Graph is the reduced call graph a Verifier checks assertions on, ready
+// to be rendered. See Verifier.Graph.
+type Graph struct {
+	Nodes []GraphNode `yaml:"nodes" json:"nodes"`
+	Edges []GraphEdge `yaml:"edges" json:"edges"`
+
+	// SampleType is the sample type the weights are in, or empty if the
+	// Verifier has no sample values.
+	SampleType string `yaml:"sample-type,omitempty" json:"sample-type,omitempty"`
+}
+
+// GraphNode is a function in a Graph.
+type GraphNode struct {
+	ID    int    `yaml:"id" json:"id"`
+	Name  string `yaml:"name" json:"name"`   // full name
+	Label string `yaml:"label" json:"label"` // name without the function prefix
+
+	// Weight is the inclusive value of the function. See Verifier.Weight.
+	Weight int64 `yaml:"weight,omitempty" json:"weight,omitempty"`
+
+	Highlighted bool `yaml:"highlighted,omitempty" json:"highlighted,omitempty"`
+}
+
+// GraphEdge is a direct path between two functions in a Graph.
+type GraphEdge struct {
+	From int      `yaml:"from" json:"from"`
+	To   int      `yaml:"to" json:"to"`
+	Kind EdgeKind `yaml:"kind" json:"kind"`
+
+	// Weight is the value of the samples with the edge. See
+	// Verifier.EdgeWeight.
+	Weight int64 `yaml:"weight,omitempty" json:"weight,omitempty"`
+
+	Highlighted bool `yaml:"highlighted,omitempty" json:"highlighted,omitempty"`
+}
+
+// ExportOption configures Verifier.Graph and the Export methods.
+type ExportOption func(*exportConfig)
+
+type exportConfig struct {
+	sampleType string
+	highlight  []*Witness
+}
+
+// WithSampleType weighs nodes and edges with the named sample type rather
+// than the profile's default one.
+func WithSampleType(sampleType string) ExportOption {
+	return func(c *exportConfig) {
+		c.sampleType = sampleType
+	}
+}
+
+// WithHighlight highlights the functions and edges of a witness, e.g. the
+// counterexample of a failed assertion. It may be given more than once,
+// and nil witnesses are ignored.
+func WithHighlight(w *Witness) ExportOption {
+	return func(c *exportConfig) {
+		if w != nil {
+			c.highlight = append(c.highlight, w)
+		}
+	}
+}
+
+// Graph returns the call graph of the Verifier, with the function prefix
+// stripped from the node labels. Nodes are sorted by name and edges by
+// their endpoints, so that the output is stable.
+func (v *Verifier) Graph(opts ...ExportOption) (*Graph, error) {
+	var config exportConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	g := &Graph{}
+	t := -1
+	if v.weighted {
+		var err error
+		if t, err = v.masterProfile.sampleTypeIndex(config.sampleType); err != nil {
+			return nil, err
+		}
+		g.SampleType = v.masterProfile.sampleTypes[t].Type
+	}
+
+	highlightedNodes := make(map[string]bool)
+	highlightedEdges := make(map[[2]string]bool)
+	for _, w := range config.highlight {
+		for _, name := range w.Functions {
+			highlightedNodes[name] = true
+		}
+		for _, edge := range w.Edges {
+			highlightedEdges[[2]string{edge.From, edge.To}] = true
+		}
+	}
+
+	pseudoIds := make([]int, 0, v.path.n)
+	for i := 0; i < v.path.n; i++ {
+		pseudoIds = append(pseudoIds, i)
+	}
+	sort.Slice(pseudoIds, func(i, j int) bool {
+		return v.name(pseudoIds[i]) < v.name(pseudoIds[j])
+	})
+
+	nodeIds := make([]int, v.path.n) // pseudoID -> node ID
+	for id, pseudoId := range pseudoIds {
+		nodeIds[pseudoId] = id
+		name := v.name(pseudoId)
+		node := GraphNode{
+			ID:          id,
+			Name:        name,
+			Label:       strings.TrimPrefix(name, v.functionPrefix),
+			Highlighted: highlightedNodes[name],
+		}
+		if t >= 0 {
+			node.Weight = v.nodeValues[pseudoId][t]
+		}
+		g.Nodes = append(g.Nodes, node)
+	}
+
+	for _, from := range pseudoIds {
+		for _, to := range v.path.successors(from) {
+			edge := GraphEdge{
+				From:        nodeIds[from],
+				To:          nodeIds[to],
+				Kind:        v.path.DirectPathKind(from, to),
+				Highlighted: highlightedEdges[[2]string{v.name(from), v.name(to)}],
+			}
+			if t >= 0 {
+				edge.Weight = v.path.DirectPathValue(from, to, t)
+			}
+			g.Edges = append(g.Edges, edge)
+		}
+	}
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].From != g.Edges[j].From {
+			return g.Edges[i].From < g.Edges[j].From
+		}
+		return g.Edges[i].To < g.Edges[j].To
+	})
+
+	return g, nil
+}
+
+// maxEdgeWeight returns the largest edge weight, or 0 if there is none.
+func (g *Graph) maxEdgeWeight() int64 {
+	var maxWeight int64
+	for _, edge := range g.Edges {
+		maxWeight = max(maxWeight, edge.Weight)
+	}
+	return maxWeight
+}
+
+// ExportDOT writes the call graph of the Verifier in the Graphviz DOT
+// language. Edge widths are proportional to their weights, inline edges
+// are dashed and highlighted nodes and edges are red.
+func (v *Verifier) ExportDOT(w io.Writer, opts ...ExportOption) error {
+	g, err := v.Graph(opts...)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	b.WriteString("digraph pprofsv {\n")
+	b.WriteString("\tnode [shape=box];\n")
+	for _, node := range g.Nodes {
+		attrs := []string{"label=" + dotQuote(node.Label), "tooltip=" + dotQuote(node.Name)}
+		if node.Highlighted {
+			attrs = append(attrs, "color=red", "penwidth=2")
+		}
+		fmt.Fprintf(&b, "\tn%d [%s];\n", node.ID, strings.Join(attrs, ", "))
+	}
+
+	maxWeight := g.maxEdgeWeight()
+	for _, edge := range g.Edges {
+		var attrs []string
+		if maxWeight > 0 {
+			attrs = append(attrs,
+				fmt.Sprintf("label=\"%d\"", edge.Weight),
+				fmt.Sprintf("penwidth=%.2f", 1+4*float64(edge.Weight)/float64(maxWeight)),
+			)
+		}
+		if edge.Kind&EdgeCall == 0 {
+			attrs = append(attrs, "style=dashed")
+		}
+		if edge.Highlighted {
+			attrs = append(attrs, "color=red")
+		}
+		fmt.Fprintf(&b, "\tn%d -> n%d", edge.From, edge.To)
+		if len(attrs) > 0 {
+			fmt.Fprintf(&b, " [%s]", strings.Join(attrs, ", "))
+		}
+		b.WriteString(";\n")
+	}
+	b.WriteString("}\n")
+
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// dotQuote quotes s as a DOT string.
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// ExportMermaid writes the call graph of the Verifier as a Mermaid
+// flowchart. Edges are labeled with their weights, inline edges are dotted
+// and highlighted nodes and edges are red.
+func (v *Verifier) ExportMermaid(w io.Writer, opts ...ExportOption) error {
+	g, err := v.Graph(opts...)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	b.WriteString("flowchart TD\n")
+	for _, node := range g.Nodes {
+		fmt.Fprintf(&b, "\tn%d[%s]\n", node.ID, mermaidQuote(node.Label))
+	}
+
+	maxWeight := g.maxEdgeWeight()
+	var highlightedEdges []string
+	for i, edge := range g.Edges {
+		arrow := "-->"
+		if edge.Kind&EdgeCall == 0 {
+			arrow = "-.->"
+		}
+		if maxWeight > 0 {
+			arrow += fmt.Sprintf("|%d|", edge.Weight)
+		}
+		fmt.Fprintf(&b, "\tn%d %s n%d\n", edge.From, arrow, edge.To)
+		if edge.Highlighted {
+			highlightedEdges = append(highlightedEdges, fmt.Sprint(i))
+		}
+	}
+
+	for _, node := range g.Nodes {
+		if node.Highlighted {
+			fmt.Fprintf(&b, "\tstyle n%d stroke:red,stroke-width:2px\n", node.ID)
+		}
+	}
+	if len(highlightedEdges) > 0 {
+		fmt.Fprintf(&b, "\tlinkStyle %s stroke:red,stroke-width:2px\n", strings.Join(highlightedEdges, ","))
+	}
+
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// mermaidQuote quotes s as a Mermaid node label.
+func mermaidQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "#quot;") + `"`
+}
+
+// ExportJSON writes the Graph of the Verifier as indented JSON.
+func (v *Verifier) ExportJSON(w io.Writer, opts ...ExportOption) error {
+	g, err := v.Graph(opts...)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(g)
+}
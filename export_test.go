@@ -0,0 +1,110 @@
+package pprofsv_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gaukas/pprofsv"
+)
+
+func TestVerifierGraph(t *testing.T) {
+	verifier, err := pprofsv.NewProfile(syntheticProfile(
+		"pkg.main;pkg.serve;pkg.write",
+		"pkg.main;pkg.serve;pkg.write",
+		"pkg.main;pkg.debug",
+	)).Verifier("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier.SetFunctionPrefix("pkg.")
+
+	witness := verifier.ReachablePath("main", "write")
+	g, err := verifier.Graph(pprofsv.WithHighlight(witness))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	labels := make([]string, 0, len(g.Nodes))
+	for _, node := range g.Nodes {
+		labels = append(labels, node.Label)
+		if node.Highlighted != (node.Label != "debug") {
+			t.Errorf("%s should be highlighted: %v", node.Label, node.Label != "debug")
+		}
+	}
+	if strings.Join(labels, " ") != "debug main serve write" {
+		t.Errorf("unexpected nodes %v", labels)
+	}
+	if g.SampleType != "samples" || g.Nodes[1].Weight != 3 {
+		t.Errorf("main should weigh 3 samples, got %d %s", g.Nodes[1].Weight, g.SampleType)
+	}
+
+	expected := []pprofsv.GraphEdge{
+		{From: 1, To: 0, Kind: pprofsv.EdgeCall, Weight: 1},
+		{From: 1, To: 2, Kind: pprofsv.EdgeCall, Weight: 2, Highlighted: true},
+		{From: 2, To: 3, Kind: pprofsv.EdgeCall, Weight: 2, Highlighted: true},
+	}
+	if len(g.Edges) != len(expected) {
+		t.Fatalf("expected edges %v, got %v", expected, g.Edges)
+	}
+	for i := range expected {
+		if g.Edges[i] != expected[i] {
+			t.Errorf("expected edge %v, got %v", expected[i], g.Edges[i])
+		}
+	}
+
+	if _, err := verifier.Graph(pprofsv.WithSampleType("cpu")); !errors.Is(err, pprofsv.ErrSampleTypeNotFound) {
+		t.Errorf("cpu should not be found, got %v", err)
+	}
+
+	t.Run("DOT", func(t *testing.T) {
+		var b bytes.Buffer
+		if err := verifier.ExportDOT(&b, pprofsv.WithHighlight(witness)); err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range []string{
+			`n0 [label="debug", tooltip="pkg.debug"];`,
+			`n1 [label="main", tooltip="pkg.main", color=red, penwidth=2];`,
+			`n1 -> n0 [label="1", penwidth=3.00];`,
+			`n2 -> n3 [label="2", penwidth=5.00, color=red];`,
+		} {
+			if !strings.Contains(b.String(), line) {
+				t.Errorf("expected %q in:\n%s", line, b.String())
+			}
+		}
+	})
+
+	t.Run("Mermaid", func(t *testing.T) {
+		var b bytes.Buffer
+		if err := verifier.ExportMermaid(&b, pprofsv.WithHighlight(witness)); err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range []string{
+			`n0["debug"]`,
+			`n1 -->|1| n0`,
+			`style n1 stroke:red`,
+			`linkStyle 1,2 stroke:red`,
+		} {
+			if !strings.Contains(b.String(), line) {
+				t.Errorf("expected %q in:\n%s", line, b.String())
+			}
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		var b bytes.Buffer
+		if err := verifier.ExportJSON(&b); err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded pprofsv.Graph
+		if err := json.Unmarshal(b.Bytes(), &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if len(decoded.Nodes) != 4 || len(decoded.Edges) != 3 || decoded.Edges[0].Kind != pprofsv.EdgeCall {
+			t.Errorf("unexpected graph %+v", decoded)
+		}
+	})
+}
@@ -0,0 +1,419 @@
+package pprofsv
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Filter selects the functions a Verifier is built with, and in which
+// samples. Filters on functions, such as Match, Package, Receiver, File and
+// Mapping, select the same functions in every sample, while Labels selects
+// every function, but only in the samples whose labels match. They compose
+// with And, Or, Not and Except, e.g. to select the functions of mysvc and
+// its subpackages, except the generated code and the test helpers, in the
+// samples of the server:
+//
+//	pprofsv.And(
+//		pprofsv.Except(pprofsv.Package("example.com/mysvc/..."), pprofsv.File("*.pb.go"), pprofsv.File("*_test.go")),
+//		pprofsv.Labels(pprofsv.MustParseLabelSelector("role=server")),
+//	)
+//
+// Errors in a Filter, such as an invalid regular expression, are reported
+// when a Verifier is built with it. See WithFilter.
+type Filter interface {
+	fmt.Stringer
+
+	// compile evaluates the filter on the functions of the profile.
+	compile(c *filterCompiler) (filterResult, error)
+}
+
+// filterResult returns the set of function IDs a filter selects in a
+// sample, given which of the label selectors of the filter match the
+// labels of the sample (the bit k of mask is set if the k-th selector
+// matches). The returned set must not be modified.
+type filterResult func(mask uint64) bitset
+
+// maxFilterLabels is the maximum number of Labels filters in a Filter.
+const maxFilterLabels = 64
+
+// filterCompiler holds the state of the compilation of a Filter.
+type filterCompiler struct {
+	p *Profile
+	n int // the size of the sets of function IDs
+
+	// selectors lists the label selectors of the Labels filters, in the
+	// order of their bits in the masks.
+	selectors []LabelSelector
+}
+
+func newFilterCompiler(p *Profile) *filterCompiler {
+	return &filterCompiler{
+		p: p,
+		n: len(p.functionIdMap) + 1, // function IDs start at 1
+	}
+}
+
+// all returns the set of every function ID.
+func (c *filterCompiler) all() bitset {
+	all := newBitset(c.n).complement(c.n)
+	all.unset(0)
+	return all
+}
+
+// functions returns the set of the function IDs whose key matches.
+func (c *filterCompiler) functions(match func(key FunctionKey) bool) bitset {
+	selected := newBitset(c.n)
+	for id, key := range c.p.functionIdKeyMap {
+		if match(key) {
+			selected.set(int(id))
+		}
+	}
+	return selected
+}
+
+// compiledFilter is a Filter compiled against a profile. It is not safe
+// for concurrent use.
+type compiledFilter struct {
+	result    filterResult
+	selectors []LabelSelector
+	cache     map[uint64]bitset
+}
+
+// compileFilter compiles the filter against the functions of p, keeping
+// only the candidate function IDs.
+func compileFilter(p *Profile, filter Filter, candidates []uint64) (*compiledFilter, error) {
+	c := newFilterCompiler(p)
+	result, err := filter.compile(c)
+	if err != nil {
+		return nil, fmt.Errorf("filter %s: %w", filter, err)
+	}
+
+	within := newBitset(c.n)
+	for _, id := range candidates {
+		within.set(int(id))
+	}
+	return &compiledFilter{
+		result: func(mask uint64) bitset {
+			selected := result(mask).clone()
+			selected.and(within)
+			return selected
+		},
+		selectors: c.selectors,
+		cache:     make(map[uint64]bitset),
+	}, nil
+}
+
+// sampleIndependent returns true if the filter selects the same functions
+// in every sample.
+func (f *compiledFilter) sampleIndependent() bool {
+	return len(f.selectors) == 0
+}
+
+// functions returns the set of the function IDs selected in a sample with
+// the given labels. Samples whose labels match the same selectors share
+// the same set, which must not be modified.
+func (f *compiledFilter) functions(labels map[string][]string, numLabels map[string][]int64) bitset {
+	var mask uint64
+	for k, selector := range f.selectors {
+		if selector.Matches(labels, numLabels) {
+			mask |= 1 << k
+		}
+	}
+	selected, ok := f.cache[mask]
+	if !ok {
+		selected = f.result(mask)
+		f.cache[mask] = selected
+	}
+	return selected
+}
+
+// constant returns the result of a filter selecting the same functions in
+// every sample.
+func constant(selected bitset) filterResult {
+	return func(uint64) bitset {
+		return selected
+	}
+}
+
+type functionFilter struct {
+	name  string
+	match func(key FunctionKey) bool
+}
+
+func (f functionFilter) String() string {
+	return f.name
+}
+
+func (f functionFilter) compile(c *filterCompiler) (filterResult, error) {
+	return constant(c.functions(f.match)), nil
+}
+
+// FunctionFilter returns a Filter selecting the functions for which match
+// returns true, for the needs not covered by the other filters.
+func FunctionFilter(match func(key FunctionKey) bool) Filter {
+	return functionFilter{name: "function(...)", match: match}
+}
+
+type matchFilter struct {
+	pattern string
+}
+
+func (f matchFilter) String() string {
+	return fmt.Sprintf("match(%s)", f.pattern)
+}
+
+func (f matchFilter) compile(c *filterCompiler) (filterResult, error) {
+	re, err := regexp.Compile(f.pattern)
+	if err != nil {
+		return nil, err
+	}
+	return constant(c.functions(func(key FunctionKey) bool {
+		return re.MatchString(key.Name)
+	})), nil
+}
+
+// Match returns a Filter selecting the functions whose full name matches
+// the regular expression, as the name pattern of NewVerifier does.
+func Match(pattern string) Filter {
+	return matchFilter{pattern: pattern}
+}
+
+// Package returns a Filter selecting the functions of the Go package with
+// the given import path, e.g. "example.com/mysvc/internal/db", or, if the
+// path ends with "/...", of the package and all its subpackages.
+func Package(importPath string) Filter {
+	return functionFilter{
+		name: fmt.Sprintf("package(%s)", importPath),
+		match: func(key FunctionKey) bool {
+			pkg, _ := splitFunctionName(key.Name)
+			if parent, ok := strings.CutSuffix(importPath, "/..."); ok {
+				return pkg == parent || strings.HasPrefix(pkg, parent+"/")
+			}
+			return pkg == importPath
+		},
+	}
+}
+
+// Receiver returns a Filter selecting the methods of the named type, and
+// the closures within them, with either a value or a pointer receiver,
+// e.g. Receiver("Conn") selects both "net.(*Conn).Read" and
+// "net.Conn.String". The type name is not qualified by its package:
+// combine the filter with Package for that.
+func Receiver(typeName string) Filter {
+	return functionFilter{
+		name: fmt.Sprintf("receiver(%s)", typeName),
+		match: func(key FunctionKey) bool {
+			_, receiver, ok := splitReceiver(key.Name)
+			if !ok {
+				return false
+			}
+			receiver = strings.TrimSuffix(strings.TrimPrefix(receiver, "(*"), ")")
+			// type parameters, e.g. "(*List[...])"
+			if i := strings.IndexByte(receiver, '['); i >= 0 {
+				receiver = receiver[:i]
+			}
+			return receiver == typeName
+		},
+	}
+}
+
+// File returns a Filter selecting the functions whose source file name
+// matches the glob pattern, as in path.Match. A pattern without a '/',
+// e.g. "*.pb.go" or "*_test.go", matches the base name of the file, and
+// any other pattern matches the full file name.
+func File(pattern string) Filter {
+	return globFilter{kind: "file", pattern: pattern, field: func(key FunctionKey) string {
+		return key.Filename
+	}}
+}
+
+// Mapping returns a Filter selecting the functions of the binaries or
+// shared libraries whose file name (or build ID) matches the glob pattern,
+// e.g. "libc.so*", as File does.
+func Mapping(pattern string) Filter {
+	return globFilter{kind: "mapping", pattern: pattern, field: func(key FunctionKey) string {
+		return key.Mapping
+	}}
+}
+
+type globFilter struct {
+	kind    string
+	pattern string
+	field   func(key FunctionKey) string
+}
+
+func (f globFilter) String() string {
+	return fmt.Sprintf("%s(%s)", f.kind, f.pattern)
+}
+
+func (f globFilter) compile(c *filterCompiler) (filterResult, error) {
+	if _, err := path.Match(f.pattern, ""); err != nil {
+		return nil, err
+	}
+	return constant(c.functions(func(key FunctionKey) bool {
+		name := f.field(key)
+		if !strings.Contains(f.pattern, "/") {
+			name = path.Base(name)
+		}
+		matched, _ := path.Match(f.pattern, name)
+		return matched
+	})), nil
+}
+
+type labelsFilter struct {
+	selector LabelSelector
+}
+
+func (f labelsFilter) String() string {
+	return fmt.Sprintf("labels(%s)", f.selector)
+}
+
+func (f labelsFilter) compile(c *filterCompiler) (filterResult, error) {
+	if f.selector.Empty() {
+		return constant(c.all()), nil
+	}
+	if len(c.selectors) == maxFilterLabels {
+		return nil, fmt.Errorf("more than %d label selectors", maxFilterLabels)
+	}
+
+	bit := uint64(1) << len(c.selectors)
+	c.selectors = append(c.selectors, f.selector)
+	all, none := c.all(), newBitset(c.n)
+	return func(mask uint64) bitset {
+		if mask&bit != 0 {
+			return all
+		}
+		return none
+	}, nil
+}
+
+// Labels returns a Filter selecting every function, but only in the
+// samples whose pprof labels match the selector. See WithLabelSelector.
+func Labels(selector LabelSelector) Filter {
+	return labelsFilter{selector: selector}
+}
+
+type andFilter []Filter
+
+func (f andFilter) String() string {
+	return joinFilters("and", f)
+}
+
+func (f andFilter) compile(c *filterCompiler) (filterResult, error) {
+	return combineFilters(c, f, func(results []bitset) bitset {
+		selected := c.all()
+		for _, result := range results {
+			selected.and(result)
+		}
+		return selected
+	})
+}
+
+// And returns a Filter selecting the functions selected by all the
+// filters, or every function if there is none.
+func And(filters ...Filter) Filter {
+	return andFilter(filters)
+}
+
+type orFilter []Filter
+
+func (f orFilter) String() string {
+	return joinFilters("or", f)
+}
+
+func (f orFilter) compile(c *filterCompiler) (filterResult, error) {
+	return combineFilters(c, f, func(results []bitset) bitset {
+		selected := newBitset(c.n)
+		for _, result := range results {
+			selected.or(result)
+		}
+		return selected
+	})
+}
+
+// Or returns a Filter selecting the functions selected by any of the
+// filters, or no function if there is none.
+func Or(filters ...Filter) Filter {
+	return orFilter(filters)
+}
+
+type notFilter struct {
+	filter Filter
+}
+
+func (f notFilter) String() string {
+	return fmt.Sprintf("not(%s)", f.filter)
+}
+
+func (f notFilter) compile(c *filterCompiler) (filterResult, error) {
+	return combineFilters(c, []Filter{f.filter}, func(results []bitset) bitset {
+		selected := results[0].complement(c.n)
+		selected.unset(0)
+		return selected
+	})
+}
+
+// Not returns a Filter selecting the functions the filter does not select.
+func Not(filter Filter) Filter {
+	return notFilter{filter: filter}
+}
+
+// Except returns a Filter selecting the functions selected by include but
+// by none of the excluded filters, e.g.
+// Except(Package("example.com/mysvc/..."), File("*.pb.go")).
+func Except(include Filter, excluded ...Filter) Filter {
+	return And(include, Not(Or(excluded...)))
+}
+
+// combineFilters compiles the filters and combines their results. The
+// combination is computed once if none of the filters depends on the
+// labels of the samples.
+func combineFilters(c *filterCompiler, filters []Filter, combine func(results []bitset) bitset) (filterResult, error) {
+	selectors := len(c.selectors)
+	compiled := make([]filterResult, 0, len(filters))
+	for _, filter := range filters {
+		if filter == nil {
+			return nil, errors.New("nil filter")
+		}
+		result, err := filter.compile(c)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, result)
+	}
+
+	result := func(mask uint64) bitset {
+		results := make([]bitset, 0, len(compiled))
+		for _, result := range compiled {
+			results = append(results, result(mask))
+		}
+		return combine(results)
+	}
+	if len(c.selectors) == selectors {
+		return constant(result(0)), nil
+	}
+	return result, nil
+}
+
+func joinFilters(op string, filters []Filter) string {
+	names := make([]string, 0, len(filters))
+	for _, filter := range filters {
+		names = append(names, fmt.Sprint(filter))
+	}
+	return fmt.Sprintf("%s(%s)", op, strings.Join(names, ", "))
+}
+
+// splitFunctionName splits the full name of a Go function into its
+// package import path and the rest, e.g. "example.com/mysvc/db" and
+// "(*Conn).Query" for "example.com/mysvc/db.(*Conn).Query".
+func splitFunctionName(name string) (importPath, rest string) {
+	slash := strings.LastIndexByte(name, '/')
+	dot := strings.IndexByte(name[slash+1:], '.')
+	if dot < 0 {
+		return "", name
+	}
+	return name[:slash+1+dot], name[slash+1+dot+1:]
+}
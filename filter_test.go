@@ -0,0 +1,211 @@
+package pprofsv_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/gaukas/pprofsv"
+	"github.com/google/pprof/profile"
+)
+
+// filterProfile returns a profile of a service, with generated code, test
+// helpers, a label telling the server from the tests apart, and a C
+// library.
+func filterProfile() *pprofsv.Profile {
+	pprof := syntheticProfile(
+		"main.main;example.com/mysvc.(*Server).Serve;example.com/mysvc/api.(*Request).Unmarshal;example.com/mysvc/api.decode",
+		"main.main;example.com/mysvc.(*Server).Serve;example.com/mysvc/db.(*Conn).Query;PQexec",
+		"testing.tRunner;example.com/mysvc.newTestServer;example.com/mysvc.(*Server).Serve",
+	)
+	pprof.Sample[0].Label = map[string][]string{"role": {"server"}}
+	pprof.Sample[1].Label = map[string][]string{"role": {"server"}}
+	pprof.Sample[2].Label = map[string][]string{"role": {"test"}}
+
+	files := map[string]string{
+		"example.com/mysvc.(*Server).Serve":          "/src/mysvc/server.go",
+		"example.com/mysvc.newTestServer":            "/src/mysvc/server_test.go",
+		"example.com/mysvc/api.(*Request).Unmarshal": "/src/mysvc/api/request.pb.go",
+		"example.com/mysvc/api.decode":               "/src/mysvc/api/request.pb.go",
+		"example.com/mysvc/db.(*Conn).Query":         "/src/mysvc/db/conn.go",
+	}
+	binary := &profile.Mapping{ID: 1, File: "/usr/bin/mysvc"}
+	libpq := &profile.Mapping{ID: 2, File: "/usr/lib/libpq.so.5"}
+	pprof.Mapping = []*profile.Mapping{binary, libpq}
+	for _, location := range pprof.Location {
+		function := location.Line[0].Function
+		function.Filename = files[function.Name]
+		location.Mapping = binary
+		if function.Name == "PQexec" {
+			location.Mapping = libpq
+		}
+	}
+	return pprofsv.NewProfile(pprof)
+}
+
+func TestFilter(t *testing.T) {
+	p := filterProfile()
+	tests := map[string]struct {
+		filter    pprofsv.Filter
+		functions []string
+	}{
+		"Package": {
+			filter:    pprofsv.Package("example.com/mysvc"),
+			functions: []string{"example.com/mysvc.(*Server).Serve", "example.com/mysvc.newTestServer"},
+		},
+		"Except": {
+			filter:    pprofsv.Except(pprofsv.Package("example.com/mysvc/..."), pprofsv.File("*.pb.go"), pprofsv.File("*_test.go")),
+			functions: []string{"example.com/mysvc.(*Server).Serve", "example.com/mysvc/db.(*Conn).Query"},
+		},
+		"FilePath": {
+			filter:    pprofsv.File("/src/mysvc/api/*"),
+			functions: []string{"example.com/mysvc/api.(*Request).Unmarshal", "example.com/mysvc/api.decode"},
+		},
+		"Receiver": {
+			filter:    pprofsv.Or(pprofsv.Receiver("Conn"), pprofsv.Receiver("Request")),
+			functions: []string{"example.com/mysvc/api.(*Request).Unmarshal", "example.com/mysvc/db.(*Conn).Query"},
+		},
+		"Mapping": {
+			filter:    pprofsv.Mapping("libpq.so*"),
+			functions: []string{"PQexec"},
+		},
+		"Function": {
+			filter: pprofsv.FunctionFilter(func(key pprofsv.FunctionKey) bool {
+				return key.Filename == ""
+			}),
+			functions: []string{"PQexec", "main.main", "testing.tRunner"},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			verifier, err := p.Verifier("", pprofsv.WithFilter(test.filter))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if functions := verifier.Functions(); !slices.Equal(functions, test.functions) {
+				t.Errorf("%s: expected %v, got %v", test.filter, test.functions, functions)
+			}
+		})
+	}
+}
+
+func TestFilterReceiverClosures(t *testing.T) {
+	p := pprofsv.NewProfile(syntheticProfile(
+		"main.main;pkg.glob..func1;pkg.F.deferwrap1;pkg.F.gowrap2;pkg.F.func3;pkg.T.M.gowrap1;pkg.(*T).N.func1",
+	))
+	for typeName, expected := range map[string][]string{
+		"glob": nil,
+		"F":    nil,
+		"T":    {"pkg.(*T).N.func1", "pkg.T.M.gowrap1"},
+	} {
+		verifier, err := p.Verifier("", pprofsv.WithFilter(pprofsv.Receiver(typeName)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var functions []string
+		if verifier != nil {
+			functions = verifier.Functions()
+		}
+		if !slices.Equal(functions, expected) {
+			t.Errorf("receiver %s: expected %v, got %v", typeName, expected, functions)
+		}
+	}
+}
+
+func TestFilterSamples(t *testing.T) {
+	p := filterProfile()
+	// the server reaches the database, and the tests start the server
+	verifier, err := p.Verifier("", pprofsv.WithFilter(pprofsv.Or(
+		pprofsv.And(pprofsv.Labels(pprofsv.MustParseLabelSelector("role=server")), pprofsv.Package("example.com/mysvc/...")),
+		pprofsv.And(pprofsv.Labels(pprofsv.MustParseLabelSelector("role=test")), pprofsv.Match(`^testing\.`)),
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verifier.Reachable("example.com/mysvc.(*Server).Serve", "example.com/mysvc/db.(*Conn).Query") {
+		t.Errorf("Serve -> Query should be reachable")
+	}
+	if _, err := verifier.CheckReachable("testing.tRunner", "example.com/mysvc.(*Server).Serve"); err != nil {
+		t.Errorf("expected tRunner and Serve to be found, got %v", err)
+	}
+	if verifier.Reachable("testing.tRunner", "example.com/mysvc.(*Server).Serve") {
+		t.Errorf("tRunner -> Serve should not be reachable, Serve is excluded from the tests")
+	}
+
+	// only the tests start the server
+	tests := pprofsv.Not(pprofsv.Labels(pprofsv.MustParseLabelSelector("role=server")))
+	verifier, err = p.Verifier("", pprofsv.WithFilter(pprofsv.And(tests, pprofsv.Package("example.com/mysvc"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verifier.Reachable("example.com/mysvc.newTestServer", "example.com/mysvc.(*Server).Serve") {
+		t.Errorf("newTestServer -> Serve should be reachable in the tests")
+	}
+	verifier, err = p.Verifier("", pprofsv.WithFilter(pprofsv.Not(tests)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifier.Reachable("example.com/mysvc.newTestServer", "example.com/mysvc.(*Server).Serve") {
+		t.Errorf("newTestServer -> Serve should not be reachable in the server")
+	}
+}
+
+func TestFilterSubVerifier(t *testing.T) {
+	p := filterProfile()
+	verifier, err := p.Verifier(`^example\.com/`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := verifier.SubVerifier("", pprofsv.WithFilter(pprofsv.Not(pprofsv.File("*.pb.go"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"example.com/mysvc.(*Server).Serve", "example.com/mysvc.newTestServer", "example.com/mysvc/db.(*Conn).Query"}
+	if functions := sub.Functions(); !slices.Equal(functions, expected) {
+		t.Errorf("expected %v, got %v", expected, functions)
+	}
+
+	// the filter cannot add functions the Verifier does not have
+	sub, err = verifier.SubVerifier("", pprofsv.WithFilter(pprofsv.Package("main")))
+	if err != nil || sub != nil {
+		t.Errorf("expected no verifier, got %v, %v", sub, err)
+	}
+
+	// the name pattern and the filters must all match
+	sub, err = verifier.SubVerifier(`\.Serve$`, pprofsv.WithLabelSelector(pprofsv.MustParseLabelSelector("role=test")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if functions := sub.Functions(); !slices.Equal(functions, []string{"example.com/mysvc.(*Server).Serve"}) {
+		t.Errorf("expected only Serve, got %v", functions)
+	}
+	if samples := len(sub.Callstack()); samples != 1 {
+		t.Errorf("expected the call stack of the test only, got %d", samples)
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+	p := filterProfile()
+	for _, filter := range []pprofsv.Filter{
+		pprofsv.Match("("),
+		pprofsv.File("["),
+		pprofsv.And(pprofsv.Package("main"), nil),
+	} {
+		if _, err := p.Verifier("", pprofsv.WithFilter(filter)); err == nil {
+			t.Errorf("%s: expected an error", filter)
+		}
+	}
+
+	// base call stacks carry no labels
+	if _, err := pprofsv.NewVerifier(p, [][]uint64{{1}}, "", pprofsv.WithFilter(pprofsv.Not(pprofsv.Labels(pprofsv.MustParseLabelSelector("role=test"))))); err == nil {
+		t.Errorf("expected an error with base call stacks")
+	}
+}
+
+func TestFilterString(t *testing.T) {
+	filter := pprofsv.Except(pprofsv.Package("example.com/mysvc/..."), pprofsv.File("*.pb.go"), pprofsv.File("*_test.go"))
+	expected := "and(package(example.com/mysvc/...), not(or(file(*.pb.go), file(*_test.go))))"
+	if filter.String() != expected {
+		t.Errorf("expected %q, got %q", expected, filter.String())
+	}
+}
@@ -0,0 +1,80 @@
+package pprofsv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// foldedAnnotations are the suffixes the flame graph tools append to frame
+// names, e.g. with stackcollapse-perf.pl --kernel --jit --inline.
+var foldedAnnotations = []string{"_[k]", "_[j]", "_[i]", "_[w]"}
+
+// ParseFolded parses folded (collapsed) stacks, as produced by the flame
+// graph tools (e.g. stackcollapse-perf.pl) or by many profilers, into a
+// pprof profile that NewProfile accepts. Every line holds a stack, from the
+// root to the leaf, separated by ';', followed by a space and its count:
+//
+//	main;handle;parse 42
+//
+// Each line becomes a sample, with the count as its samples/count value.
+// Annotations such as "_[k]" for kernel frames are stripped from the
+// names, and frames annotated with "_[i]" are inlined into the frame before
+// them. Empty lines and lines starting with '#' are ignored.
+func ParseFolded(r io.Reader) (*profile.Profile, error) {
+	b := newStackBuilder(&profile.ValueType{Type: "samples", Unit: "count"})
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		i := strings.LastIndexByte(text, ' ')
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: expected a stack and a count", lineNumber)
+		}
+		count, err := strconv.ParseInt(text[i+1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid count: %w", lineNumber, err)
+		}
+
+		frames := strings.Split(strings.TrimSpace(text[:i]), ";")
+		sample := &profile.Sample{Value: []int64{count}}
+		var lines []profile.Line // leaf first, the last one is a real frame
+		for k := len(frames) - 1; k >= 0; k-- {
+			name, inlined := frames[k], false
+			for _, annotation := range foldedAnnotations {
+				if trimmed, ok := strings.CutSuffix(name, annotation); ok {
+					name, inlined = trimmed, annotation == "_[i]"
+					break
+				}
+			}
+			if name == "" {
+				return nil, fmt.Errorf("line %d: empty frame", lineNumber)
+			}
+
+			lines = append(lines, profile.Line{Function: b.function(name, "")})
+			if !inlined || k == 0 {
+				sample.Location = append(sample.Location, b.location(nil, 0, lines...))
+				lines = nil
+			}
+		}
+		b.p.Sample = append(b.p.Sample, sample)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(b.p.Sample) == 0 {
+		return nil, ErrNoStacks
+	}
+	return b.p, nil
+}
@@ -0,0 +1,73 @@
+package pprofsv_test
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gaukas/pprofsv"
+)
+
+func TestParseFolded(t *testing.T) {
+	file, err := os.Open("testdata/stacks.folded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	pprof, err := pprofsv.ParseFolded(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pprof.Sample) != 6 {
+		t.Fatalf("expected 6 stacks, got %d", len(pprof.Sample))
+	}
+	if count := pprof.Sample[0].Value[0]; count != 40 {
+		t.Errorf("expected a count of 40, got %d", count)
+	}
+	if leaf := pprof.Sample[1].Location[0].Line[0].Function.Name; leaf != "memcpy" {
+		t.Errorf("expected the kernel annotation to be stripped, got %s", leaf)
+	}
+	if lines := pprof.Sample[4].Location[1].Line; len(lines) != 2 || lines[0].Function.Name != "checksum" || lines[1].Function.Name != "handle" {
+		t.Errorf("expected checksum to be inlined into handle, got %+v", lines)
+	}
+
+	verifier, err := pprofsv.NewProfile(pprof).Verifier(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verifier.Reachable("serve", "write") {
+		t.Error("serve -> write should be reachable")
+	}
+	if verifier.Reachable("init", "handle") {
+		t.Error("init -> handle should not be reachable")
+	}
+	if witness, err := verifier.CheckReachablePath("handle", "crc32"); err != nil || witness == nil || witness.String() != "handle -inline-> checksum -call-> crc32" {
+		t.Errorf("expected handle to inline checksum, which calls crc32, got %v (%v)", witness, err)
+	}
+	if share, err := verifier.Share("handle", "parse", "samples"); err != nil || share != 42.0/77 {
+		t.Errorf("expected handle to call parse in 42 of 77 samples, got %v (%v)", share, err)
+	}
+}
+
+func TestParseFoldedErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name, input, err string
+	}{
+		{name: "NoCount", input: "main;serve\n", err: "line 1: expected a stack and a count"},
+		{name: "InvalidCount", input: "# comment\nmain;serve 1.5\n", err: "line 2: invalid count"},
+		{name: "EmptyFrame", input: "main;;serve 3\n", err: "line 1: empty frame"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := pprofsv.ParseFolded(strings.NewReader(tc.input))
+			if err == nil || !strings.HasPrefix(err.Error(), tc.err) {
+				t.Errorf("expected error %q, got %v", tc.err, err)
+			}
+		})
+	}
+
+	if _, err := pprofsv.ParseFolded(strings.NewReader("# nothing\n\n")); !errors.Is(err, pprofsv.ErrNoStacks) {
+		t.Errorf("expected ErrNoStacks, got %v", err)
+	}
+}
@@ -6,3 +6,5 @@ require (
 	github.com/crillab/gophersat v1.3.1
 	github.com/google/pprof v0.0.0-20231101202521-4ca4178f5c7a
 )
+
+require gopkg.in/yaml.v3 v3.0.1
@@ -0,0 +1,172 @@
+package pprofsv
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// Labels set on the samples of a goroutine dump. See ParseGoroutineDump.
+const (
+	// GoroutineStateLabel is the state of the goroutine, e.g. "running",
+	// "chan receive" or "sync.Mutex.Lock".
+	GoroutineStateLabel = "state"
+
+	// GoroutineCreatorLabel is the function that created the goroutine.
+	GoroutineCreatorLabel = "created_by"
+
+	// GoroutineIDLabel is the numeric ID of the goroutine.
+	GoroutineIDLabel = "goroutine"
+
+	// GoroutineWaitLabel is the number of minutes the goroutine has been
+	// blocked for, if the dump reports it.
+	GoroutineWaitLabel = "wait_minutes"
+)
+
+// ParseGoroutineDump parses a text goroutine dump, as written by
+// runtime/pprof's goroutine profile with debug=2, by runtime.Stack with
+// all=true, or by the runtime on a panic or SIGQUIT, into a pprof profile
+// with one sample per goroutine that NewProfile accepts.
+//
+// Samples have the goroutine/count sample type, and the state, creator, ID
+// and wait time of their goroutine as labels (GoroutineStateLabel, etc.),
+// so that a Verifier can be restricted to, e.g., the blocked goroutines
+// with WithLabelSelector. Anything outside the goroutine stacks, such as the
+// panic message or the registers, is ignored.
+//
+// Frames inlined by the compiler, which the dump prints with "(...)" as
+// their arguments, are inlined into the frame that follows, as in a
+// goroutine profile.
+func ParseGoroutineDump(r io.Reader) (*profile.Profile, error) {
+	b := newStackBuilder(&profile.ValueType{Type: "goroutine", Unit: "count"})
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	var sample *profile.Sample // the goroutine being parsed, if any
+	var function string        // the function whose file line comes next
+	var created bool           // whether function is the creator
+	var inline bool            // whether function is inlined
+	var inlined []profile.Line // the frames inlined into the next one
+	flush := func() {
+		// frames inlined into a frame that is not in the dump
+		if len(inlined) > 0 {
+			sample.Location = append(sample.Location, b.location(nil, 0, inlined...))
+			inlined = nil
+		}
+	}
+	for scanner.Scan() {
+		text := scanner.Text()
+
+		switch {
+		case sample == nil:
+			// skip anything but a goroutine header, including a panic
+			// message that happens to start with "goroutine "
+			if sample = parseGoroutineHeader(text); sample != nil {
+				b.p.Sample = append(b.p.Sample, sample)
+			}
+		case text == "":
+			flush()
+			sample, function = nil, ""
+		case strings.HasPrefix(text, "\t"):
+			if function == "" {
+				continue
+			}
+			file, line := parseGoroutineFileLine(text)
+			frame := profile.Line{Function: b.function(function, file), Line: line}
+			switch {
+			case created:
+				sample.Label[GoroutineCreatorLabel] = []string{function}
+			case inline:
+				inlined = append(inlined, frame)
+			default:
+				sample.Location = append(sample.Location, b.location(nil, 0, append(inlined, frame)...))
+				inlined = nil
+			}
+			function = ""
+		case strings.HasPrefix(text, "created by "):
+			flush()
+			function, created = strings.TrimPrefix(text, "created by "), true
+			// since Go 1.21: "created by main.f in goroutine 1"
+			if i := strings.Index(function, " in goroutine "); i >= 0 {
+				function = function[:i]
+			}
+		case strings.HasPrefix(text, "..."):
+			// "...additional frames elided..."
+			flush()
+			function = ""
+		default:
+			function, created = text, false
+			// e.g. "sync.(*Mutex).Lock(...)"
+			inline = strings.HasSuffix(function, "(...)")
+			// strip the arguments, e.g. "main.(*T).f(0xc000010000, {0x0, 0x0})"
+			if i := strings.LastIndexByte(function, '('); i > 0 && strings.HasSuffix(function, ")") {
+				function = function[:i]
+			}
+		}
+	}
+	if sample != nil {
+		flush()
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(b.p.Sample) == 0 {
+		return nil, ErrNoGoroutines
+	}
+	return b.p, nil
+}
+
+// parseGoroutineHeader parses a line such as
+// "goroutine 7 [chan receive, 2 minutes]:" into an empty sample, or returns
+// nil if the line is not a goroutine header.
+func parseGoroutineHeader(text string) *profile.Sample {
+	if !strings.HasPrefix(text, "goroutine ") || !strings.HasSuffix(text, "]:") {
+		return nil
+	}
+	fields := strings.Fields(strings.TrimPrefix(text, "goroutine "))
+	id, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return nil
+	}
+
+	// with GOTRACEBACK=system or higher, the state comes after
+	// "gp=0x... m=0 mp=0x..."
+	start, end := strings.IndexByte(text, '['), len(text)-len("]:")
+	if start < 0 {
+		return nil
+	}
+
+	sample := &profile.Sample{
+		Value:    []int64{1},
+		Label:    make(map[string][]string),
+		NumLabel: map[string][]int64{GoroutineIDLabel: {id}},
+	}
+	for i, field := range strings.Split(text[start+1:end], ", ") {
+		if i == 0 {
+			sample.Label[GoroutineStateLabel] = []string{field}
+		} else if minutes, ok := strings.CutSuffix(field, " minutes"); ok {
+			if n, err := strconv.ParseInt(minutes, 10, 64); err == nil {
+				sample.NumLabel[GoroutineWaitLabel] = []int64{n}
+			}
+		}
+	}
+	return sample
+}
+
+// parseGoroutineFileLine parses a line such as
+// "\t/src/main.go:12 +0x1d" into the file name and line number.
+func parseGoroutineFileLine(text string) (file string, line int64) {
+	text = strings.TrimSpace(text)
+	if i := strings.LastIndex(text, " +0x"); i >= 0 {
+		text = text[:i]
+	}
+	if i := strings.LastIndexByte(text, ':'); i >= 0 {
+		if n, err := strconv.ParseInt(text[i+1:], 10, 64); err == nil {
+			return text[:i], n
+		}
+	}
+	return text, 0
+}
@@ -0,0 +1,136 @@
+package pprofsv_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"runtime/pprof"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gaukas/pprofsv"
+	"github.com/google/pprof/profile"
+)
+
+func TestParseGoroutineDump(t *testing.T) {
+	file, err := os.Open("testdata/goroutines.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	pprof, err := pprofsv.ParseGoroutineDump(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pprof.Sample) != 4 {
+		t.Fatalf("expected 4 goroutines, got %d", len(pprof.Sample))
+	}
+
+	io := pprof.Sample[2]
+	if state := io.Label[pprofsv.GoroutineStateLabel]; !slices.Equal(state, []string{"IO wait"}) {
+		t.Errorf("expected state IO wait, got %v", state)
+	}
+	if creator := io.Label[pprofsv.GoroutineCreatorLabel]; !slices.Equal(creator, []string{"main.(*Server).Start"}) {
+		t.Errorf("expected creator main.(*Server).Start, got %v", creator)
+	}
+	if id, wait := io.NumLabel[pprofsv.GoroutineIDLabel], io.NumLabel[pprofsv.GoroutineWaitLabel]; !slices.Equal(id, []int64{19}) || !slices.Equal(wait, []int64{2}) {
+		t.Errorf("expected goroutine 19 waiting for 2 minutes, got %v and %v", id, wait)
+	}
+	if leaf := io.Location[0].Line[0]; leaf.Function.Name != "internal/poll.runtime_pollWait" || leaf.Function.Filename != "/usr/local/go/src/runtime/netpoll.go" || leaf.Line != 351 {
+		t.Errorf("unexpected leaf frame %+v", leaf)
+	}
+
+	// no goroutine is parked in Read while in a function holding the
+	// server's mutex
+	p := pprofsv.NewProfile(pprof)
+	blocked := p.WithLabels(pprofsv.MustParseLabelSelector("state!=running"))
+	verifier, err := blocked.Verifier(`^main\.|\.Read$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verifier.Reachable("main.(*Server).handle", "net.(*conn).Read") {
+		t.Error("handle -> Read should be reachable")
+	}
+	witness, err := verifier.CheckReachablePath("main.(*Server).readLocked", "internal/poll.(*FD).Read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if witness == nil || witness.String() != "main.(*Server).readLocked -call-> net.(*conn).Read -call-> internal/poll.(*FD).Read" {
+		t.Errorf("expected readLocked to be parked in Read, got %v", witness)
+	}
+	if n := len(verifier.Callstack()); n != 3 {
+		t.Errorf("expected the 3 blocked goroutines, got %d", n)
+	}
+
+	// the frames with "(...)" as arguments are inlined
+	if lines := pprof.Sample[1].Location[2].Line; len(lines) != 3 || lines[0].Function.Name != "internal/sync.(*Mutex).Lock" || lines[2].Function.Name != "main.(*Server).flush" {
+		t.Errorf("expected Lock to be inlined into flush, got %+v", lines)
+	}
+	verifier, err = p.Verifier(`(?i)lock|flush`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind := verifier.NextKind("main.(*Server).flush", "sync.(*Mutex).Lock"); kind != pprofsv.EdgeInline {
+		t.Errorf("expected sync.(*Mutex).Lock to be inlined into flush, got %v", kind)
+	}
+	if kind := verifier.NextKind("internal/sync.(*Mutex).Lock", "internal/sync.(*Mutex).lockSlow"); kind != pprofsv.EdgeCall {
+		t.Errorf("expected internal/sync.(*Mutex).Lock to call lockSlow, got %v", kind)
+	}
+
+	if _, err := pprofsv.ParseGoroutineDump(strings.NewReader("panic: goroutine 1 [running]\n")); !errors.Is(err, pprofsv.ErrNoGoroutines) {
+		t.Errorf("expected ErrNoGoroutines, got %v", err)
+	}
+}
+
+//go:noinline
+func parkLocked(mu *sync.Mutex, ready chan<- struct{}, done <-chan struct{}) {
+	mu.Lock()
+	defer mu.Unlock()
+	ready <- struct{}{}
+	<-done
+}
+
+func TestGoroutineProfile(t *testing.T) {
+	var mu sync.Mutex
+	ready, done := make(chan struct{}), make(chan struct{})
+	go parkLocked(&mu, ready, done)
+	<-ready
+	defer close(done)
+
+	// the goroutine profile has the runtime frames
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	pprof0, err := profile.Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := pprofsv.NewProfile(pprof0).Verifier(`pprofsv_test\.|^runtime\.gopark$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verifier.Reachable("github.com/gaukas/pprofsv_test.parkLocked", "runtime.gopark") {
+		t.Error("parkLocked should be parked")
+	}
+
+	// the dump has the goroutine states instead
+	buf.Reset()
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 2); err != nil {
+		t.Fatal(err)
+	}
+	pprof2, err := pprofsv.ParseGoroutineDump(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err = pprofsv.NewProfile(pprof2).Verifier(`pprofsv_test\.`, pprofsv.WithLabelSelector(pprofsv.MustParseLabelSelector("state=chan receive")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifier == nil || !slices.Contains(verifier.Functions(), "github.com/gaukas/pprofsv_test.parkLocked") {
+		t.Error("parkLocked should be blocked in a channel receive")
+	}
+}
@@ -0,0 +1,206 @@
+package pprofsv
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Granularity is the unit a Verifier collapses functions into: its nodes
+// are whole packages, types or source files rather than functions, so that
+// the same queries check package layering or type-level state machines.
+type Granularity string
+
+const (
+	// GranularityFunction keeps every function as its own node. It is
+	// the default.
+	GranularityFunction Granularity = "function"
+
+	// GranularityReceiver collapses the methods of a type, and the
+	// closures within them, into a node named after the receiver, e.g.
+	// "example.com/mysvc.(*Server)". Value and pointer receivers are
+	// distinct nodes, and functions that are not methods keep their own.
+	GranularityReceiver Granularity = "receiver"
+
+	// GranularityPackage collapses the functions of a Go package into a
+	// node named after its import path, e.g. "example.com/mysvc/db".
+	GranularityPackage Granularity = "package"
+
+	// GranularityFile collapses the functions of a source file into a
+	// node named after its file name. Functions without a file name keep
+	// their own node.
+	GranularityFile Granularity = "file"
+)
+
+// WithGranularity collapses the functions of the Verifier into nodes of
+// the given granularity. The name pattern and the filters still select
+// functions, before they are collapsed. The nodes of a package, type or
+// file are connected if any of their functions are, calls between the
+// functions of a node are dropped, and weights are aggregated over the
+// functions of a node, counting each sample once.
+func WithGranularity(granularity Granularity) VerifierOption {
+	return func(c *verifierConfig) {
+		c.granularity = granularity
+	}
+}
+
+// ParseGranularity parses the name of a Granularity, e.g. "package". An
+// empty name is GranularityFunction.
+func ParseGranularity(name string) (Granularity, error) {
+	switch g := Granularity(name); g {
+	case "":
+		return GranularityFunction, nil
+	case GranularityFunction, GranularityReceiver, GranularityPackage, GranularityFile:
+		return g, nil
+	default:
+		return "", fmt.Errorf("unknown granularity %q", name)
+	}
+}
+
+// node returns the name of the node the function belongs to.
+func (g Granularity) node(key FunctionKey) string {
+	switch g {
+	case GranularityReceiver:
+		if pkg, receiver, ok := splitReceiver(key.Name); ok {
+			return pkg + "." + receiver
+		}
+	case GranularityPackage:
+		if pkg, _ := splitFunctionName(key.Name); pkg != "" {
+			return pkg
+		}
+	case GranularityFile:
+		if key.Filename != "" {
+			return key.Filename
+		}
+	}
+	return key.Name
+}
+
+// closureName matches the names the compiler gives to closures and to the
+// wrappers of deferred and go calls, e.g. "func1", "func1.2", "deferwrap1"
+// or "gowrap2", so that they are not mistaken for methods.
+var closureName = regexp.MustCompile(`^(func|deferwrap|gowrap)?[0-9]+(\.|$)`)
+
+// splitReceiver splits the full name of a method into its package import
+// path and its receiver type, e.g. "example.com/mysvc" and "(*Server)" for
+// "example.com/mysvc.(*Server).Serve". It returns false if the function is
+// not a method.
+func splitReceiver(name string) (importPath, receiver string, ok bool) {
+	importPath, rest := splitFunctionName(name)
+	if importPath == "" || strings.HasPrefix(rest, "glob.") {
+		// e.g. "glob..func1", a closure in a package-level variable
+		return "", "", false
+	}
+
+	if strings.HasPrefix(rest, "(") {
+		end := strings.Index(rest, ").")
+		if end < 0 {
+			return "", "", false
+		}
+		return importPath, rest[:end+1], true
+	}
+
+	// a value receiver, whose type parameters, if any, are "[...]"
+	depth := 0
+	for i := 0; i < len(rest); i++ {
+		switch rest[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case '.':
+			if depth == 0 {
+				if closureName.MatchString(rest[i+1:]) {
+					// e.g. "Serve.func1", a closure of a function
+					return "", "", false
+				}
+				return importPath, rest[:i], true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// groupFunctions returns a Profile whose functions are the nodes of the
+// given granularity that the given functions of p belong to, and the
+// mapping from the ID of each function of p to the ID of its node.
+//
+// Nodes keep the mapping of their functions, so that those of different
+// binaries stay apart. The new Profile shares the samples of p, but not
+// their call stacks, which the Verifier holds: see groupCallStacks.
+func (p *Profile) groupFunctions(granularity Granularity, functionIds []uint64) (*Profile, []uint64) {
+	q := &Profile{
+		functionNameMap: make(map[string]uint64),
+		functionIdMap:   make(map[uint64]string),
+		duplicateNames:  make(map[string][]uint64),
+		functionKeyMap:  make(map[FunctionKey]uint64),
+
+		functionIdKeyMap:     make(map[uint64]FunctionKey),
+		functionQualifiedMap: make(map[string]uint64),
+
+		sourceOffsets:     p.sourceOffsets,
+		sampleTypes:       p.sampleTypes,
+		defaultSampleType: p.defaultSampleType,
+		values:            p.values,
+		labels:            p.labels,
+		numLabels:         p.numLabels,
+		selector:          p.selector,
+		origins:           p.origins,
+		numOrigins:        p.numOrigins,
+	}
+
+	keys := make(map[uint64]FunctionKey, len(functionIds))
+	for _, id := range functionIds {
+		key := p.functionIdKeyMap[id]
+		keys[id] = FunctionKey{Name: granularity.node(key), Mapping: key.Mapping}
+	}
+	// add the nodes in a deterministic order
+	nodes := make([]FunctionKey, 0, len(keys))
+	for _, key := range keys {
+		nodes = append(nodes, key)
+	}
+	slices.SortFunc(nodes, func(a, b FunctionKey) int {
+		return strings.Compare(a.String(), b.String())
+	})
+	for _, node := range nodes {
+		q.addFunction(node)
+	}
+
+	groups := make([]uint64, len(p.functionIdMap)+1)
+	for id, key := range keys {
+		groups[id] = q.functionKeyMap[key]
+	}
+	return q, groups
+}
+
+// groupCallStacks replaces every function in the call stacks by its node,
+// merging the consecutive frames of the same node. The edge between two
+// nodes is an inline expansion if the edge between their frames was.
+func groupCallStacks(callStacks [][]uint64, inlined [][]bool, groups []uint64) ([][]uint64, [][]bool) {
+	groupedCallStacks := make([][]uint64, 0, len(callStacks))
+	groupedInlined := make([][]bool, 0, len(callStacks))
+
+	var interner stackInterner
+	var groupedCallStack []uint64
+	var groupedFlags []bool
+	for i, callStack := range callStacks {
+		groupedCallStack, groupedFlags = groupedCallStack[:0], groupedFlags[:0]
+		for k, function := range callStack {
+			node := groups[function]
+			if len(groupedCallStack) > 0 && groupedCallStack[len(groupedCallStack)-1] == node {
+				continue
+			}
+			if len(groupedFlags) > 0 {
+				groupedFlags[len(groupedFlags)-1] = inlined != nil && inlined[i][k-1]
+			}
+			groupedCallStack = append(groupedCallStack, node)
+			groupedFlags = append(groupedFlags, false)
+		}
+
+		stack, stackInlined := interner.intern(groupedCallStack, groupedFlags)
+		groupedCallStacks = append(groupedCallStacks, stack)
+		groupedInlined = append(groupedInlined, stackInlined)
+	}
+	return groupedCallStacks, groupedInlined
+}
@@ -0,0 +1,132 @@
+package pprofsv_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/gaukas/pprofsv"
+)
+
+func TestGranularityPackage(t *testing.T) {
+	p := filterProfile()
+	verifier, err := p.Verifier("", pprofsv.WithGranularity(pprofsv.GranularityPackage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"PQexec", "example.com/mysvc", "example.com/mysvc/api", "example.com/mysvc/db", "main", "testing"}
+	if functions := verifier.Functions(); !slices.Equal(functions, expected) {
+		t.Errorf("expected %v, got %v", expected, functions)
+	}
+	if !verifier.Next("main", "example.com/mysvc") {
+		t.Errorf("main -> example.com/mysvc should be next")
+	}
+	if !verifier.Next("testing", "example.com/mysvc") {
+		t.Errorf("testing -> example.com/mysvc should be next, through newTestServer")
+	}
+	if !verifier.Reachable("example.com/mysvc", "PQexec") {
+		t.Errorf("example.com/mysvc -> PQexec should be reachable")
+	}
+	// the calls within a package are not edges
+	if verifier.Next("example.com/mysvc", "example.com/mysvc") {
+		t.Errorf("example.com/mysvc should not call itself")
+	}
+	if verifier.Reachable("example.com/mysvc/db", "example.com/mysvc/api") {
+		t.Errorf("example.com/mysvc/db -> example.com/mysvc/api should not be reachable")
+	}
+
+	// the test sample holds two functions of example.com/mysvc, and counts
+	// once
+	if weight, err := verifier.Weight("example.com/mysvc", "samples"); err != nil || weight != 3 {
+		t.Errorf("expected weight 3, got %d, %v", weight, err)
+	}
+	if weight, err := verifier.EdgeWeight("main", "example.com/mysvc", "samples"); err != nil || weight != 2 {
+		t.Errorf("expected edge weight 2, got %d, %v", weight, err)
+	}
+}
+
+func TestGranularityReceiver(t *testing.T) {
+	p := pprofsv.NewProfile(syntheticProfile(
+		"main.main;pkg.Func;pkg.Func.func1;pkg.T.Method;pkg.(*List[...]).Push;pkg.(*List[...]).Push.func2;pkg.List[...].Len",
+		"main.main;pkg.glob..func1;pkg.Func.deferwrap1;pkg.Func.gowrap2;pkg.T.Method.gowrap1",
+	))
+	verifier, err := p.Verifier("", pprofsv.WithGranularity(pprofsv.GranularityReceiver))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// closures and the wrappers of deferred and go calls belong to their
+	// function, or to the receiver of their method
+	expected := []string{"main.main", "pkg.(*List[...])", "pkg.Func", "pkg.Func.deferwrap1", "pkg.Func.func1", "pkg.Func.gowrap2", "pkg.List[...]", "pkg.T", "pkg.glob..func1"}
+	if functions := verifier.Functions(); !slices.Equal(functions, expected) {
+		t.Errorf("expected %v, got %v", expected, functions)
+	}
+	verifier.SetFunctionPrefix("pkg.")
+	if !verifier.Next("T", "(*List[...])") {
+		t.Errorf("T -> (*List[...]) should be next")
+	}
+	if !verifier.Next("(*List[...])", "List[...]") {
+		t.Errorf("(*List[...]) -> List[...] should be next")
+	}
+}
+
+func TestGranularityFile(t *testing.T) {
+	p := filterProfile()
+	// the filters select functions, before they are collapsed
+	verifier, err := p.Verifier("", pprofsv.WithGranularity(pprofsv.GranularityFile), pprofsv.WithFilter(pprofsv.Package("example.com/mysvc/...")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"/src/mysvc/api/request.pb.go", "/src/mysvc/db/conn.go", "/src/mysvc/server.go", "/src/mysvc/server_test.go"}
+	if functions := verifier.Functions(); !slices.Equal(functions, expected) {
+		t.Errorf("expected %v, got %v", expected, functions)
+	}
+	if !verifier.Next("/src/mysvc/server.go", "/src/mysvc/api/request.pb.go") {
+		t.Errorf("server.go -> request.pb.go should be next")
+	}
+	if !verifier.Next("/src/mysvc/server_test.go", "/src/mysvc/server.go") {
+		t.Errorf("server_test.go -> server.go should be next")
+	}
+
+	// sub-verifiers select nodes
+	sub, err := verifier.SubVerifier(`server`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if functions := sub.Functions(); !slices.Equal(functions, expected[2:]) {
+		t.Errorf("expected %v, got %v", expected[2:], functions)
+	}
+}
+
+func TestGranularityDummy(t *testing.T) {
+	pprof := loadProfile(t, "testdata/pprof.profile")
+
+	verifier, err := pprofsv.NewProfile(pprof).Verifier("dummy", pprofsv.WithGranularity(pprofsv.GranularityReceiver))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier.SetFunctionPrefix("github.com/gaukas/pprofsv/")
+	if !verifier.Next("dummy_test.BenchmarkDummyDeepFunc", "dummy.(*Dummy)") {
+		t.Errorf("BenchmarkDummyDeepFunc -> (*Dummy) should be next")
+	}
+	if verifier.Reachable("dummy.(*Dummy)", "dummy_test.BenchmarkDummyDeepFunc") {
+		t.Errorf("(*Dummy) -> BenchmarkDummyDeepFunc should not be reachable")
+	}
+	if cycles := verifier.Cycles(); len(cycles) != 0 {
+		t.Errorf("expected no cycle, the recursion is within (*Dummy), got %v", cycles)
+	}
+}
+
+func TestGranularityUnknown(t *testing.T) {
+	p := filterProfile()
+	if _, err := p.Verifier("", pprofsv.WithGranularity("module")); err == nil {
+		t.Errorf("expected an error with an unknown granularity")
+	}
+	if _, err := pprofsv.ParseGranularity("module"); err == nil {
+		t.Errorf("expected an error with an unknown granularity")
+	}
+	if g, err := pprofsv.ParseGranularity(""); err != nil || g != pprofsv.GranularityFunction {
+		t.Errorf("expected the function granularity, got %q, %v", g, err)
+	}
+}
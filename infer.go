@@ -0,0 +1,367 @@
+package pprofsv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// InferOption configures InferModel and InferSpec.
+type InferOption func(*inferConfig)
+
+type inferConfig struct {
+	prefix     string
+	sampleType string
+	tolerance  float64
+	minSamples int
+}
+
+// WithInferredPrefix sets the prefix stripped from the inferred state
+// names. By default, the longest common prefix of the functions, up to a
+// '.' or '/', is used, e.g. "github.com/gaukas/pprofsv/dummy.(*Dummy).".
+func WithInferredPrefix(prefix string) InferOption {
+	return func(c *inferConfig) {
+		c.prefix = prefix
+	}
+}
+
+// WithShareSampleType selects the sample type the shares of the inferred
+// share assertions are in, rather than the profiles' default one.
+func WithShareSampleType(sampleType string) InferOption {
+	return func(c *inferConfig) {
+		c.sampleType = sampleType
+	}
+}
+
+// WithShareTolerance widens the bounds of the inferred share assertions by
+// tolerance on both sides of the observed shares. It defaults to 0.05.
+func WithShareTolerance(tolerance float64) InferOption {
+	return func(c *inferConfig) {
+		c.tolerance = tolerance
+	}
+}
+
+// WithMinSamples drops the transitions observed in fewer than n samples
+// across all the profiles, e.g. to ignore rare transitions as noise.
+func WithMinSamples(n int) InferOption {
+	return func(c *inferConfig) {
+		c.minSamples = n
+	}
+}
+
+// inference is what InferModel and InferSpec mine from the profiles.
+type inference struct {
+	pattern string
+	prefix  string
+
+	states []string // full names, sorted
+	edges  []inferredEdge
+
+	// weighted is true if every profile has the sample type the shares
+	// are in.
+	weighted bool
+}
+
+// inferredEdge is an observed direct path, with the range of the shares
+// (see Verifier.Share) it has in the profiles where `from` has a weight.
+type inferredEdge struct {
+	from, to           string // full names
+	samples            int
+	minShare, maxShare float64
+}
+
+// infer builds a Verifier from every profile and collects their functions
+// and direct paths.
+func infer(pattern string, profiles []*Profile, opts []InferOption) (*inference, error) {
+	config := inferConfig{tolerance: 0.05}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if config.tolerance < 0 {
+		return nil, fmt.Errorf("negative share tolerance %g", config.tolerance)
+	}
+
+	type profileWeights struct {
+		nodes map[string]int64
+		edges map[[2]string]int64
+	}
+
+	states := make(map[string]bool)
+	samples := make(map[[2]string]int)
+	var weights []profileWeights
+	weighted := true
+	for _, p := range profiles {
+		v, err := p.Verifier(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			continue
+		}
+
+		t := -1
+		if weighted {
+			if t, err = v.sampleTypeIndex(config.sampleType); errors.Is(err, ErrNoSampleValues) {
+				weighted = false
+			} else if err != nil {
+				return nil, err
+			}
+		}
+
+		edgeSamples := v.edgeSampleCounts()
+		w := profileWeights{nodes: make(map[string]int64), edges: make(map[[2]string]int64)}
+		for from := 0; from < v.path.n; from++ {
+			states[v.name(from)] = true
+			if weighted {
+				w.nodes[v.name(from)] = v.nodeValues[from][t]
+			}
+			for _, to := range v.path.successors(from) {
+				key := [2]string{v.name(from), v.name(to)}
+				samples[key] += edgeSamples[[2]uint64{v.pseudoFunctionIdMap[uint64(from)], v.pseudoFunctionIdMap[uint64(to)]}]
+				if weighted {
+					w.edges[key] = v.path.DirectPathValue(from, to, t)
+				}
+			}
+		}
+		weights = append(weights, w)
+	}
+	if len(states) == 0 {
+		return nil, fmt.Errorf("no call stacks match pattern %q", pattern)
+	}
+
+	inf := &inference{pattern: pattern, weighted: weighted}
+	for state := range states {
+		inf.states = append(inf.states, state)
+	}
+	sort.Strings(inf.states)
+
+	if config.prefix == "" {
+		inf.prefix = commonPrefix(inf.states)
+	} else {
+		inf.prefix = config.prefix
+		for _, state := range inf.states {
+			if !strings.HasPrefix(state, inf.prefix) {
+				return nil, fmt.Errorf("function %s does not start with prefix %s", state, inf.prefix)
+			}
+		}
+	}
+
+	for key, n := range samples {
+		if n < config.minSamples {
+			continue
+		}
+		edge := inferredEdge{from: key[0], to: key[1], samples: n, minShare: math.Inf(1), maxShare: math.Inf(-1)}
+		if weighted {
+			for _, w := range weights {
+				if w.nodes[edge.from] == 0 {
+					continue
+				}
+				share := float64(w.edges[key]) / float64(w.nodes[edge.from])
+				edge.minShare = min(edge.minShare, share-config.tolerance)
+				edge.maxShare = max(edge.maxShare, share+config.tolerance)
+			}
+		}
+		inf.edges = append(inf.edges, edge)
+	}
+	sort.Slice(inf.edges, func(i, j int) bool {
+		if inf.edges[i].from != inf.edges[j].from {
+			return inf.edges[i].from < inf.edges[j].from
+		}
+		return inf.edges[i].to < inf.edges[j].to
+	})
+
+	return inf, nil
+}
+
+// commonPrefix returns the longest common prefix of the sorted names that
+// ends with a '.' or '/'.
+func commonPrefix(names []string) string {
+	first, last := names[0], names[len(names)-1]
+	n := 0
+	for n < len(first) && n < len(last) && first[n] == last[n] {
+		n++
+	}
+	return first[:strings.LastIndexAny(first[:n], "./")+1]
+}
+
+// state strips the prefix from a full function name.
+func (inf *inference) state(name string) string {
+	return strings.TrimPrefix(name, inf.prefix)
+}
+
+// InferModel mines a candidate Model from the direct paths observed
+// between the functions matching the name pattern in any of the profiles.
+// Every observed function becomes a state and every observed direct path a
+// transition, annotated with the number of samples it was observed in.
+//
+// If exactly one state has outgoing but no incoming transitions, it is the
+// initial state of the Model. The Model is meant to be reviewed, approved
+// as a baseline and tightened, e.g. with Verifier.Conformance.
+func InferModel(pattern string, profiles []*Profile, opts ...InferOption) (*Model, error) {
+	inf, err := infer(pattern, profiles, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Model{Prefix: inf.prefix}
+	for _, name := range inf.states {
+		m.States = append(m.States, inf.state(name))
+	}
+
+	incoming := make(map[string]bool)
+	for _, edge := range inf.edges {
+		m.Transitions = append(m.Transitions, Transition{
+			From:    inf.state(edge.from),
+			To:      inf.state(edge.to),
+			Samples: edge.samples,
+		})
+		incoming[edge.to] = true
+	}
+
+	var initial []string
+	for _, edge := range inf.edges {
+		if !incoming[edge.from] && (len(initial) == 0 || initial[len(initial)-1] != edge.from) {
+			initial = append(initial, edge.from)
+		}
+	}
+	if len(initial) == 1 {
+		m.Initial = inf.state(initial[0])
+	}
+
+	return m, nil
+}
+
+// InferSpec is like InferModel, but mines a candidate Spec: a next
+// assertion for every observed direct path and, if the profiles have
+// sample values, a share assertion bounding the share of the path with
+// the range observed across the profiles, widened by the share tolerance.
+// Bounds that would not constrain the share (0 and 1) are left out.
+func InferSpec(pattern string, profiles []*Profile, opts ...InferOption) (*Spec, error) {
+	inf, err := infer(pattern, profiles, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Spec{Pattern: inf.pattern, Prefix: inf.prefix}
+	for _, edge := range inf.edges {
+		from, to := inf.state(edge.from), inf.state(edge.to)
+		s.Assertions = append(s.Assertions, Assertion{Kind: AssertNext, From: from, To: to})
+		if !inf.weighted || math.IsInf(edge.minShare, 1) {
+			continue
+		}
+
+		share := Assertion{Kind: AssertShare, From: from, To: to}
+		// round outwards to 3 decimals, so that the observed shares stay
+		// within bounds, ignoring floating-point noise
+		if low := math.Floor(edge.minShare*1000+1e-6) / 1000; low > 0 {
+			share.Min = Bound(low)
+		}
+		if high := math.Ceil(edge.maxShare*1000-1e-6) / 1000; high < 1 {
+			share.Max = Bound(high)
+		}
+		if share.Min != nil || share.Max != nil {
+			s.Assertions = append(s.Assertions, share)
+		}
+	}
+	return s, nil
+}
+
+// assertionKindNames maps the assertion kinds to the names of their
+// constants, for WriteGo.
+var assertionKindNames = map[AssertionKind]string{
+	AssertReachable:         "AssertReachable",
+	AssertNotReachable:      "AssertNotReachable",
+	AssertNext:              "AssertNext",
+	AssertNotNext:           "AssertNotNext",
+	AssertReachableAvoiding: "AssertReachableAvoiding",
+	AssertCTL:               "AssertCTL",
+	AssertShare:             "AssertShare",
+	AssertNoRecursion:       "AssertNoRecursion",
+	AssertMustPassThrough:   "AssertMustPassThrough",
+	AssertBefore:            "AssertBefore",
+	AssertEventually:        "AssertEventually",
+	AssertNeverAfter:        "AssertNeverAfter",
+}
+
+// granularityNames maps the granularities to the names of their
+// constants, for WriteGo.
+var granularityNames = map[Granularity]string{
+	GranularityFunction: "GranularityFunction",
+	GranularityReceiver: "GranularityReceiver",
+	GranularityPackage:  "GranularityPackage",
+	GranularityFile:     "GranularityFile",
+}
+
+// WriteGo writes the Spec as a Go source file of package pkg declaring it
+// as the variable name, e.g. to check an inferred Spec in next to the
+// tests that verify it.
+func (s *Spec) WriteGo(w io.Writer, pkg, name string) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "package %s\n\n", pkg)
+	b.WriteString("import \"github.com/gaukas/pprofsv\"\n\n")
+	fmt.Fprintf(&b, "var %s = &pprofsv.Spec{\n", name)
+	fmt.Fprintf(&b, "Pattern: %q,\n", s.Pattern)
+	if s.Prefix != "" {
+		fmt.Fprintf(&b, "Prefix: %q,\n", s.Prefix)
+	}
+	if s.Labels != "" {
+		fmt.Fprintf(&b, "Labels: %q,\n", s.Labels)
+	}
+	if s.Granularity != "" {
+		if granularity, ok := granularityNames[s.Granularity]; ok {
+			fmt.Fprintf(&b, "Granularity: pprofsv.%s,\n", granularity)
+		} else {
+			fmt.Fprintf(&b, "Granularity: pprofsv.Granularity(%q),\n", s.Granularity)
+		}
+	}
+	b.WriteString("Assertions: []pprofsv.Assertion{\n")
+	for _, a := range s.Assertions {
+		b.WriteString("{")
+		fields := make([]string, 0, 4)
+		if a.Name != "" {
+			fields = append(fields, fmt.Sprintf("Name: %q", a.Name))
+		}
+		if kind, ok := assertionKindNames[a.Kind]; ok {
+			fields = append(fields, "Kind: pprofsv."+kind)
+		} else {
+			fields = append(fields, fmt.Sprintf("Kind: pprofsv.AssertionKind(%q)", a.Kind))
+		}
+		for _, field := range []struct{ name, value string }{
+			{"From", a.From}, {"To", a.To}, {"Formula", a.Formula},
+			{"SampleType", a.SampleType}, {"Match", a.Match}, {"Via", a.Via},
+		} {
+			if field.value != "" {
+				fields = append(fields, fmt.Sprintf("%s: %q", field.name, field.value))
+			}
+		}
+		if len(a.Avoid) > 0 {
+			avoid := make([]string, 0, len(a.Avoid))
+			for _, function := range a.Avoid {
+				avoid = append(avoid, strconv.Quote(function))
+			}
+			fields = append(fields, fmt.Sprintf("Avoid: []string{%s}", strings.Join(avoid, ", ")))
+		}
+		if a.Min != nil {
+			fields = append(fields, fmt.Sprintf("Min: pprofsv.Bound(%s)", strconv.FormatFloat(*a.Min, 'g', -1, 64)))
+		}
+		if a.Max != nil {
+			fields = append(fields, fmt.Sprintf("Max: pprofsv.Bound(%s)", strconv.FormatFloat(*a.Max, 'g', -1, 64)))
+		}
+		b.WriteString(strings.Join(fields, ", "))
+		b.WriteString("},\n")
+	}
+	b.WriteString("},\n}\n")
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(src)
+	return err
+}
@@ -0,0 +1,165 @@
+package pprofsv_test
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gaukas/pprofsv"
+)
+
+func TestInferModel(t *testing.T) {
+	pprof := loadProfile(t, "testdata/pprof.profile")
+	p := pprofsv.NewProfile(pprof)
+
+	model, err := pprofsv.InferModel(`\(\*Dummy\)\.([Bb]ranch|final)`, []*pprofsv.Profile{p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model.Prefix != "github.com/gaukas/pprofsv/dummy.(*Dummy)." {
+		t.Errorf("unexpected prefix %q", model.Prefix)
+	}
+	if model.Initial != "BranchFunc" {
+		t.Errorf("expected initial state BranchFunc, got %q", model.Initial)
+	}
+	if err := model.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	i := slices.IndexFunc(model.Transitions, func(t pprofsv.Transition) bool {
+		return t.From == "BranchFunc" && t.To == "branchA"
+	})
+	if i < 0 || model.Transitions[i].Samples == 0 {
+		t.Errorf("expected BranchFunc -> branchA to be observed, got %+v", model.Transitions)
+	}
+
+	// the inferred model is a baseline the profile conforms to
+	verifier, err := p.Verifier("dummy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := verifier.Conformance(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Conforms() || len(report.Gaps) > 0 || len(report.Unreached) > 0 {
+		t.Errorf("expected full conformance, got %+v", report)
+	}
+}
+
+func TestInferSpec(t *testing.T) {
+	var (
+		first  = pprofsv.NewProfile(syntheticProfile("pkg.main;pkg.a;pkg.b", "pkg.main;pkg.a;pkg.b", "pkg.main;pkg.a;pkg.b", "pkg.main;pkg.c"))
+		second = pprofsv.NewProfile(syntheticProfile("pkg.main;pkg.a;pkg.b", "pkg.main;pkg.a", "pkg.main;pkg.c", "pkg.main;pkg.c"))
+	)
+
+	spec, err := pprofsv.InferSpec("pkg", []*pprofsv.Profile{first, second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Pattern != "pkg" || spec.Prefix != "pkg." {
+		t.Errorf("unexpected pattern %q and prefix %q", spec.Pattern, spec.Prefix)
+	}
+
+	var got []string
+	for _, a := range spec.Assertions {
+		got = append(got, a.String())
+	}
+	want := []string{
+		"next(a, b)",
+		"share(a, b, min=0.45)",
+		"next(main, a)",
+		"share(main, a, min=0.45, max=0.8)",
+		"next(main, c)",
+		"share(main, c, min=0.2, max=0.55)",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected assertions %q, got %q", want, got)
+	}
+
+	for _, p := range []*pprofsv.Profile{first, second} {
+		report, err := spec.Verify(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if failures := report.Failures(); len(failures) > 0 {
+			t.Errorf("inferred spec fails on its own profile: %v", failures)
+		}
+	}
+
+	// transitions in fewer samples are dropped
+	spec, err = pprofsv.InferSpec("pkg", []*pprofsv.Profile{first, second}, pprofsv.WithMinSamples(4), pprofsv.WithShareTolerance(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = got[:0]
+	for _, a := range spec.Assertions {
+		got = append(got, a.String())
+	}
+	want = []string{"next(a, b)", "share(a, b, min=0.5)", "next(main, a)", "share(main, a, min=0.5, max=0.75)"}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected assertions %q, got %q", want, got)
+	}
+
+	if _, err := pprofsv.InferSpec("pkg", []*pprofsv.Profile{first}, pprofsv.WithInferredPrefix("main.")); err == nil {
+		t.Error("expected an error for a prefix the functions do not start with")
+	}
+	if _, err := pprofsv.InferSpec("nothing", []*pprofsv.Profile{first}); err == nil {
+		t.Error("expected an error for a pattern matching no function")
+	}
+}
+
+func TestSpecWriteGo(t *testing.T) {
+	spec := &pprofsv.Spec{
+		Pattern:     "dummy",
+		Prefix:      "github.com/gaukas/pprofsv/dummy.(*Dummy).",
+		Labels:      "role=server",
+		Granularity: pprofsv.GranularityReceiver,
+		Assertions: []pprofsv.Assertion{
+			{Kind: pprofsv.AssertNext, From: "BranchFunc", To: "branchA"},
+			{Kind: pprofsv.AssertShare, From: "BranchFunc", To: "branchA", Min: pprofsv.Bound(0.4)},
+			{Kind: pprofsv.AssertReachableAvoiding, From: "MultiFunc", To: "final", Avoid: []string{"multiFuncA", "multiFuncB"}},
+		},
+	}
+
+	var b strings.Builder
+	if err := spec.WriteGo(&b, "baseline", "DummySpec"); err != nil {
+		t.Fatal(err)
+	}
+	src := b.String()
+
+	file, err := parser.ParseFile(token.NewFileSet(), "spec.go", src, 0)
+	if err != nil {
+		t.Fatalf("generated code does not parse: %v\n%s", err, src)
+	}
+
+	// every field set in the Spec makes it to the generated code
+	keys := make(map[string]bool)
+	lit := file.Decls[1].(*ast.GenDecl).Specs[0].(*ast.ValueSpec).Values[0].(*ast.UnaryExpr).X.(*ast.CompositeLit)
+	for _, elt := range lit.Elts {
+		keys[elt.(*ast.KeyValueExpr).Key.(*ast.Ident).Name] = true
+	}
+	fields := reflect.ValueOf(*spec)
+	for i := 0; i < fields.NumField(); i++ {
+		if name := fields.Type().Field(i).Name; !fields.Field(i).IsZero() && !keys[name] {
+			t.Errorf("expected generated code to set %s, got\n%s", name, src)
+		}
+	}
+	for _, want := range []string{
+		"package baseline",
+		"var DummySpec = &pprofsv.Spec{",
+		`Labels:      "role=server",`,
+		"Granularity: pprofsv.GranularityReceiver,",
+		`{Kind: pprofsv.AssertNext, From: "BranchFunc", To: "branchA"},`,
+		`{Kind: pprofsv.AssertShare, From: "BranchFunc", To: "branchA", Min: pprofsv.Bound(0.4)},`,
+		`Avoid: []string{"multiFuncA", "multiFuncB"}}`,
+	} {
+		if !strings.Contains(src, want) {
+			t.Errorf("expected generated code to contain %q, got\n%s", want, src)
+		}
+	}
+}
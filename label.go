@@ -0,0 +1,217 @@
+package pprofsv
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// LabelSelector selects samples by their pprof labels, i.e., the labels
+// set with runtime/pprof.Do or pprof.SetGoroutineLabels (sample.Label)
+// and the numeric labels (sample.NumLabel) of a profile.
+//
+// A selector is a comma-separated list of matchers, all of which must
+// hold for a sample to be selected:
+//
+//	key=value       the label has the value
+//	key!=value      the label does not have the value, or is not set
+//	key=~regexp     the label has a value fully matching the regexp
+//	key!~regexp     the label has no value fully matching the regexp
+//	key>number      the label has a numeric value greater than number,
+//	                also >=, < and <=
+//
+// The zero LabelSelector selects every sample.
+type LabelSelector struct {
+	matchers []labelMatcher
+}
+
+type labelOp string
+
+const (
+	labelEqual        labelOp = "="
+	labelNotEqual     labelOp = "!="
+	labelMatch        labelOp = "=~"
+	labelNotMatch     labelOp = "!~"
+	labelGreater      labelOp = ">"
+	labelGreaterEqual labelOp = ">="
+	labelLess         labelOp = "<"
+	labelLessEqual    labelOp = "<="
+)
+
+// labelOps lists the operators so that no operator comes after another one
+// it starts with.
+var labelOps = []labelOp{
+	labelNotEqual, labelMatch, labelNotMatch, labelGreaterEqual, labelLessEqual,
+	labelEqual, labelGreater, labelLess,
+}
+
+type labelMatcher struct {
+	key   string
+	op    labelOp
+	value string
+
+	re     *regexp.Regexp // for labelMatch and labelNotMatch
+	number float64        // for the numeric comparisons
+}
+
+// ParseLabelSelector parses a LabelSelector, e.g. "role=server,tenant!=test".
+// The commas within the braces or brackets of a regular expression, e.g.
+// "state=~a{1,3}", do not separate terms. An empty string selects every
+// sample.
+func ParseLabelSelector(s string) (LabelSelector, error) {
+	var selector LabelSelector
+	if strings.TrimSpace(s) == "" {
+		return selector, nil
+	}
+
+	for _, term := range splitLabelTerms(s) {
+		matcher, err := parseLabelMatcher(strings.TrimSpace(term))
+		if err != nil {
+			return LabelSelector{}, fmt.Errorf("label selector %q: %w", s, err)
+		}
+		selector.matchers = append(selector.matchers, matcher)
+	}
+	return selector, nil
+}
+
+// splitLabelTerms splits a label selector at the commas outside the
+// repetitions, e.g. "{1,3}", and character classes, e.g. "[,;]", of
+// regular expressions.
+func splitLabelTerms(s string) []string {
+	var terms []string
+	var braces int    // the depth of the braces
+	var brackets bool // whether in a character class
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++ // an escaped character
+		case brackets:
+			brackets = c != ']'
+		case c == '[':
+			brackets = true
+		case c == '{':
+			braces++
+		case c == '}' && braces > 0:
+			braces--
+		case c == ',' && braces == 0:
+			terms = append(terms, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(terms, s[start:])
+}
+
+// MustParseLabelSelector is like ParseLabelSelector but panics if the
+// selector cannot be parsed.
+func MustParseLabelSelector(s string) LabelSelector {
+	selector, err := ParseLabelSelector(s)
+	if err != nil {
+		panic(err)
+	}
+	return selector
+}
+
+func parseLabelMatcher(term string) (labelMatcher, error) {
+	i := strings.IndexAny(term, "=!~<>")
+	if i <= 0 {
+		return labelMatcher{}, fmt.Errorf("%q: expected key, operator and value", term)
+	}
+
+	m := labelMatcher{key: strings.TrimSpace(term[:i])}
+	for _, op := range labelOps {
+		if strings.HasPrefix(term[i:], string(op)) {
+			m.op = op
+			break
+		}
+	}
+	if m.op == "" {
+		return labelMatcher{}, fmt.Errorf("%q: unknown operator", term)
+	}
+	m.value = strings.TrimSpace(term[i+len(m.op):])
+
+	var err error
+	switch m.op {
+	case labelMatch, labelNotMatch:
+		m.re, err = regexp.Compile("^(?:" + m.value + ")$")
+	case labelGreater, labelGreaterEqual, labelLess, labelLessEqual:
+		m.number, err = strconv.ParseFloat(m.value, 64)
+	}
+	if err != nil {
+		return labelMatcher{}, fmt.Errorf("%q: %w", term, err)
+	}
+	return m, nil
+}
+
+// String returns the selector in the form accepted by ParseLabelSelector.
+func (s LabelSelector) String() string {
+	terms := make([]string, 0, len(s.matchers))
+	for _, m := range s.matchers {
+		terms = append(terms, m.key+string(m.op)+m.value)
+	}
+	return strings.Join(terms, ",")
+}
+
+// Empty returns true if the selector selects every sample.
+func (s LabelSelector) Empty() bool {
+	return len(s.matchers) == 0
+}
+
+// Matches returns true if a sample with the given labels is selected.
+func (s LabelSelector) Matches(labels map[string][]string, numLabels map[string][]int64) bool {
+	for _, m := range s.matchers {
+		if !m.matches(labels[m.key], numLabels[m.key]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m labelMatcher) matches(values []string, numValues []int64) bool {
+	switch m.op {
+	case labelEqual, labelNotEqual:
+		equal := false
+		for _, value := range values {
+			equal = equal || value == m.value
+		}
+		for _, value := range numValues {
+			equal = equal || strconv.FormatInt(value, 10) == m.value
+		}
+		return equal == (m.op == labelEqual)
+	case labelMatch, labelNotMatch:
+		match := false
+		for _, value := range values {
+			match = match || m.re.MatchString(value)
+		}
+		return match == (m.op == labelMatch)
+	default:
+		// string labels holding numbers are compared as well, since
+		// runtime/pprof only sets string labels.
+		for _, value := range values {
+			if number, err := strconv.ParseFloat(value, 64); err == nil && m.compare(number) {
+				return true
+			}
+		}
+		for _, value := range numValues {
+			if m.compare(float64(value)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func (m labelMatcher) compare(number float64) bool {
+	switch m.op {
+	case labelGreater:
+		return number > m.number
+	case labelGreaterEqual:
+		return number >= m.number
+	case labelLess:
+		return number < m.number
+	case labelLessEqual:
+		return number <= m.number
+	}
+	return false
+}
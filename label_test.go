@@ -0,0 +1,62 @@
+package pprofsv_test
+
+import (
+	"testing"
+
+	"github.com/gaukas/pprofsv"
+)
+
+func TestLabelSelector(t *testing.T) {
+	labels := map[string][]string{
+		"role":   {"server"},
+		"tenant": {"acme", "globex"},
+		"shard":  {"7"},
+	}
+	numLabels := map[string][]int64{
+		"bytes": {4096},
+	}
+
+	for selector, matches := range map[string]bool{
+		"":                            true,
+		"role=server":                 true,
+		"role=client":                 false,
+		"role!=client":                true,
+		"missing!=client":             true,
+		"missing=client":              false,
+		"tenant=globex":               true,
+		"tenant!=acme":                false,
+		"tenant=~glo.*":               true,
+		"tenant=~glo":                 false, // fully anchored
+		"tenant!~initech|umbrella":    true,
+		"bytes=4096":                  true,
+		"bytes>4000":                  true,
+		"bytes<=4096":                 true,
+		"bytes<4096":                  false,
+		"shard>=7":                    true, // string labels holding numbers
+		"shard>7":                     false,
+		"role=server, tenant=~acme":   true,
+		"role=server,tenant=initech":  false,
+		"tenant=~glo(b){1,2}ex":       true, // commas within regular expressions
+		"tenant=~[a,]cme,role=server": true,
+		"tenant=~ac\\,me":             false,
+	} {
+		s, err := pprofsv.ParseLabelSelector(selector)
+		if err != nil {
+			t.Errorf("%q: %v", selector, err)
+			continue
+		}
+		if got := s.Matches(labels, numLabels); got != matches {
+			t.Errorf("%q should match: %v, got %v", selector, matches, got)
+		}
+	}
+
+	for _, selector := range []string{"role", "=server", "role~server", "tenant=~(", "bytes>many"} {
+		if _, err := pprofsv.ParseLabelSelector(selector); err == nil {
+			t.Errorf("%q: expected an error", selector)
+		}
+	}
+
+	if s := pprofsv.MustParseLabelSelector("role=server, bytes>=4096").String(); s != "role=server,bytes>=4096" {
+		t.Errorf("unexpected String: %q", s)
+	}
+}
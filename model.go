@@ -0,0 +1,246 @@
+package pprofsv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Model is a declared state machine, where every state is a function and
+// every transition is a direct path from one function to another.
+//
+// A Model is usually written in YAML (or JSON):
+//
+//	prefix: github.com/gaukas/pprofsv/dummy.(*Dummy).
+//	initial: BranchFunc
+//	states: [BranchFunc, branchA, branchB, sleep]
+//	transitions:
+//	  - {from: BranchFunc, to: branchA}
+//	  - {from: BranchFunc, to: branchB}
+//	  - {from: branchA, to: sleep}
+//	  - {from: branchB, to: sleep}
+type Model struct {
+	// Prefix is prepended to every state name.
+	Prefix string `yaml:"prefix,omitempty" json:"prefix,omitempty"`
+
+	// Initial optionally names the state the machine starts in. See
+	// ConformanceReport.Unreached.
+	Initial string `yaml:"initial,omitempty" json:"initial,omitempty"`
+
+	States      []string     `yaml:"states" json:"states"`
+	Transitions []Transition `yaml:"transitions" json:"transitions"`
+}
+
+// Transition is an allowed transition between two states of a Model.
+type Transition struct {
+	From string `yaml:"from" json:"from"`
+	To   string `yaml:"to" json:"to"`
+
+	// Samples optionally records in how many samples the transition was
+	// observed, e.g. in an inferred Model. See InferModel.
+	Samples int `yaml:"samples,omitempty" json:"samples,omitempty"`
+}
+
+func (t Transition) String() string {
+	return fmt.Sprintf("%s -> %s", t.From, t.To)
+}
+
+// Validate checks that the Model is well-formed: states are unique, and
+// transitions and the initial state only refer to declared states.
+func (m *Model) Validate() error {
+	states := make(map[string]bool, len(m.States))
+	for _, state := range m.States {
+		if state == "" {
+			return errors.New("model: empty state")
+		}
+		if states[state] {
+			return fmt.Errorf("model: duplicate state %s", state)
+		}
+		states[state] = true
+	}
+
+	if m.Initial != "" && !states[m.Initial] {
+		return fmt.Errorf("model: initial state %s is not declared", m.Initial)
+	}
+
+	for _, t := range m.Transitions {
+		if !states[t.From] || !states[t.To] {
+			return fmt.Errorf("model: transition %s refers to an undeclared state", t)
+		}
+	}
+	return nil
+}
+
+// LoadModel reads a Model in YAML or JSON from r and validates it.
+func LoadModel(r io.Reader) (*Model, error) {
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+
+	var model Model
+	if err := dec.Decode(&model); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("empty model")
+		}
+		return nil, err
+	}
+
+	if err := model.Validate(); err != nil {
+		return nil, err
+	}
+	return &model, nil
+}
+
+// LoadModelFile reads a Model in YAML or JSON from the named file.
+func LoadModelFile(name string) (*Model, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	model, err := LoadModel(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return model, nil
+}
+
+// ConformanceReport compares the transitions observed in a profile with
+// a Model. All the functions are named as the states of the Model.
+type ConformanceReport struct {
+	// Violations are the observed transitions the Model does not allow,
+	// with the samples they were observed in.
+	Violations []WitnessEdge `yaml:"violations" json:"violations"`
+
+	// Gaps are the transitions of the Model that were never observed.
+	Gaps []Transition `yaml:"gaps" json:"gaps"`
+
+	// Unreached are the states that were never observed or, if the Model
+	// has an initial state, that are not reachable from it through the
+	// observed transitions.
+	Unreached []string `yaml:"unreached" json:"unreached"`
+}
+
+// Conforms returns true if no transition violates the Model. Gaps and
+// unreached states only reflect the coverage of the profile.
+func (r *ConformanceReport) Conforms() bool {
+	return len(r.Violations) == 0
+}
+
+// Conformance compares the call graph of the Verifier with the Model.
+//
+// The call graph is first reduced to the functions that are states of the
+// Model, so that a transition is observed from state A to state B if A
+// calls B through any functions that are not states. A state that is not
+// in the profile at all is unreached, while a state filtered out by the
+// Verifier is an error.
+func (v *Verifier) Conformance(m *Model) (*ConformanceReport, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+
+	stateIds := make(map[uint64]string, len(m.States)) // real ID -> state
+	functionIds := make([]uint64, 0, len(m.States))
+	for _, state := range m.States {
+		pseudoId, err := v.lookupFullName(m.Prefix + state)
+		if errors.Is(err, ErrFunctionNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		id := v.pseudoFunctionIdMap[uint64(pseudoId)]
+		stateIds[id] = state
+		functionIds = append(functionIds, id)
+	}
+
+	report := &ConformanceReport{}
+	reduced := v.restrict(functionIds)
+
+	allowed := make(map[Transition]bool, len(m.Transitions))
+	for _, t := range m.Transitions {
+		allowed[Transition{From: t.From, To: t.To}] = true
+	}
+
+	observed := make(map[Transition]bool)
+	observedStates := make(map[string]bool)
+	if reduced != nil {
+		for _, callStack := range reduced.callStacks {
+			for _, id := range callStack {
+				observedStates[stateIds[id]] = true
+			}
+		}
+
+		for from := 0; from < reduced.path.n; from++ {
+			for _, to := range reduced.path.successors(from) {
+				fromId, toId := reduced.pseudoFunctionIdMap[uint64(from)], reduced.pseudoFunctionIdMap[uint64(to)]
+				t := Transition{From: stateIds[fromId], To: stateIds[toId]}
+				observed[t] = true
+				if allowed[t] {
+					continue
+				}
+				report.Violations = append(report.Violations, WitnessEdge{
+					From:    t.From,
+					To:      t.To,
+					Kind:    reduced.path.DirectPathKind(from, to),
+					Samples: reduced.edgeSamples(fromId, toId),
+				})
+			}
+		}
+	}
+	sort.Slice(report.Violations, func(i, j int) bool {
+		if report.Violations[i].From != report.Violations[j].From {
+			return report.Violations[i].From < report.Violations[j].From
+		}
+		return report.Violations[i].To < report.Violations[j].To
+	})
+
+	for _, t := range m.Transitions {
+		if !observed[Transition{From: t.From, To: t.To}] {
+			report.Gaps = append(report.Gaps, t)
+		}
+	}
+
+	reached := observedStates
+	if m.Initial != "" {
+		reached = make(map[string]bool)
+		if observedStates[m.Initial] {
+			// breadth-first search through the observed transitions
+			reached[m.Initial] = true
+			queue := []string{m.Initial}
+			for len(queue) > 0 {
+				state := queue[0]
+				queue = queue[1:]
+				for t := range observed {
+					if t.From == state && !reached[t.To] {
+						reached[t.To] = true
+						queue = append(queue, t.To)
+					}
+				}
+			}
+		}
+	}
+	for _, state := range m.States {
+		if !reached[state] {
+			report.Unreached = append(report.Unreached, state)
+		}
+	}
+
+	return report, nil
+}
+
+// restrict returns a new Verifier keeping only the given functions of v,
+// or nil if none of them appears in any call stack.
+func (v *Verifier) restrict(functionIds []uint64) *Verifier {
+	interesting := functionSet(functionIds)
+	callStacks, inlined, samples := reduceCallStacks(v.callStacks, v.inlined, v.samples, func(int) bitset {
+		return interesting
+	})
+	if len(callStacks) == 0 {
+		return nil
+	}
+	return newReducedVerifier(v.masterProfile, callStacks, inlined, samples, functionIds, v.weighted)
+}
@@ -0,0 +1,129 @@
+package pprofsv_test
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gaukas/pprofsv"
+)
+
+func TestVerifierConformance(t *testing.T) {
+	pprof := loadProfile(t, "testdata/pprof.profile")
+
+	verifier, err := pprofsv.NewProfile(pprof).Verifier("dummy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := pprofsv.LoadModelFile("testdata/branch.model.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := verifier.Conformance(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Conforms() || len(report.Gaps) > 0 || len(report.Unreached) > 0 {
+		t.Errorf("expected full conformance, got %+v", report)
+	}
+
+	// forbid branchB -> branchBinner, allow an unobserved branchA -> branchB
+	// and add a state that never runs
+	model.States = append(model.States, "never")
+	model.Transitions = slices.DeleteFunc(model.Transitions, func(t pprofsv.Transition) bool {
+		return t.From == "branchB" && t.To == "branchBinner"
+	})
+	model.Transitions = append(model.Transitions, pprofsv.Transition{From: "branchA", To: "branchB"})
+
+	report, err = verifier.Conformance(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Conforms() || len(report.Violations) != 1 {
+		t.Fatalf("expected a single violation, got %+v", report.Violations)
+	}
+	if violation := report.Violations[0]; violation.From != "branchB" || violation.To != "branchBinner" || len(violation.Samples) == 0 {
+		t.Errorf("unexpected violation %+v", violation)
+	}
+	if !slices.Equal(report.Gaps, []pprofsv.Transition{{From: "branchA", To: "branchB"}}) {
+		t.Errorf("unexpected gaps %v", report.Gaps)
+	}
+
+	// branchBinner, final and alloc are still reached through branchA
+	if !slices.Equal(report.Unreached, []string{"never"}) {
+		t.Errorf("unexpected unreached states %v", report.Unreached)
+	}
+
+	// a state filtered out by the Verifier is an error
+	sub, err := verifier.SubVerifier(`\.branch`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sub.Conformance(model); !errors.Is(err, pprofsv.ErrFunctionFiltered) {
+		t.Errorf("expected BranchFunc to be filtered, got %v", err)
+	}
+}
+
+func TestVerifierConformanceInitial(t *testing.T) {
+	verifier, err := pprofsv.NewProfile(syntheticProfile(
+		"main;start;run;stop",
+		"main;debug;stop",
+	)).Verifier("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model := &pprofsv.Model{
+		States: []string{"start", "run", "stop", "debug"},
+		Transitions: []pprofsv.Transition{
+			{From: "start", To: "run"},
+			{From: "run", To: "stop"},
+			{From: "debug", To: "stop"},
+		},
+	}
+
+	// main is not a state, so main -> start is not a transition
+	report, err := verifier.Conformance(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Conforms() || len(report.Gaps) > 0 || len(report.Unreached) > 0 {
+		t.Errorf("expected full conformance, got %+v", report)
+	}
+
+	// debug is observed, but not reachable from start
+	model.Initial = "start"
+	report, err = verifier.Conformance(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(report.Unreached, []string{"debug"}) {
+		t.Errorf("unexpected unreached states %v", report.Unreached)
+	}
+
+	// run is skipped over when it is not a state
+	model = &pprofsv.Model{
+		States:      []string{"start", "stop"},
+		Transitions: []pprofsv.Transition{{From: "start", To: "stop"}},
+	}
+	if report, err := verifier.Conformance(model); err != nil || !report.Conforms() {
+		t.Errorf("expected start -> stop to conform, got %+v, %v", report, err)
+	}
+}
+
+func TestLoadModelInvalid(t *testing.T) {
+	for name, input := range map[string]string{
+		"Empty":          ``,
+		"UnknownField":   `{"states": ["A"], "edges": []}`,
+		"DuplicateState": `{"states": ["A", "A"]}`,
+		"UnknownInitial": `{"initial": "B", "states": ["A"]}`,
+		"UnknownState":   `{"states": ["A"], "transitions": [{"from": "A", "to": "B"}]}`,
+	} {
+		if _, err := pprofsv.LoadModel(strings.NewReader(input)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
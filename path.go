@@ -2,106 +2,636 @@ package pprofsv
 
 import (
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/crillab/gophersat/bf"
 )
 
+// EdgeKind describes how a direct path between two nodes was observed.
+// A direct path may be observed as both a call and an inline expansion
+// at different call sites, in which case both bits are set.
+type EdgeKind uint8
+
+const (
+	// EdgeCall means the callee was entered through a real call.
+	EdgeCall EdgeKind = 1 << iota
+
+	// EdgeInline means the callee was inlined into the caller by the
+	// compiler.
+	EdgeInline
+)
+
+// String returns a human-readable representation of the EdgeKind.
+func (k EdgeKind) String() string {
+	switch k {
+	case 0:
+		return "none"
+	case EdgeCall:
+		return "call"
+	case EdgeInline:
+		return "inline"
+	case EdgeCall | EdgeInline:
+		return "call|inline"
+	default:
+		return fmt.Sprintf("EdgeKind(%d)", uint8(k))
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (k EdgeKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (k *EdgeKind) UnmarshalText(text []byte) error {
+	for _, kind := range []EdgeKind{0, EdgeCall, EdgeInline, EdgeCall | EdgeInline} {
+		if kind.String() == string(text) {
+			*k = kind
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown edge kind %q", text)
+}
+
 // Path holds a n-by-n matrix representing the path between nodes.
 //
 // Axioms:
-//  1. Path.directPaths[i][i] being true means there is a self-loop at node i.
-//  2. Path.directPaths[i][j] being true means there is a path from i to j.
+//  1. Path.directPaths[i] containing i means there is a self-loop at node i.
+//  2. Path.directPaths[i] containing j means there is a path from i to j.
 //  3. For any i,j,k, if there is a path from i to j ([i][j] is true)
 //     and a path from j to k ([j][k] is true), then there is a path
 //     from i to k no matter whether [i][k] is true or not.
+//
+// Reachability is answered by a bit-parallel breadth-first search over
+// directPaths. The set of nodes reachable from a node is computed on
+// first use and cached in allPaths until a new direct path invalidates it.
+// Reachability while avoiding a set of nodes is cached separately in
+// avoidingPaths, keyed by the source node and the set of skipped nodes,
+// so that it never leaks into unconstrained answers.
 type Path struct {
-	directPaths [][]bool
-	allPaths    [][]bool
+	n int
+
+	directPaths []bitset // directPaths[i] is the set of nodes with a direct path from i
+	edgeKinds   map[[2]int]EdgeKind
+	edgeValues  map[[2]int][]int64 // edgeValues[{i, j}][t] is the accumulated value of sample type t on the edge from i to j
+	allPaths    []bitset           // allPaths[i] is the set of nodes reachable from i, or nil if not yet computed
+
+	avoidingPaths map[avoidingKey]bitset
+
+	satCrossCheck bool
 
 	rw *sync.RWMutex
 }
 
 // NewPath returns a new Path with size n.
 func NewPath(n int) *Path {
-	p := make([][]bool, n)
-	for i := range p {
-		p[i] = make([]bool, n)
-	}
-
-	allPaths := make([][]bool, n)
-	for i := range allPaths {
-		allPaths[i] = make([]bool, n)
+	directPaths := make([]bitset, n)
+	for i := range directPaths {
+		directPaths[i] = newBitset(n)
 	}
 
 	return &Path{
-		directPaths: p,
-		allPaths:    allPaths,
-		rw:          &sync.RWMutex{},
+		n:           n,
+		directPaths: directPaths,
+		edgeKinds:   make(map[[2]int]EdgeKind),
+		edgeValues:  make(map[[2]int][]int64),
+		allPaths:    make([]bitset, n),
+
+		avoidingPaths: make(map[avoidingKey]bitset),
+
+		rw: &sync.RWMutex{},
 	}
 }
 
 // Set sets Path[i][j] to true, which means there is a DIRECT path from i to j.
+//
+// It is equivalent to SetKind(i, j, EdgeCall).
 func (p *Path) Set(i, j int) {
+	p.SetKind(i, j, EdgeCall)
+}
+
+// SetKind records a DIRECT path of the given kind from i to j. Kinds
+// recorded for the same pair of nodes accumulate.
+func (p *Path) SetKind(i, j int, kind EdgeKind) {
 	p.rw.Lock()
 	defer p.rw.Unlock()
-	p.directPaths[i][j] = true
-	p.allPaths[i][j] = true
+	p.edgeKinds[[2]int{i, j}] |= kind
+	if p.directPaths[i].has(j) {
+		return
+	}
+	p.directPaths[i].set(j)
+
+	// Only the nodes that could already reach i gain new reachable nodes,
+	// so the cached reachability of every other node stays valid.
+	for k, reachable := range p.allPaths {
+		if reachable != nil && (k == i || reachable.has(i)) {
+			p.allPaths[k] = nil
+		}
+	}
+	clear(p.avoidingPaths)
 }
 
-// HasPath returns true if there is a
+// SetSATCrossCheck enables or disables cross-checking every HasPath
+// answer against the SAT-based solver. It is meant as a debugging aid
+// for small graphs only: the SAT encoding grows as O(n^3) and HasPath
+// panics if the two answers disagree.
+func (p *Path) SetSATCrossCheck(enabled bool) {
+	p.rw.Lock()
+	defer p.rw.Unlock()
+	p.satCrossCheck = enabled
+}
+
+// HasPath returns true if there is a path of at least one step from i to
+// j which does not pass through any of the skipped nodes.
+//
+// Skipped nodes only constrain the intermediate nodes of a path: i and j
+// themselves are never considered skipped, so HasPath(i, j, j) is the same
+// as HasPath(i, j) and a path ending in a skipped node j is still a path.
+//
+// HasPath is safe for concurrent use, including concurrently with Set.
 func (p *Path) HasPath(i, j int, skipped ...int) bool {
+	var result bool
+	if skipped = normalizeSkipped(i, j, skipped); len(skipped) == 0 {
+		result = p.reachableFrom(i).has(j)
+	} else {
+		result = p.reachableAvoiding(i, skipped).has(j)
+	}
+
+	p.rw.RLock()
+	defer p.rw.RUnlock()
+	if p.satCrossCheck {
+		if satResult := p.satCheckPath(i, j, skipped...); satResult != result {
+			panic(fmt.Sprintf("pprofsv: HasPath(%d, %d, %v) = %t but SAT solver says %t", i, j, skipped, result, satResult))
+		}
+	}
+	return result
+}
+
+// FindPath returns a shortest path of at least one step from i to j which
+// does not pass through any of the skipped nodes, as the list of nodes
+// visited from i to j inclusively. It returns nil if there is no such path.
+//
+// Skipped nodes are treated the same way as in HasPath.
+func (p *Path) FindPath(i, j int, skipped ...int) []int {
+	skipped = normalizeSkipped(i, j, skipped)
+
 	p.rw.RLock()
 	defer p.rw.RUnlock()
-	if p.allPaths[i][j] {
-		return true
+
+	// parent[k] is the node from which k was first discovered, or -1.
+	parent := make([]int, p.n)
+	for k := range parent {
+		parent[k] = -1
+	}
+
+	queue := []int{i}
+	for len(queue) > 0 {
+		k := queue[0]
+		queue = queue[1:]
+		if k != i && contains(skipped, k) {
+			continue
+		}
+
+		found := false
+		p.directPaths[k].forEach(func(next int) {
+			if found || parent[next] != -1 {
+				return
+			}
+			parent[next] = k
+			if next == j {
+				found = true
+				return
+			}
+			queue = append(queue, next)
+		})
+
+		if found {
+			path := []int{j}
+			for k := parent[j]; k != i; k = parent[k] {
+				path = append(path, k)
+			}
+			path = append(path, i)
+			slices.Reverse(path)
+			return path
+		}
 	}
+	return nil
+}
 
-	p.allPaths[i][j] = p.satCheckPath(i, j, skipped...)
-	return p.allPaths[i][j]
+// HasPathSAT is like HasPath, but answers with the SAT-based solver.
+//
+// It is much slower than HasPath and is kept for cross-checking only.
+func (p *Path) HasPathSAT(i, j int, skipped ...int) bool {
+	p.rw.RLock()
+	defer p.rw.RUnlock()
+	return p.satCheckPath(i, j, skipped...)
 }
 
 func (p *Path) HasDirectPath(i, j int) bool {
 	p.rw.RLock()
 	defer p.rw.RUnlock()
-	return p.directPaths[i][j]
+	return p.directPaths[i].has(j)
+}
+
+// DirectPathKind returns the kind(s) of the DIRECT path from i to j, or 0
+// if there is no direct path.
+func (p *Path) DirectPathKind(i, j int) EdgeKind {
+	p.rw.RLock()
+	defer p.rw.RUnlock()
+	return p.edgeKinds[[2]int{i, j}]
+}
+
+// successors returns the nodes with a direct path from i, in ascending
+// order.
+func (p *Path) successors(i int) []int {
+	p.rw.RLock()
+	defer p.rw.RUnlock()
+	var successors []int
+	p.directPaths[i].forEach(func(j int) {
+		successors = append(successors, j)
+	})
+	return successors
+}
+
+// AddValues accumulates values, one per sample type, on the direct path
+// from i to j. The path must have been set with Set or SetKind.
+func (p *Path) AddValues(i, j int, values []int64) {
+	p.rw.Lock()
+	defer p.rw.Unlock()
+	edge := [2]int{i, j}
+	accumulated := p.edgeValues[edge]
+	if len(accumulated) < len(values) {
+		accumulated = append(accumulated, make([]int64, len(values)-len(accumulated))...)
+		p.edgeValues[edge] = accumulated
+	}
+	for t, value := range values {
+		accumulated[t] += value
+	}
+}
+
+// DirectPathValue returns the accumulated value of the t-th sample type on
+// the direct path from i to j, or 0 if there is no such path.
+func (p *Path) DirectPathValue(i, j, t int) int64 {
+	p.rw.RLock()
+	defer p.rw.RUnlock()
+	values := p.edgeValues[[2]int{i, j}]
+	if t >= len(values) {
+		return 0
+	}
+	return values[t]
+}
+
+// reachableFrom returns the set of nodes reachable from i, computing and
+// caching it if needed. The returned bitset must not be modified.
+func (p *Path) reachableFrom(i int) bitset {
+	p.rw.RLock()
+	reachable := p.allPaths[i]
+	p.rw.RUnlock()
+	if reachable != nil {
+		return reachable
+	}
+
+	p.rw.Lock()
+	defer p.rw.Unlock()
+	if p.allPaths[i] == nil {
+		p.allPaths[i] = p.search(i, nil)
+	}
+	return p.allPaths[i]
+}
+
+// avoidingKey identifies a cached reachability answer for a source node
+// and a normalized set of skipped nodes.
+type avoidingKey struct {
+	from    int
+	skipped string
+}
+
+func newAvoidingKey(from int, skipped []int) avoidingKey {
+	var b strings.Builder
+	for _, k := range skipped {
+		b.WriteString(strconv.Itoa(k))
+		b.WriteByte(',')
+	}
+	return avoidingKey{from: from, skipped: b.String()}
+}
+
+// normalizeSkipped returns the sorted and deduplicated set of skipped
+// nodes with the endpoints i and j removed.
+func normalizeSkipped(i, j int, skipped []int) []int {
+	if len(skipped) == 0 {
+		return nil
+	}
+
+	normalized := make([]int, 0, len(skipped))
+	for _, k := range skipped {
+		if k != i && k != j {
+			normalized = append(normalized, k)
+		}
+	}
+	slices.Sort(normalized)
+	return slices.Compact(normalized)
+}
+
+// reachableAvoiding returns the set of nodes reachable from i without
+// passing through any of the (normalized) skipped nodes, computing and
+// caching it if needed. The returned bitset must not be modified.
+func (p *Path) reachableAvoiding(i int, skipped []int) bitset {
+	key := newAvoidingKey(i, skipped)
+
+	p.rw.RLock()
+	reachable, ok := p.avoidingPaths[key]
+	p.rw.RUnlock()
+	if ok {
+		return reachable
+	}
+
+	p.rw.Lock()
+	defer p.rw.Unlock()
+	if reachable, ok = p.avoidingPaths[key]; !ok {
+		reachable = p.search(i, skipped)
+		p.avoidingPaths[key] = reachable
+	}
+	return reachable
+}
+
+// search returns the set of nodes reachable from i in at least one step
+// without expanding any of the skipped nodes. The caller must hold p.rw.
+func (p *Path) search(i int, skipped []int) bitset {
+	var skip bitset
+	if len(skipped) > 0 {
+		skip = newBitset(p.n)
+		for _, k := range skipped {
+			skip.set(k)
+		}
+	}
+
+	reachable := p.directPaths[i].clone()
+	frontier := reachable.clone()
+	for {
+		next := newBitset(p.n)
+		frontier.forEach(func(k int) {
+			if skip == nil || !skip.has(k) {
+				next.or(p.directPaths[k])
+			}
+		})
+		next.andNot(reachable)
+		if !reachable.or(next) {
+			return reachable
+		}
+		frontier = next
+	}
 }
 
 func (p *Path) satCheckPath(i, j int, skipped ...int) bool {
 	// SAT problem:
-	//  1) For all a, b, if p.allPaths[a][b] is true, add constraint: R(a,b) == true.
+	//  1) For all a, b, if p.directPaths[a][b] is true, add constraint: R(a,b) == true.
 	//  2) add constraint: (R(a,b) && R(b,c)) => R(a,c)
 	//  3) add hypothesis: R(i,j) == false
 	// if the hypothesis is not satisfiable, then there IS a path from i to j.
-	const varFmt = "R(%d,%d)==%t"
+	const varFmt = "R(%d,%d)"
 	constraints := bf.True
 
-	// either R(i,j)==true or R(i,j)==false
-	constraints = bf.And(constraints, bf.Unique(fmt.Sprintf(varFmt, i, j, true), fmt.Sprintf(varFmt, i, j, false)))
-
-	// 1) For all a, b, if p.allPaths[a][b] is true, add constraint: R(a,b) == true.
-	// 2) unless b is in skipped
-	for a := range p.allPaths {
-		for b := range p.allPaths[a] {
-			if p.allPaths[a][b] && !contains(skipped, b) {
-				constraints = bf.And(constraints, bf.Var(fmt.Sprintf(varFmt, a, b, true)))
-			}
+	// 1) For all a, b, if p.directPaths[a][b] is true, add constraint: R(a,b) == true,
+	// unless a is in skipped (paths may end at, but not pass through, a skipped node)
+	for a := range p.directPaths {
+		if a != i && contains(skipped, a) {
+			continue
 		}
+		p.directPaths[a].forEach(func(b int) {
+			constraints = bf.And(constraints, bf.Var(fmt.Sprintf(varFmt, a, b)))
+		})
 	}
 
-	// 2) add constraint: (R(a,b) && R(b,c)) => R(a,c)
-	for a := range p.allPaths {
-		for b := range p.allPaths {
-			for c := range p.allPaths {
-				constraints = bf.And(constraints, bf.Implies(bf.And(bf.Var(fmt.Sprintf(varFmt, a, b, true)), bf.Var(fmt.Sprintf(varFmt, b, c, true))), bf.Var(fmt.Sprintf(varFmt, a, c, true))))
+	// 2) add constraint: (R(a,b) && R(b,c)) => R(a,c), unless b is in skipped
+	for a := 0; a < p.n; a++ {
+		for b := 0; b < p.n; b++ {
+			if contains(skipped, b) {
+				continue
+			}
+			for c := 0; c < p.n; c++ {
+				constraints = bf.And(constraints, bf.Implies(bf.And(bf.Var(fmt.Sprintf(varFmt, a, b)), bf.Var(fmt.Sprintf(varFmt, b, c))), bf.Var(fmt.Sprintf(varFmt, a, c))))
 			}
 		}
 	}
 
 	// 3) add (negated) hypothesis: R(i,j) == false
-	constraints = bf.And(constraints, bf.Not(bf.Var(fmt.Sprintf(varFmt, i, j, true))))
+	constraints = bf.And(constraints, bf.Not(bf.Var(fmt.Sprintf(varFmt, i, j))))
 
 	// solve the SAT problem
 	model := bf.Solve(constraints)
 	return model == nil // nil -> unsatisfiable -> there is a path from i to j
 }
+
+// StronglyConnectedComponents returns the strongly connected components of
+// the graph, i.e., the maximal sets of nodes that can all reach each other.
+// Each component is sorted, and components come in reverse topological
+// order: no node in a component has a direct path to a later component.
+func (p *Path) StronglyConnectedComponents() [][]int {
+	p.rw.RLock()
+	defer p.rw.RUnlock()
+
+	// Tarjan's algorithm, with an explicit call stack so that deep graphs
+	// cannot overflow the goroutine stack.
+	const unvisited = -1
+	index := make([]int, p.n)
+	lowlink := make([]int, p.n)
+	for i := range index {
+		index[i] = unvisited
+	}
+	onStack := newBitset(p.n)
+	var stack []int
+	var components [][]int
+	next := 0
+
+	type frame struct {
+		node       int
+		successors []int
+	}
+	for root := 0; root < p.n; root++ {
+		if index[root] != unvisited {
+			continue
+		}
+
+		var frames []frame
+		visit := func(node int) {
+			index[node], lowlink[node] = next, next
+			next++
+			stack = append(stack, node)
+			onStack.set(node)
+
+			var successors []int
+			p.directPaths[node].forEach(func(j int) {
+				successors = append(successors, j)
+			})
+			frames = append(frames, frame{node: node, successors: successors})
+		}
+
+		visit(root)
+		for len(frames) > 0 {
+			top := &frames[len(frames)-1]
+			if len(top.successors) > 0 {
+				j := top.successors[0]
+				top.successors = top.successors[1:]
+				if index[j] == unvisited {
+					visit(j)
+				} else if onStack.has(j) {
+					lowlink[top.node] = min(lowlink[top.node], index[j])
+				}
+				continue
+			}
+
+			node := top.node
+			frames = frames[:len(frames)-1]
+			if len(frames) > 0 {
+				parent := frames[len(frames)-1].node
+				lowlink[parent] = min(lowlink[parent], lowlink[node])
+			}
+			if lowlink[node] != index[node] {
+				continue
+			}
+
+			// node is the root of a component
+			var component []int
+			for {
+				j := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack.unset(j)
+				component = append(component, j)
+				if j == node {
+					break
+				}
+			}
+			slices.Sort(component)
+			components = append(components, component)
+		}
+	}
+	return components
+}
+
+// Dominators returns the immediate dominator of every node in the graph
+// rooted at root: idom[j] is the last node before j on every path from
+// root to j. idom[root] is root, and idom[j] is -1 if j is not reachable
+// from root.
+func (p *Path) Dominators(root int) []int {
+	p.rw.RLock()
+	defer p.rw.RUnlock()
+	return p.dominators([]int{root}, false)[:p.n]
+}
+
+// PostDominators is like Dominators on the reversed graph: idom[j] is the
+// first node after j on every path from j to exit.
+func (p *Path) PostDominators(exit int) []int {
+	p.rw.RLock()
+	defer p.rw.RUnlock()
+	return p.dominators([]int{exit}, true)[:p.n]
+}
+
+// dominators computes the immediate dominators with the iterative
+// algorithm of Cooper, Harvey and Kennedy. If there is more than one root,
+// a virtual entry node n with a direct path to every root is added, and
+// the returned slice has n+1 entries. The caller must hold p.rw.
+func (p *Path) dominators(roots []int, reversed bool) []int {
+	entry := roots[0]
+	size := p.n
+	if len(roots) > 1 {
+		entry = p.n
+		size++
+	}
+
+	successors := func(i int) []int {
+		if i == p.n {
+			return roots
+		}
+		var next []int
+		if reversed {
+			for j := 0; j < p.n; j++ {
+				if p.directPaths[j].has(i) {
+					next = append(next, j)
+				}
+			}
+		} else {
+			p.directPaths[i].forEach(func(j int) {
+				next = append(next, j)
+			})
+		}
+		return next
+	}
+
+	// number the nodes in postorder, with an explicit stack
+	const unvisited = -1
+	postorder := make([]int, size)
+	for i := range postorder {
+		postorder[i] = unvisited
+	}
+	var order []int // nodes in postorder
+	predecessors := make([][]int, size)
+	visited := newBitset(size)
+
+	type frame struct {
+		node       int
+		successors []int
+	}
+	visited.set(entry)
+	frames := []frame{{node: entry, successors: successors(entry)}}
+	for len(frames) > 0 {
+		top := &frames[len(frames)-1]
+		if len(top.successors) > 0 {
+			j := top.successors[0]
+			top.successors = top.successors[1:]
+			predecessors[j] = append(predecessors[j], top.node)
+			if !visited.has(j) {
+				visited.set(j)
+				frames = append(frames, frame{node: j, successors: successors(j)})
+			}
+			continue
+		}
+		postorder[top.node] = len(order)
+		order = append(order, top.node)
+		frames = frames[:len(frames)-1]
+	}
+
+	idom := make([]int, size)
+	for i := range idom {
+		idom[i] = unvisited
+	}
+	idom[entry] = entry
+
+	intersect := func(a, b int) int {
+		for a != b {
+			for postorder[a] < postorder[b] {
+				a = idom[a]
+			}
+			for postorder[b] < postorder[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+
+	for changed := true; changed; {
+		changed = false
+		// reverse postorder, skipping the entry
+		for k := len(order) - 2; k >= 0; k-- {
+			node := order[k]
+			newIdom := unvisited
+			for _, pred := range predecessors[node] {
+				if idom[pred] == unvisited {
+					continue
+				}
+				if newIdom == unvisited {
+					newIdom = pred
+				} else {
+					newIdom = intersect(pred, newIdom)
+				}
+			}
+			if idom[node] != newIdom {
+				idom[node] = newIdom
+				changed = true
+			}
+		}
+	}
+	return idom
+}
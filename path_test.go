@@ -1,6 +1,8 @@
 package pprofsv_test
 
 import (
+	"slices"
+	"sync"
 	"testing"
 
 	"github.com/gaukas/pprofsv"
@@ -9,6 +11,12 @@ import (
 func TestPath(t *testing.T) {
 	t.Run("Direct", testPathDirect)
 	t.Run("Indirect", testPathIndirect)
+	t.Run("Skipped", testPathSkipped)
+	t.Run("SkippedEndpoints", testPathSkippedEndpoints)
+	t.Run("SkippedCache", testPathSkippedCache)
+	t.Run("Concurrent", testPathConcurrent)
+	t.Run("SATCrossCheck", testPathSATCrossCheck)
+	t.Run("Large", testPathLarge)
 }
 
 func testPathDirect(t *testing.T) {
@@ -117,3 +125,331 @@ func testPathIndirect(t *testing.T) {
 		t.Errorf("indirect route 2->4 not found")
 	}
 }
+
+func testPathSkipped(t *testing.T) {
+	p := pprofsv.NewPath(5)
+
+	// 0->1->2->4 and 0->3->4
+	p.Set(0, 1)
+	p.Set(1, 2)
+	p.Set(2, 4)
+	p.Set(0, 3)
+	p.Set(3, 4)
+
+	if !p.HasPath(0, 4, 1) {
+		t.Errorf("route 0->4 avoiding 1 not found")
+	}
+
+	if !p.HasPath(0, 4, 3) {
+		t.Errorf("route 0->4 avoiding 3 not found")
+	}
+
+	if p.HasPath(0, 4, 2, 3) {
+		t.Errorf("route 0->4 avoiding 2 and 3 found unexpectedly")
+	}
+
+	// constrained queries must not affect unconstrained ones
+	if !p.HasPath(0, 4) {
+		t.Errorf("route 0->4 not found")
+	}
+}
+
+func testPathSkippedEndpoints(t *testing.T) {
+	p := pprofsv.NewPath(3)
+
+	p.Set(0, 1)
+	p.Set(1, 2)
+
+	// endpoints are never skipped
+	if !p.HasPath(0, 2, 0, 2) {
+		t.Errorf("route 0->2 with skipped endpoints not found")
+	}
+
+	if !p.HasPath(0, 1, 1) {
+		t.Errorf("route 0->1 with skipped destination not found")
+	}
+
+	if p.HasPath(0, 2, 1, 1, 1) {
+		t.Errorf("route 0->2 avoiding 1 found unexpectedly")
+	}
+}
+
+func testPathSkippedCache(t *testing.T) {
+	p := pprofsv.NewPath(4)
+
+	// 0->1->3 only
+	p.Set(0, 1)
+	p.Set(1, 3)
+
+	// warm up the unconstrained cache first, so that a stale transitive
+	// entry would be able to bypass the skipped node.
+	if !p.HasPath(0, 3) {
+		t.Errorf("route 0->3 not found")
+	}
+
+	if p.HasPath(0, 3, 1) {
+		t.Errorf("route 0->3 avoiding 1 found unexpectedly")
+	}
+
+	// and the constrained answer must not pollute the unconstrained one
+	if !p.HasPath(0, 3) {
+		t.Errorf("route 0->3 not found after constrained query")
+	}
+
+	// a new direct path invalidates cached constrained answers
+	p.Set(0, 2)
+	p.Set(2, 3)
+	if !p.HasPath(0, 3, 1) {
+		t.Errorf("route 0->3 avoiding 1 not found after adding 0->2->3")
+	}
+}
+
+func testPathConcurrent(t *testing.T) {
+	const n = 64
+	p := pprofsv.NewPath(n)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < n-1; i += 4 {
+				p.Set(i, i+1)
+			}
+		}(w)
+
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				p.HasPath(w, i)
+				p.HasPath(w, i, (w+i)%n)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// once all writers are done, answers must be complete
+	if !p.HasPath(0, n-1) {
+		t.Errorf("route 0->%d not found", n-1)
+	}
+
+	if p.HasPath(0, n-1, n/2) {
+		t.Errorf("route 0->%d avoiding %d found unexpectedly", n-1, n/2)
+	}
+}
+
+func testPathSATCrossCheck(t *testing.T) {
+	p := pprofsv.NewPath(6)
+
+	p.Set(0, 1)
+	p.Set(1, 2)
+	p.Set(2, 0)
+	p.Set(2, 3)
+	p.Set(4, 5)
+	p.Set(5, 5)
+	p.SetSATCrossCheck(true)
+
+	for i := 0; i < 6; i++ {
+		for j := 0; j < 6; j++ {
+			// HasPath panics on disagreement
+			p.HasPath(i, j)
+			p.HasPath(i, j, 1)
+			p.HasPath(i, j, 2, 5)
+		}
+	}
+}
+
+func testPathLarge(t *testing.T) {
+	const n = 5000
+	p := pprofsv.NewPath(n)
+
+	// a long chain with a shortcut every 100 nodes
+	for i := 0; i < n-1; i++ {
+		p.Set(i, i+1)
+		if i%100 == 0 && i+100 < n {
+			p.Set(i, i+100)
+		}
+	}
+
+	if !p.HasPath(0, n-1) {
+		t.Errorf("route 0->%d not found", n-1)
+	}
+
+	if p.HasPath(n-1, 0) {
+		t.Errorf("route %d->0 found unexpectedly", n-1)
+	}
+
+	if !p.HasPath(0, n-1, 50) {
+		t.Errorf("route 0->%d avoiding 50 not found", n-1)
+	}
+
+	if p.HasPath(0, n-1, 50, 100) {
+		t.Errorf("route 0->%d avoiding 50 and 100 found unexpectedly", n-1)
+	}
+
+	// a new direct path updates cached answers
+	p.Set(n-1, 0)
+	if !p.HasPath(n-1, 0) {
+		t.Errorf("route %d->0 not found", n-1)
+	}
+	if !p.HasPath(0, 0) {
+		t.Errorf("route 0->0 not found")
+	}
+}
+
+func TestPathFindPath(t *testing.T) {
+	p := pprofsv.NewPath(6)
+
+	// 0->1->2->3->4 with a shortcut 1->3, and a cycle 4->5->4
+	p.Set(0, 1)
+	p.Set(1, 2)
+	p.Set(2, 3)
+	p.Set(3, 4)
+	p.Set(1, 3)
+	p.Set(4, 5)
+	p.Set(5, 4)
+
+	if path := p.FindPath(0, 4); !slices.Equal(path, []int{0, 1, 3, 4}) {
+		t.Errorf("route 0->4 should be [0 1 3 4], got %v", path)
+	}
+
+	if path := p.FindPath(0, 4, 3); path != nil {
+		t.Errorf("route 0->4 avoiding 3 found unexpectedly: %v", path)
+	}
+
+	if path := p.FindPath(0, 3, 3); !slices.Equal(path, []int{0, 1, 3}) {
+		t.Errorf("route 0->3 with skipped destination should be [0 1 3], got %v", path)
+	}
+
+	if path := p.FindPath(4, 4); !slices.Equal(path, []int{4, 5, 4}) {
+		t.Errorf("route 4->4 should be [4 5 4], got %v", path)
+	}
+
+	if path := p.FindPath(0, 0); path != nil {
+		t.Errorf("route 0->0 found unexpectedly: %v", path)
+	}
+
+	if path := p.FindPath(4, 0); path != nil {
+		t.Errorf("route 4->0 found unexpectedly: %v", path)
+	}
+}
+
+func TestPathKind(t *testing.T) {
+	p := pprofsv.NewPath(3)
+
+	p.Set(0, 1)
+	p.SetKind(1, 2, pprofsv.EdgeInline)
+
+	if kind := p.DirectPathKind(0, 1); kind != pprofsv.EdgeCall {
+		t.Errorf("direct route 0->1 should be call, got %s", kind)
+	}
+
+	if kind := p.DirectPathKind(1, 2); kind != pprofsv.EdgeInline {
+		t.Errorf("direct route 1->2 should be inline, got %s", kind)
+	}
+
+	if !p.HasDirectPath(1, 2) {
+		t.Errorf("direct route 1->2 not found")
+	}
+
+	// kinds accumulate
+	p.Set(1, 2)
+	if kind := p.DirectPathKind(1, 2); kind != pprofsv.EdgeCall|pprofsv.EdgeInline {
+		t.Errorf("direct route 1->2 should be call|inline, got %s", kind)
+	}
+
+	if kind := p.DirectPathKind(0, 2); kind != 0 {
+		t.Errorf("direct route 0->2 reported unexpectedly as %s", kind)
+	}
+}
+
+func TestPathValues(t *testing.T) {
+	p := pprofsv.NewPath(3)
+
+	p.Set(0, 1)
+	p.AddValues(0, 1, []int64{1, 10})
+	p.AddValues(0, 1, []int64{2, 20})
+
+	if value := p.DirectPathValue(0, 1, 0); value != 3 {
+		t.Errorf("value of 0->1 should be 3, got %d", value)
+	}
+	if value := p.DirectPathValue(0, 1, 1); value != 30 {
+		t.Errorf("value of 0->1 should be 30, got %d", value)
+	}
+
+	// unknown sample types and edges weigh nothing
+	if value := p.DirectPathValue(0, 1, 2); value != 0 {
+		t.Errorf("value of 0->1 should be 0, got %d", value)
+	}
+	if value := p.DirectPathValue(1, 2, 0); value != 0 {
+		t.Errorf("value of 1->2 should be 0, got %d", value)
+	}
+}
+
+func TestPathStronglyConnectedComponents(t *testing.T) {
+	p := pprofsv.NewPath(6)
+
+	// 0 -> {1 <-> 2} -> 3 -> 3, 4 -> 5
+	p.Set(0, 1)
+	p.Set(1, 2)
+	p.Set(2, 1)
+	p.Set(2, 3)
+	p.Set(3, 3)
+	p.Set(4, 5)
+
+	components := p.StronglyConnectedComponents()
+	if len(components) != 5 {
+		t.Fatalf("expected 5 components, got %v", components)
+	}
+
+	// reverse topological order
+	position := make(map[int]int)
+	for c, component := range components {
+		for _, i := range component {
+			position[i] = c
+		}
+	}
+	if position[1] != position[2] {
+		t.Errorf("1 and 2 should be in the same component, got %v", components)
+	}
+	for _, edge := range [][2]int{{0, 1}, {2, 3}, {4, 5}} {
+		if position[edge[0]] <= position[edge[1]] {
+			t.Errorf("component of %d should come after component of %d, got %v", edge[0], edge[1], components)
+		}
+	}
+
+	// a long chain does not overflow the stack
+	const n = 10000
+	p = pprofsv.NewPath(n)
+	for i := 0; i < n-1; i++ {
+		p.Set(i, i+1)
+	}
+	p.Set(n-1, 0)
+	if components := p.StronglyConnectedComponents(); len(components) != 1 || len(components[0]) != n {
+		t.Errorf("expected a single component of %d nodes, got %d components", n, len(components))
+	}
+}
+
+func TestPathDominators(t *testing.T) {
+	p := pprofsv.NewPath(7)
+
+	// 0 -> 1 -> {2, 3} -> 4 -> 5, 4 -> 1, and 6 is disconnected
+	p.Set(0, 1)
+	p.Set(1, 2)
+	p.Set(1, 3)
+	p.Set(2, 4)
+	p.Set(3, 4)
+	p.Set(4, 5)
+	p.Set(4, 1)
+
+	expected := []int{0, 0, 1, 1, 1, 4, -1}
+	if idom := p.Dominators(0); !slices.Equal(idom, expected) {
+		t.Errorf("expected dominators %v, got %v", expected, idom)
+	}
+
+	expected = []int{1, 4, 4, 4, 5, 5, -1}
+	if idom := p.PostDominators(5); !slices.Equal(idom, expected) {
+		t.Errorf("expected post-dominators %v, got %v", expected, idom)
+	}
+}
@@ -0,0 +1,203 @@
+package pprofsv
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// Labels set on the samples of perf script output. See ParsePerfScript.
+const (
+	// PerfCommandLabel is the command name of the sampled thread.
+	PerfCommandLabel = "comm"
+
+	// PerfEventLabel is the event that was sampled, e.g. "cycles".
+	PerfEventLabel = "event"
+
+	// PerfPIDLabel and PerfTIDLabel are the numeric process and thread
+	// IDs of the sampled thread.
+	PerfPIDLabel = "pid"
+	PerfTIDLabel = "tid"
+)
+
+// ParsePerfScript parses the output of `perf script` (with call graphs,
+// e.g. recorded with perf record -g) into a pprof profile that NewProfile
+// accepts. Each sample starts with a header line and is followed by its
+// frames, from the leaf to the root, one per line:
+//
+//	nginx 1234/1235 [002] 5178.127123:     250000 cpu-clock:
+//		    55d0c2f3a1b4 ngx_http_parse_request_line+0x24 (/usr/sbin/nginx)
+//		    55d0c2f2e0a0 ngx_http_process_request_line+0x70 (/usr/sbin/nginx)
+//
+// Samples have two sample types: samples/count, which is always 1, and
+// period/events, the period of the sample if the header has one, which is
+// the default sample type unless no header has a period.
+//
+// Frames are tied to the mappings of their binaries or shared libraries,
+// so that functions of the same name in different binaries stay apart,
+// and consecutive frames at the same address, as printed with --inline,
+// are inlined into the last of them. The command, event, process and
+// thread IDs of the samples are labels (PerfCommandLabel, etc.). Samples
+// without frames are ignored.
+func ParsePerfScript(r io.Reader) (*profile.Profile, error) {
+	b := newStackBuilder(
+		&profile.ValueType{Type: "samples", Unit: "count"},
+		&profile.ValueType{Type: "period", Unit: "events"},
+	)
+
+	type frame struct {
+		address  uint64
+		function *profile.Function
+		mapping  *profile.Mapping
+	}
+	var sample *profile.Sample // the sample being parsed, if any
+	var frames []frame         // its frames, from the leaf to the root
+	flush := func() {
+		if sample == nil || len(frames) == 0 {
+			// without a call graph, e.g. recorded without -g
+			sample, frames = nil, nil
+			return
+		}
+		var lines []profile.Line
+		for k, f := range frames {
+			lines = append(lines, profile.Line{Function: f.function})
+			if k == len(frames)-1 || frames[k+1].address != f.address || frames[k+1].mapping != f.mapping {
+				sample.Location = append(sample.Location, b.location(f.mapping, f.address, lines...))
+				lines = nil
+			}
+		}
+		b.p.Sample = append(b.p.Sample, sample)
+		sample, frames = nil, nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		text := scanner.Text()
+		switch {
+		case strings.TrimSpace(text) == "":
+			flush()
+		case text[0] == ' ' || text[0] == '\t':
+			if sample == nil {
+				continue
+			}
+			address, name, file, ok := parsePerfFrame(text)
+			if !ok {
+				continue
+			}
+			frames = append(frames, frame{address: address, function: b.function(name, ""), mapping: b.mapping(file)})
+		case strings.HasPrefix(text, "#"):
+			// comments, e.g. from perf script --header
+		default:
+			flush()
+			sample = parsePerfHeader(text)
+		}
+	}
+	flush()
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(b.p.Sample) == 0 {
+		return nil, ErrNoStacks
+	}
+
+	// weigh with the periods by default, if there are any
+	b.p.DefaultSampleType = "samples"
+	for _, sample := range b.p.Sample {
+		if sample.Value[1] != 0 {
+			b.p.DefaultSampleType = "period"
+			break
+		}
+	}
+	return b.p, nil
+}
+
+// parsePerfHeader parses a sample header such as
+// "nginx 1234/1235 [002] 5178.127123: 250000 cpu-clock:" into an empty
+// sample. The command name may contain spaces, and the CPU, the period and
+// the event may be missing, depending on the fields perf script prints.
+func parsePerfHeader(text string) *profile.Sample {
+	sample := &profile.Sample{
+		Value:    []int64{1, 0},
+		Label:    make(map[string][]string),
+		NumLabel: make(map[string][]int64),
+	}
+
+	fields := strings.Fields(text)
+	// the timestamp is the first field ending with ':'
+	timestamp := -1
+	for i, field := range fields {
+		if strings.HasSuffix(field, ":") {
+			if _, err := strconv.ParseFloat(strings.TrimSuffix(field, ":"), 64); err == nil {
+				timestamp = i
+				break
+			}
+		}
+	}
+	if timestamp < 0 {
+		sample.Label[PerfCommandLabel] = []string{strings.TrimSpace(text)}
+		return sample
+	}
+
+	// the command is followed by the PID/TID and optionally the CPU
+	head := fields[:timestamp]
+	if len(head) > 0 && strings.HasPrefix(head[len(head)-1], "[") {
+		head = head[:len(head)-1]
+	}
+	if len(head) > 1 {
+		pid, tid, hasTID := strings.Cut(head[len(head)-1], "/")
+		if n, err := strconv.ParseInt(pid, 10, 64); err == nil {
+			head = head[:len(head)-1]
+			if hasTID {
+				sample.NumLabel[PerfPIDLabel] = []int64{n}
+				if n, err := strconv.ParseInt(tid, 10, 64); err == nil {
+					sample.NumLabel[PerfTIDLabel] = []int64{n}
+				}
+			} else {
+				// perf script prints the TID alone by default
+				sample.NumLabel[PerfTIDLabel] = []int64{n}
+			}
+		}
+	}
+	sample.Label[PerfCommandLabel] = []string{strings.Join(head, " ")}
+
+	tail := fields[timestamp+1:]
+	if len(tail) > 1 {
+		if period, err := strconv.ParseInt(tail[0], 10, 64); err == nil {
+			sample.Value[1] = period
+			tail = tail[1:]
+		}
+	}
+	if len(tail) > 0 {
+		// e.g. "cycles:", "cpu-clock:pppH:" or "cycles:u:"
+		event, _, _ := strings.Cut(tail[0], ":")
+		sample.Label[PerfEventLabel] = []string{event}
+	}
+	return sample
+}
+
+// parsePerfFrame parses a frame such as
+// "\t55d0c2f3a1b4 ngx_http_parse_request_line+0x24 (/usr/sbin/nginx)".
+func parsePerfFrame(text string) (address uint64, name, file string, ok bool) {
+	text = strings.TrimSpace(text)
+	addr, rest, found := strings.Cut(text, " ")
+	if !found {
+		return 0, "", "", false
+	}
+	address, err := strconv.ParseUint(addr, 16, 64)
+	if err != nil {
+		return 0, "", "", false
+	}
+
+	rest = strings.TrimSpace(rest)
+	if i := strings.LastIndex(rest, " ("); i >= 0 && strings.HasSuffix(rest, ")") {
+		rest, file = rest[:i], rest[i+2:len(rest)-1]
+	}
+	if i := strings.LastIndex(rest, "+0x"); i > 0 {
+		rest = rest[:i]
+	}
+	return address, rest, file, rest != ""
+}
@@ -0,0 +1,84 @@
+package pprofsv_test
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gaukas/pprofsv"
+)
+
+func TestParsePerfScript(t *testing.T) {
+	file, err := os.Open("testdata/perf.script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	pprof, err := pprofsv.ParsePerfScript(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pprof.Sample) != 3 {
+		t.Fatalf("expected 3 samples, got %d", len(pprof.Sample))
+	}
+	if pprof.DefaultSampleType != "period" {
+		t.Errorf("expected period to be the default sample type, got %q", pprof.DefaultSampleType)
+	}
+
+	fluent := pprof.Sample[2]
+	if !slices.Equal(fluent.Value, []int64{1, 500000}) {
+		t.Errorf("expected 1 sample of 500000 events, got %v", fluent.Value)
+	}
+	if comm, event := fluent.Label[pprofsv.PerfCommandLabel], fluent.Label[pprofsv.PerfEventLabel]; !slices.Equal(comm, []string{"fluent bit"}) || !slices.Equal(event, []string{"cpu-clock"}) {
+		t.Errorf("expected command fluent bit and event cpu-clock, got %v and %v", comm, event)
+	}
+	if pid, tid := fluent.NumLabel[pprofsv.PerfPIDLabel], fluent.NumLabel[pprofsv.PerfTIDLabel]; !slices.Equal(pid, []int64{977}) || !slices.Equal(tid, []int64{980}) {
+		t.Errorf("expected process 977 and thread 980, got %v and %v", pid, tid)
+	}
+	if leaf := fluent.Location[0]; leaf.Address != 0xffffffffa1e0c2b1 || leaf.Mapping.File != "[kernel.kallsyms]" || leaf.Line[0].Function.Name != "do_syscall_64" {
+		t.Errorf("unexpected leaf frame %+v", leaf)
+	}
+
+	p := pprofsv.NewProfile(pprof)
+	verifier, err := p.Verifier(`^Envoy::|^main$|^flb_|memcpy`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// add is inlined into onData, at the same address
+	witness, err := verifier.CheckReachablePath("Envoy::Network::FilterManagerImpl::onRead", "__memcpy_avx_unaligned")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if witness == nil || witness.String() != "Envoy::Network::FilterManagerImpl::onRead -call-> Envoy::Http::ConnectionManagerImpl::onData -inline-> Envoy::Buffer::OwnedImpl::add -call-> __memcpy_avx_unaligned" {
+		t.Errorf("unexpected witness %v", witness)
+	}
+	if share, err := verifier.Share("Envoy::Http::ConnectionManagerImpl::onData", "Envoy::Buffer::OwnedImpl::add", ""); err != nil || share != 0.5 {
+		t.Errorf("expected onData to spend half of its period in add, got %v (%v)", share, err)
+	}
+
+	// the two mains are in different binaries
+	var functionErr *pprofsv.FunctionError
+	if _, err := verifier.Weight("main", ""); !errors.As(err, &functionErr) || len(functionErr.Candidates) != 2 {
+		t.Errorf("expected main to be ambiguous, got %v", err)
+	}
+
+	// restricted to a command with the labels
+	envoy, err := p.WithLabels(pprofsv.MustParseLabelSelector("comm=envoy")).Verifier(`^main$|onData$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(envoy.Callstack()); n != 2 {
+		t.Errorf("expected the 2 envoy samples, got %d", n)
+	}
+}
+
+func TestParsePerfScriptWithoutCallGraphs(t *testing.T) {
+	// recorded without -g: no frames, and the TID alone by default
+	input := "envoy 4215 8412.100231: cycles:\n\nenvoy 4215 8412.104231: cycles:\n"
+	if _, err := pprofsv.ParsePerfScript(strings.NewReader(input)); !errors.Is(err, pprofsv.ErrNoStacks) {
+		t.Errorf("expected ErrNoStacks, got %v", err)
+	}
+}
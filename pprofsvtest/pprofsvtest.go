@@ -0,0 +1,206 @@
+// Package pprofsvtest provides helpers to verify state-transition
+// assertions in Go tests, either on a checked-in profile or on a profile
+// captured around the code under test.
+//
+// A test typically captures a profile, builds a Verifier and asserts on it:
+//
+//	func TestMain(m *testing.M) {
+//		pprofsvtest.EnableHeap()
+//		os.Exit(m.Run())
+//	}
+//
+//	func TestBranch(t *testing.T) {
+//		d := dummy.NewDummy()
+//		p := pprofsvtest.CaptureHeap(t, func() {
+//			d.BranchFunc(true)
+//		})
+//		v := pprofsvtest.Verifier(t, p, "dummy", "github.com/gaukas/pprofsv/dummy.(*Dummy).")
+//		pprofsvtest.AssertReachable(t, v, "BranchFunc", "final")
+//		pprofsvtest.AssertNotNext(t, v, "BranchFunc", "branchAinner")
+//	}
+//
+// Failed assertions are reported with testing.TB.Errorf, with their
+// witness path if there is one, so that all of them are reported at once.
+// Errors setting up the profile or the Verifier are fatal.
+package pprofsvtest
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gaukas/pprofsv"
+	"github.com/google/pprof/profile"
+)
+
+// Load reads and parses the named pprof profile.
+func Load(tb testing.TB, name string) *pprofsv.Profile {
+	tb.Helper()
+
+	file, err := os.Open(name)
+	if err != nil {
+		tb.Fatalf("pprofsvtest: %v", err)
+	}
+	defer file.Close()
+
+	pprof, err := profile.Parse(file)
+	if err != nil {
+		tb.Fatalf("pprofsvtest: %s: %v", name, err)
+	}
+	return pprofsv.NewProfile(pprof)
+}
+
+// CaptureCPU runs f while recording a CPU profile with runtime/pprof.
+//
+// The CPU profiler samples the running goroutines about 100 times per
+// second, so f must run long enough (e.g. in a loop for a few hundred
+// milliseconds) for every transition to be sampled. It cannot be used
+// while another CPU profile is recorded, e.g. with go test -cpuprofile.
+func CaptureCPU(tb testing.TB, f func()) *pprofsv.Profile {
+	tb.Helper()
+
+	var buf bytes.Buffer
+	if err := pprof.StartCPUProfile(&buf); err != nil {
+		tb.Fatalf("pprofsvtest: %v", err)
+	}
+	func() {
+		defer pprof.StopCPUProfile()
+		f()
+	}()
+
+	return parse(tb, &buf)
+}
+
+// CaptureHeap runs f and returns the heap profile of the allocations made
+// by f, from runtime/pprof. Unlike CaptureCPU, the call stack of every
+// allocation is in the profile, so it suits transitions that allocate; the
+// other goroutines allocating while f runs are in the profile too. Small
+// allocations without pointers (under 16 bytes) share memory blocks, and
+// only the first allocation of every block is recorded.
+//
+// CaptureHeap fails the test unless EnableHeap was called first.
+func CaptureHeap(tb testing.TB, f func()) *pprofsv.Profile {
+	tb.Helper()
+
+	if runtime.MemProfileRate != 1 {
+		tb.Fatalf("pprofsvtest: CaptureHeap requires runtime.MemProfileRate 1, got %d: call EnableHeap in TestMain", runtime.MemProfileRate)
+	}
+	before := heapProfile(tb)
+	f()
+	after := heapProfile(tb)
+
+	// subtract the allocations made before f
+	before.Scale(-1)
+	pprof, err := profile.Merge([]*profile.Profile{after, before})
+	if err != nil {
+		tb.Fatalf("pprofsvtest: %v", err)
+	}
+	pprof.Sample = slices.DeleteFunc(pprof.Sample, func(sample *profile.Sample) bool {
+		return sample.Value[0] <= 0 // alloc_objects
+	})
+	return pprofsv.NewProfile(pprof)
+}
+
+// EnableHeap sets runtime.MemProfileRate to 1, so that the heap profile
+// records every allocation, as CaptureHeap requires. The rate must be set
+// before the allocations to be recorded, and changing it later only takes
+// effect gradually, so EnableHeap is best called first in TestMain. It
+// slows down the allocations of the whole test binary.
+func EnableHeap() {
+	runtime.MemProfileRate = 1
+}
+
+func heapProfile(tb testing.TB) *profile.Profile {
+	tb.Helper()
+
+	// the heap profile only reflects the allocations up to the last
+	// completed GC cycle
+	runtime.GC()
+	runtime.GC()
+
+	var buf bytes.Buffer
+	if err := pprof.Lookup("heap").WriteTo(&buf, 0); err != nil {
+		tb.Fatalf("pprofsvtest: %v", err)
+	}
+	pprof, err := profile.Parse(&buf)
+	if err != nil {
+		tb.Fatalf("pprofsvtest: %v", err)
+	}
+	return pprof
+}
+
+func parse(tb testing.TB, buf *bytes.Buffer) *pprofsv.Profile {
+	tb.Helper()
+
+	pprof, err := profile.Parse(buf)
+	if err != nil {
+		tb.Fatalf("pprofsvtest: %v", err)
+	}
+	return pprofsv.NewProfile(pprof)
+}
+
+// Verifier builds a Verifier from the functions of p matching the name
+// pattern, with the given function prefix. It fails the test if no call
+// stack matches the pattern.
+func Verifier(tb testing.TB, p *pprofsv.Profile, pattern, prefix string, opts ...pprofsv.VerifierOption) *pprofsv.Verifier {
+	tb.Helper()
+
+	v, err := p.Verifier(pattern, opts...)
+	if err != nil {
+		tb.Fatalf("pprofsvtest: %v", err)
+	}
+	if v == nil {
+		tb.Fatalf("pprofsvtest: no call stacks match pattern %q", pattern)
+	}
+	v.SetFunctionPrefix(prefix)
+	return v
+}
+
+// Assert evaluates the assertions on v and reports every one that does not
+// hold, e.g. the assertions of a pprofsv.Spec or parsed with
+// pprofsv.ParseAssertion.
+func Assert(tb testing.TB, v *pprofsv.Verifier, assertions ...pprofsv.Assertion) {
+	tb.Helper()
+
+	for _, result := range pprofsv.Evaluate(v, assertions).Failures() {
+		message := result.String()
+		if prefix := v.FunctionPrefix(); prefix != "" {
+			message = strings.ReplaceAll(message, prefix, "")
+		}
+		tb.Errorf("pprofsvtest: %s", message)
+	}
+}
+
+// AssertReachable reports an error if function `to` is not reachable from
+// function `from` without passing through the skipped functions.
+func AssertReachable(tb testing.TB, v *pprofsv.Verifier, from, to string, skipped ...string) {
+	tb.Helper()
+	Assert(tb, v, pprofsv.Assertion{Kind: pprofsv.AssertReachable, From: from, To: to, Avoid: skipped})
+}
+
+// AssertNotReachable reports an error, with the path found as a
+// counterexample, if function `to` is reachable from function `from`
+// without passing through the skipped functions.
+func AssertNotReachable(tb testing.TB, v *pprofsv.Verifier, from, to string, skipped ...string) {
+	tb.Helper()
+	Assert(tb, v, pprofsv.Assertion{Kind: pprofsv.AssertNotReachable, From: from, To: to, Avoid: skipped})
+}
+
+// AssertNext reports an error if function `from` never directly calls (nor
+// inlines) function `to`.
+func AssertNext(tb testing.TB, v *pprofsv.Verifier, from, to string) {
+	tb.Helper()
+	Assert(tb, v, pprofsv.Assertion{Kind: pprofsv.AssertNext, From: from, To: to})
+}
+
+// AssertNotNext reports an error, with the direct path as a
+// counterexample, if function `from` directly calls (or inlines) function
+// `to`.
+func AssertNotNext(tb testing.TB, v *pprofsv.Verifier, from, to string) {
+	tb.Helper()
+	Assert(tb, v, pprofsv.Assertion{Kind: pprofsv.AssertNotNext, From: from, To: to})
+}
@@ -0,0 +1,86 @@
+package pprofsvtest_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gaukas/pprofsv"
+	"github.com/gaukas/pprofsv/pprofsvtest"
+)
+
+const testPrefix = "github.com/gaukas/pprofsv/dummy.(*Dummy)."
+
+// recorder is a testing.TB recording the errors reported to it.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssert(t *testing.T) {
+	p := pprofsvtest.Load(t, "../testdata/pprof.profile")
+	v := pprofsvtest.Verifier(t, p, "dummy", testPrefix)
+
+	pprofsvtest.AssertReachable(t, v, "DeepFunc", "deepFuncLv5")
+	pprofsvtest.AssertReachable(t, v, "MultiFunc", "final", "multiFuncA", "multiFuncB", "multiFuncC")
+	pprofsvtest.AssertNotReachable(t, v, "multiFuncA", "multiFuncB")
+	pprofsvtest.AssertNext(t, v, "BranchFunc", "branchA")
+	pprofsvtest.AssertNotNext(t, v, "BranchFunc", "branchAinner")
+
+	r := &recorder{TB: t}
+	pprofsvtest.AssertNotNext(r, v, "BranchFunc", "branchA")
+	pprofsvtest.AssertNotReachable(r, v, "DeepFunc", "deepFuncLv2")
+	pprofsvtest.AssertReachable(r, v, "deepFuncLv2", "DeepFunc")
+	pprofsvtest.AssertNext(r, v, "DeepFunc", "deepFuncLv9")
+
+	want := []string{
+		"pprofsvtest: FAIL not-next(BranchFunc, branchA): counterexample BranchFunc -call-> branchA",
+		"pprofsvtest: FAIL not-reachable(DeepFunc, deepFuncLv2): counterexample DeepFunc -call-> deepFuncLv1 -call-> deepFuncLv2",
+		"pprofsvtest: FAIL reachable(deepFuncLv2, DeepFunc)",
+		"pprofsvtest: FAIL next(DeepFunc, deepFuncLv9): ",
+	}
+	if len(r.errors) != len(want) {
+		t.Fatalf("expected %d errors, got %q", len(want), r.errors)
+	}
+	for i := range want {
+		if !strings.HasPrefix(r.errors[i], want[i]) {
+			t.Errorf("expected error %q, got %q", want[i], r.errors[i])
+		}
+	}
+
+	pprofsvtest.Assert(t, v, pprofsv.Assertion{Kind: pprofsv.AssertNoRecursion, Match: "DeepFunc"})
+}
+
+//go:noinline
+func spin(n int) int {
+	for i := 0; i < 1000; i++ {
+		n = n*31 + i
+	}
+	return n
+}
+
+func TestCaptureCPU(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping CPU profiling in short mode")
+	}
+
+	var n int
+	p := pprofsvtest.CaptureCPU(t, func() {
+		for start := time.Now(); time.Since(start) < 300*time.Millisecond; {
+			n = spin(n)
+		}
+	})
+	if p.NumSamples() == 0 {
+		t.Fatal("expected CPU samples")
+	}
+
+	v := pprofsvtest.Verifier(t, p, `pprofsvtest_test\.`, "github.com/gaukas/pprofsv/pprofsvtest_test.")
+	pprofsvtest.AssertReachable(t, v, "TestCaptureCPU.func1", "spin")
+}
@@ -1,41 +1,480 @@
 package pprofsv
 
-import "github.com/google/pprof/profile"
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
 
 type Profile struct {
 	functionNameMap map[string]uint64
 	functionIdMap   map[uint64]string
 
+	// duplicateNames maps a name shared by more than one function to the
+	// IDs of all those functions. functionNameMap only keeps one of them.
+	duplicateNames map[string][]uint64
+
+	// functionKeyMap maps the identity of a function to its ID. Function
+	// IDs are assigned by the Profile rather than taken from the pprof
+	// profiles, since IDs of the same function differ between profiles.
+	functionKeyMap map[FunctionKey]uint64
+
+	// functionIdKeyMap is the reverse of functionKeyMap.
+	functionIdKeyMap map[uint64]FunctionKey
+
+	// functionQualifiedMap maps FunctionKey.String() to the function ID.
+	functionQualifiedMap map[string]uint64
+
 	callStacks [][]uint64 // callStacks[i] is the call stack of sample i, created from chaining all locations in sample i.
+
+	// inlined[i][k] is true if callStacks[i][k] was inlined by the compiler
+	// into its caller callStacks[i][k+1], i.e., the edge between the two
+	// frames is an inline expansion rather than a real call.
+	inlined [][]bool
+
+	// sourceOffsets[s] is the index in callStacks of the first sample
+	// from the s-th pprof profile added to the Profile.
+	sourceOffsets []int
+
+	// sampleTypes lists the sample types of all pprof profiles added to
+	// the Profile, e.g. samples/count and cpu/nanoseconds.
+	sampleTypes []profile.ValueType
+
+	// defaultSampleType is the index in sampleTypes of the sample type
+	// used when none is given.
+	defaultSampleType int
+
+	// values[i][t] is the value of sample i for sampleTypes[t]. It may be
+	// shorter than sampleTypes if sample i comes from a pprof profile
+	// without some of the sample types, which then count as zero.
+	values [][]int64
+
+	// labels[i] and numLabels[i] are the pprof labels of sample i.
+	labels    []map[string][]string
+	numLabels []map[string][]int64
+
+	// selector keeps only the samples it matches, see WithLabels.
+	selector LabelSelector
+
+	// origins[i] is the index sample i would have in the Profile without
+	// its selector. It is nil if the Profile has no selector.
+	origins []int
+
+	// numOrigins is the number of samples added to the Profile, including
+	// those dropped by its selector. sourceOffsets index such samples.
+	numOrigins int
+}
+
+// FunctionKey identifies a function across pprof profiles. Two functions
+// sharing a name, e.g. from different binaries, have different keys.
+type FunctionKey struct {
+	Name       string
+	SystemName string
+	Filename   string
+	StartLine  int64
+
+	// Mapping identifies the binary or shared library the function was
+	// found in: its build ID if known, or its file name otherwise.
+	Mapping string
+}
+
+func newFunctionKey(function *profile.Function, mapping *profile.Mapping) FunctionKey {
+	key := FunctionKey{
+		Name:       function.Name,
+		SystemName: function.SystemName,
+		Filename:   function.Filename,
+		StartLine:  function.StartLine,
+	}
+	if mapping != nil {
+		key.Mapping = mapping.BuildID
+		if key.Mapping == "" {
+			key.Mapping = mapping.File
+		}
+	}
+	return key
+}
+
+// functionKeySeparator separates the fields of a qualified function key.
+const functionKeySeparator = "|"
+
+// String returns the fully qualified key as
+// "Name|SystemName|Filename|StartLine|Mapping". It can be used in place of
+// a function name wherever a name would be ambiguous.
+func (k FunctionKey) String() string {
+	return strings.Join([]string{k.Name, k.SystemName, k.Filename, strconv.FormatInt(k.StartLine, 10), k.Mapping}, functionKeySeparator)
+}
+
+// ParseFunctionKey parses a fully qualified key returned by
+// FunctionKey.String.
+func ParseFunctionKey(s string) (FunctionKey, error) {
+	fields := strings.Split(s, functionKeySeparator)
+	if len(fields) != 5 {
+		return FunctionKey{}, fmt.Errorf("function key %q: expected 5 fields separated by %q", s, functionKeySeparator)
+	}
+
+	startLine, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return FunctionKey{}, fmt.Errorf("function key %q: invalid start line: %w", s, err)
+	}
+
+	return FunctionKey{
+		Name:       fields[0],
+		SystemName: fields[1],
+		Filename:   fields[2],
+		StartLine:  startLine,
+		Mapping:    fields[4],
+	}, nil
 }
 
 func NewProfile(pprof *profile.Profile) *Profile {
+	return NewProfileFromMany(pprof)
+}
+
+// NewProfileFromMany returns a new Profile merging the samples of all the
+// given pprof profiles, e.g. collected from several replicas or test
+// shards. See Merge.
+func NewProfileFromMany(pprofs ...*profile.Profile) *Profile {
 	p := &Profile{
 		functionNameMap: make(map[string]uint64),
 		functionIdMap:   make(map[uint64]string),
-		callStacks:      make([][]uint64, len(pprof.Sample)),
+		duplicateNames:  make(map[string][]uint64),
+		functionKeyMap:  make(map[FunctionKey]uint64),
+
+		functionIdKeyMap:     make(map[uint64]FunctionKey),
+		functionQualifiedMap: make(map[string]uint64),
 	}
+	p.Merge(pprofs...)
+	return p
+}
 
-	for _, function := range pprof.Function {
-		p.functionNameMap[function.Name] = function.ID
-		p.functionIdMap[function.ID] = function.Name
+// Merge adds the samples of the given pprof profiles to the Profile.
+//
+// Functions are reconciled by their identity (see FunctionKey) rather than
+// by their ID in each pprof profile.
+// Samples are appended in order, so that each sample can be traced back to
+// its source with SampleSource.
+//
+// Verifiers built from the Profile before Merge do not see the new samples.
+func (p *Profile) Merge(pprofs ...*profile.Profile) {
+	for _, pprof := range pprofs {
+		p.merge(pprof)
+	}
+}
+
+func (p *Profile) merge(pprof *profile.Profile) {
+	p.sourceOffsets = append(p.sourceOffsets, p.numOrigins)
+
+	// sampleTypeMap maps the index of a sample type in pprof to its index
+	// in p.sampleTypes.
+	sampleTypeMap := make([]int, len(pprof.SampleType))
+	for i, sampleType := range pprof.SampleType {
+		sampleTypeMap[i] = p.addSampleType(*sampleType)
+		if len(p.sourceOffsets) == 1 && (sampleType.Type == pprof.DefaultSampleType || (pprof.DefaultSampleType == "" && i == len(pprof.SampleType)-1)) {
+			p.defaultSampleType = sampleTypeMap[i]
+		}
 	}
 
-	for i, sample := range pprof.Sample {
+	// pprofIdMap maps function and mapping IDs in pprof to function IDs in
+	// p, as the same function ID may appear in different mappings.
+	pprofIdMap := make(map[[2]uint64]uint64, len(pprof.Function))
+
+	// Both sample.Location and location.Line are ordered from the leaf
+	// (callee) to the root (caller). Within a location, only the last line
+	// is a real frame and every line before it has been inlined into the
+	// line that follows. So the flattened call stack keeps the leaf-to-root
+	// order and each frame but the last of a location is marked as inlined.
+	for _, sample := range pprof.Sample {
+		p.numOrigins++
+		if !p.selector.Matches(sample.Label, sample.NumLabel) {
+			continue
+		}
+		if !p.selector.Empty() {
+			p.origins = append(p.origins, p.numOrigins-1)
+		}
+
 		callStack := make([]uint64, 0, len(sample.Location))
+		inlined := make([]bool, 0, len(sample.Location))
 		for _, location := range sample.Location {
-			for _, line := range location.Line {
-				callStack = append(callStack, line.Function.ID)
+			var mappingId uint64
+			if location.Mapping != nil {
+				mappingId = location.Mapping.ID
+			}
+			for j, line := range location.Line {
+				id, ok := pprofIdMap[[2]uint64{line.Function.ID, mappingId}]
+				if !ok {
+					id = p.addFunction(newFunctionKey(line.Function, location.Mapping))
+					pprofIdMap[[2]uint64{line.Function.ID, mappingId}] = id
+				}
+				callStack = append(callStack, id)
+				inlined = append(inlined, j < len(location.Line)-1)
 			}
 		}
-		p.callStacks[i] = callStack
+		p.callStacks = append(p.callStacks, callStack)
+		p.inlined = append(p.inlined, inlined)
+
+		values := make([]int64, len(p.sampleTypes))
+		for i, value := range sample.Value {
+			values[sampleTypeMap[i]] = value
+		}
+		p.values = append(p.values, values)
+		p.labels = append(p.labels, sample.Label)
+		p.numLabels = append(p.numLabels, sample.NumLabel)
 	}
 
-	return p
+	// keep the functions that do not appear in any sample, so that they
+	// are reported as filtered out rather than not found. They keep the
+	// mappings of their locations, if any, or else are not added again if
+	// the Profile already has them in some mapping, e.g. from another
+	// profile of the same binary.
+	seen := make(map[uint64]bool, len(pprofIdMap))
+	for ids := range pprofIdMap {
+		seen[ids[0]] = true
+	}
+	mappings := make(map[uint64][]*profile.Mapping)
+	for _, location := range pprof.Location {
+		for _, line := range location.Line {
+			if line.Function != nil && !seen[line.Function.ID] && !slices.Contains(mappings[line.Function.ID], location.Mapping) {
+				mappings[line.Function.ID] = append(mappings[line.Function.ID], location.Mapping)
+			}
+		}
+	}
+	for _, function := range pprof.Function {
+		if seen[function.ID] {
+			continue
+		}
+		if len(mappings[function.ID]) > 0 {
+			for _, mapping := range mappings[function.ID] {
+				p.addFunction(newFunctionKey(function, mapping))
+			}
+		} else if key := newFunctionKey(function, nil); !p.hasFunction(key) {
+			p.addFunction(key)
+		}
+	}
+}
+
+// hasFunction returns true if p has a function with the key, in any
+// mapping.
+func (p *Profile) hasFunction(key FunctionKey) bool {
+	ids := p.duplicateNames[key.Name]
+	if id, ok := p.functionNameMap[key.Name]; ok && len(ids) == 0 {
+		ids = []uint64{id}
+	}
+	for _, id := range ids {
+		other := p.functionIdKeyMap[id]
+		other.Mapping = key.Mapping
+		if other == key {
+			return true
+		}
+	}
+	return false
+}
+
+// addFunction returns the ID of the function in p, adding it if needed.
+func (p *Profile) addFunction(key FunctionKey) uint64 {
+	if id, ok := p.functionKeyMap[key]; ok {
+		return id
+	}
+
+	id := uint64(len(p.functionKeyMap) + 1)
+	p.functionKeyMap[key] = id
+	p.functionIdKeyMap[id] = key
+	p.functionQualifiedMap[key.String()] = id
+	p.functionIdMap[id] = key.Name
+
+	if other, ok := p.functionNameMap[key.Name]; ok {
+		if _, ok := p.duplicateNames[key.Name]; !ok {
+			p.duplicateNames[key.Name] = []uint64{other}
+		}
+		p.duplicateNames[key.Name] = append(p.duplicateNames[key.Name], id)
+	} else {
+		p.functionNameMap[key.Name] = id
+	}
+	return id
+}
+
+// addSampleType returns the index of the sample type in p, adding it if
+// needed. Sample types are identified by both their type and unit.
+func (p *Profile) addSampleType(sampleType profile.ValueType) int {
+	for i, known := range p.sampleTypes {
+		if known.Type == sampleType.Type && known.Unit == sampleType.Unit {
+			return i
+		}
+	}
+	p.sampleTypes = append(p.sampleTypes, profile.ValueType{Type: sampleType.Type, Unit: sampleType.Unit})
+	return len(p.sampleTypes) - 1
+}
+
+// SampleTypes returns the names of the sample types in the Profile, e.g.
+// "samples" and "cpu" for a CPU profile.
+func (p *Profile) SampleTypes() []string {
+	names := make([]string, 0, len(p.sampleTypes))
+	for _, sampleType := range p.sampleTypes {
+		names = append(names, sampleType.Type)
+	}
+	return names
+}
+
+// sampleTypeIndex returns the index in p.sampleTypes of the named sample
+// type, or of the default sample type if name is empty.
+func (p *Profile) sampleTypeIndex(name string) (int, error) {
+	if name == "" {
+		if len(p.sampleTypes) == 0 {
+			return 0, fmt.Errorf("%w: profile has no sample types", ErrSampleTypeNotFound)
+		}
+		return p.defaultSampleType, nil
+	}
+	for i, sampleType := range p.sampleTypes {
+		if sampleType.Type == name {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrSampleTypeNotFound, name)
+}
+
+// sampleValue returns the value of sample i for the sample type at index t.
+func (p *Profile) sampleValue(i, t int) int64 {
+	if t >= len(p.values[i]) {
+		return 0
+	}
+	return p.values[i][t]
+}
+
+// Lookup resolves a function name, or a fully qualified key as returned by
+// FunctionKey.String, to the FunctionKey of the function in the Profile.
+//
+// It returns a *FunctionError wrapping ErrAmbiguousFunction, with all the
+// candidates, if more than one function goes by the name.
+func (p *Profile) Lookup(name string) (FunctionKey, error) {
+	id, err := p.lookup(name)
+	if err != nil {
+		return FunctionKey{}, err
+	}
+	return p.functionIdKeyMap[id], nil
+}
+
+func (p *Profile) lookup(name string) (uint64, error) {
+	if id, ok := p.functionQualifiedMap[name]; ok {
+		return id, nil
+	}
+
+	if ids, ok := p.duplicateNames[name]; ok {
+		return 0, p.ambiguousError(name, ids)
+	}
+
+	id, ok := p.functionNameMap[name]
+	if !ok {
+		return 0, &FunctionError{Name: name, Err: ErrFunctionNotFound}
+	}
+	return id, nil
+}
+
+func (p *Profile) ambiguousError(name string, ids []uint64) *FunctionError {
+	candidates := make([]FunctionKey, 0, len(ids))
+	for _, id := range ids {
+		candidates = append(candidates, p.functionIdKeyMap[id])
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].String() < candidates[j].String()
+	})
+	return &FunctionError{Name: name, Err: ErrAmbiguousFunction, Candidates: candidates}
+}
+
+// WithLabels returns a new Profile with only the samples of p matching the
+// label selector, e.g. to verify the functions called on behalf of one
+// tenant. Samples merged into the new Profile later are filtered as well.
+//
+// The new Profile keeps all functions of p, as well as the sources of the
+// samples it keeps (see SampleSource).
+func (p *Profile) WithLabels(selector LabelSelector) *Profile {
+	q := &Profile{
+		functionNameMap: maps.Clone(p.functionNameMap),
+		functionIdMap:   maps.Clone(p.functionIdMap),
+		duplicateNames:  make(map[string][]uint64, len(p.duplicateNames)),
+		functionKeyMap:  maps.Clone(p.functionKeyMap),
+
+		functionIdKeyMap:     maps.Clone(p.functionIdKeyMap),
+		functionQualifiedMap: maps.Clone(p.functionQualifiedMap),
+
+		sourceOffsets:     slices.Clone(p.sourceOffsets),
+		sampleTypes:       slices.Clone(p.sampleTypes),
+		defaultSampleType: p.defaultSampleType,
+
+		selector: LabelSelector{
+			matchers: append(slices.Clip(p.selector.matchers), selector.matchers...),
+		},
+		origins:    []int{},
+		numOrigins: p.numOrigins,
+	}
+	for name, ids := range p.duplicateNames {
+		q.duplicateNames[name] = slices.Clone(ids)
+	}
+
+	for i := range p.callStacks {
+		if !selector.Matches(p.labels[i], p.numLabels[i]) {
+			continue
+		}
+		q.callStacks = append(q.callStacks, p.callStacks[i])
+		q.inlined = append(q.inlined, p.inlined[i])
+		q.values = append(q.values, p.values[i])
+		q.labels = append(q.labels, p.labels[i])
+		q.numLabels = append(q.numLabels, p.numLabels[i])
+		q.origins = append(q.origins, p.origin(i))
+	}
+	return q
+}
+
+// origin returns the index sample i would have in p without its selector.
+func (p *Profile) origin(i int) int {
+	if p.origins == nil {
+		return i
+	}
+	return p.origins[i]
+}
+
+// Labels returns the pprof labels of the i-th sample of the Profile.
+func (p *Profile) Labels(i int) (labels map[string][]string, numLabels map[string][]int64) {
+	return p.labels[i], p.numLabels[i]
 }
 
 // Verifier returns a new Verifier for functions matching a
-// given regular expression.
-func (p *Profile) Verifier(namePattern string) (*Verifier, error) {
-	return NewVerifier(p, nil, namePattern)
+// given regular expression. See NewVerifier for the options.
+func (p *Profile) Verifier(namePattern string, opts ...VerifierOption) (*Verifier, error) {
+	return NewVerifier(p, nil, namePattern, opts...)
+}
+
+// Functions returns the sorted names of all functions in the profile.
+func (p *Profile) Functions() []string {
+	names := make([]string, 0, len(p.functionNameMap))
+	for name := range p.functionNameMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NumSamples returns the number of samples (and call stacks) in the
+// Profile.
+func (p *Profile) NumSamples() int {
+	return len(p.callStacks)
+}
+
+// NumSources returns the number of pprof profiles merged into the Profile.
+func (p *Profile) NumSources() int {
+	return len(p.sourceOffsets)
+}
+
+// SampleSource returns which pprof profile the i-th sample of the Profile
+// came from, as the index of that profile in the order it was added, and
+// the index of the sample within that profile.
+func (p *Profile) SampleSource(i int) (source, sample int) {
+	i = p.origin(i)
+	source = sort.Search(len(p.sourceOffsets), func(s int) bool {
+		return p.sourceOffsets[s] > i
+	}) - 1
+	return source, i - p.sourceOffsets[source]
 }
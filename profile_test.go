@@ -1,5 +1,16 @@
 package pprofsv_test
 
+import (
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gaukas/pprofsv"
+	"github.com/google/pprof/profile"
+)
+
 // func TestProfile(t *testing.T) {
 // 	file, err := os.Open("testdata/pprof.profile")
 // 	if err != nil {
@@ -26,3 +37,299 @@ package pprofsv_test
 // 		t.Logf("%d:%s", function.ID, function.Name)
 // 	}
 // }
+
+// loadProfile reads and parses the named pprof profile.
+func loadProfile(t testing.TB, name string) *profile.Profile {
+	t.Helper()
+
+	file, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	pprof, err := profile.Parse(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pprof
+}
+
+// syntheticProfile builds a pprof profile with one sample per stack. Each
+// stack lists function names from the root to the leaf separated by ";",
+// like the folded stack format.
+func syntheticProfile(stacks ...string) *profile.Profile {
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+	}
+
+	functions := make(map[string]*profile.Location)
+	for _, stack := range stacks {
+		names := strings.Split(stack, ";")
+		sample := &profile.Sample{Value: []int64{1}}
+		for i := len(names) - 1; i >= 0; i-- {
+			location, ok := functions[names[i]]
+			if !ok {
+				function := &profile.Function{
+					ID:   uint64(len(p.Function) + 1),
+					Name: names[i],
+				}
+				location = &profile.Location{
+					ID:   uint64(len(p.Location) + 1),
+					Line: []profile.Line{{Function: function}},
+				}
+				p.Function = append(p.Function, function)
+				p.Location = append(p.Location, location)
+				functions[names[i]] = location
+			}
+			sample.Location = append(sample.Location, location)
+		}
+		p.Sample = append(p.Sample, sample)
+	}
+	return p
+}
+
+func TestProfileMerge(t *testing.T) {
+	first := syntheticProfile("main;a;b", "main;a")
+	// the same functions get different IDs in the second profile
+	second := syntheticProfile("main;x", "main;b;c", "main;c")
+
+	p := pprofsv.NewProfileFromMany(first, second)
+	if p.NumSources() != 2 {
+		t.Errorf("expected 2 sources, got %d", p.NumSources())
+	}
+	if p.NumSamples() != 5 {
+		t.Errorf("expected 5 samples, got %d", p.NumSamples())
+	}
+
+	if functions := p.Functions(); !slices.Equal(functions, []string{"a", "b", "c", "main", "x"}) {
+		t.Errorf("unexpected functions %v", functions)
+	}
+
+	verifier, err := p.Verifier("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a -> b is only in the first profile and b -> c only in the second
+	witness := verifier.ReachablePath("a", "c")
+	if witness == nil {
+		t.Fatal("a -> c should be reachable across profiles")
+	}
+
+	for _, edge := range witness.Edges {
+		for _, i := range edge.Samples {
+			source, sample := p.SampleSource(i)
+			var expected struct{ source, sample int }
+			switch edge.From {
+			case "a":
+				expected.source, expected.sample = 0, 0
+			case "b":
+				expected.source, expected.sample = 1, 1
+			}
+			if source != expected.source || sample != expected.sample {
+				t.Errorf("%s -> %s: sample %d should come from sample %d of source %d, got sample %d of source %d",
+					edge.From, edge.To, i, expected.sample, expected.source, sample, source)
+			}
+		}
+	}
+
+	// merging more profiles later
+	p.Merge(syntheticProfile("c;main"))
+	if source, sample := p.SampleSource(5); source != 2 || sample != 0 {
+		t.Errorf("sample 5 should be sample 0 of source 2, got sample %d of source %d", sample, source)
+	}
+
+	verifier, err = p.Verifier("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verifier.Reachable("main", "main") {
+		t.Errorf("main -> main should be reachable after merging")
+	}
+}
+
+func TestProfileMergeIdentity(t *testing.T) {
+	// same name, but different source files
+	first := syntheticProfile("main;f")
+	second := syntheticProfile("main;f")
+	for _, function := range second.Function {
+		if function.Name == "f" {
+			function.Filename = "other.go"
+		}
+	}
+
+	verifier, err := pprofsv.NewProfileFromMany(first, second).Verifier("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := verifier.CheckNext("main", "f"); !errors.Is(err, pprofsv.ErrAmbiguousFunction) {
+		t.Errorf("f should be ambiguous, got %v", err)
+	}
+
+	// while main is the same function in both profiles
+	if _, err := verifier.CheckNext("main", "main"); err != nil {
+		t.Errorf("main should not be ambiguous, got %v", err)
+	}
+}
+
+func TestProfileMergeUnsampled(t *testing.T) {
+	// two profiles of the same binary, where F is only sampled in the first
+	mapped := func(stacks ...string) *profile.Profile {
+		pprof := syntheticProfile(stacks...)
+		mapping := &profile.Mapping{ID: 1, File: "/bin/x"}
+		pprof.Mapping = []*profile.Mapping{mapping}
+		for _, location := range pprof.Location {
+			location.Mapping = mapping
+		}
+		return pprof
+	}
+	first := mapped("main.main;main.F")
+	listed := mapped("main.main;main.G")
+	listed.Function = append(listed.Function, &profile.Function{ID: uint64(len(listed.Function) + 1), Name: "main.F"})
+	located := mapped("main.main;main.G")
+	function := &profile.Function{ID: uint64(len(located.Function) + 1), Name: "main.F"}
+	located.Function = append(located.Function, function)
+	located.Location = append(located.Location, &profile.Location{
+		ID:      uint64(len(located.Location) + 1),
+		Mapping: located.Mapping[0],
+		Line:    []profile.Line{{Function: function}},
+	})
+
+	for name, second := range map[string]*profile.Profile{"Listed": listed, "Located": located} {
+		t.Run(name, func(t *testing.T) {
+			p := pprofsv.NewProfileFromMany(first, second)
+			if functions := p.Functions(); !slices.Equal(functions, []string{"main.F", "main.G", "main.main"}) {
+				t.Errorf("unexpected functions %v", functions)
+			}
+			if key, err := p.Lookup("main.F"); err != nil || key.Mapping != "/bin/x" {
+				t.Errorf("expected main.F in /bin/x, got %v, %v", key, err)
+			}
+
+			verifier, err := p.Verifier("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reachable, err := verifier.CheckReachable("main.main", "main.F"); err != nil || !reachable {
+				t.Errorf("main.main -> main.F should be reachable, got %v, %v", reachable, err)
+			}
+		})
+	}
+}
+
+func TestProfileFunctionKey(t *testing.T) {
+	// same function, but in two different binaries
+	pprof := syntheticProfile("main;f", "main;g;f")
+	mappings := []*profile.Mapping{
+		{ID: 1, File: "/bin/a"},
+		{ID: 2, File: "/bin/b", BuildID: "b1d"},
+	}
+	pprof.Mapping = mappings
+	for _, location := range pprof.Location {
+		location.Mapping = mappings[0]
+		if location.Line[0].Function.Name == "f" {
+			// share the function, but not the location
+			other := &profile.Location{
+				ID:      uint64(len(pprof.Location) + 1),
+				Mapping: mappings[1],
+				Line:    location.Line,
+			}
+			pprof.Sample[1].Location[0] = other
+		}
+	}
+
+	p := pprofsv.NewProfile(pprof)
+
+	_, err := p.Lookup("f")
+	var functionErr *pprofsv.FunctionError
+	if !errors.As(err, &functionErr) || !errors.Is(err, pprofsv.ErrAmbiguousFunction) {
+		t.Fatalf("f should be ambiguous, got %v", err)
+	}
+	if len(functionErr.Candidates) != 2 {
+		t.Fatalf("f should have 2 candidates, got %v", functionErr.Candidates)
+	}
+	if functionErr.Candidates[0].Mapping != "/bin/a" || functionErr.Candidates[1].Mapping != "b1d" {
+		t.Errorf("unexpected candidate mappings: %v", functionErr.Candidates)
+	}
+
+	verifier, err := p.Verifier("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the qualified keys pick one of the candidates
+	fa, fb := functionErr.Candidates[0].String(), functionErr.Candidates[1].String()
+	for _, c := range []struct {
+		from, to string
+		next     bool
+	}{
+		{"main", fa, true},
+		{"main", fb, false},
+		{"g", fa, false},
+		{"g", fb, true},
+	} {
+		next, err := verifier.CheckNext(c.from, c.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next != c.next {
+			t.Errorf("Next(%s, %s) = %v, want %v", c.from, c.to, next, c.next)
+		}
+	}
+
+	key, err := pprofsv.ParseFunctionKey(fb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != functionErr.Candidates[1] {
+		t.Errorf("ParseFunctionKey(%q) = %#v", fb, key)
+	}
+
+	if key, err := p.Lookup("main"); err != nil || key.Mapping != "/bin/a" {
+		t.Errorf("Lookup(main) = %v, %v", key, err)
+	}
+}
+
+func TestProfileWithLabels(t *testing.T) {
+	pprof := syntheticProfile("main;accept;close", "main;accept;read", "main;connect;close")
+	for i, role := range []string{"server", "server", "client"} {
+		pprof.Sample[i].Label = map[string][]string{"role": {role}}
+	}
+
+	p := pprofsv.NewProfileFromMany(syntheticProfile("main;accept"), pprof)
+	server := p.WithLabels(pprofsv.MustParseLabelSelector("role=server"))
+	if n := server.NumSamples(); n != 2 {
+		t.Fatalf("expected 2 server samples, got %d", n)
+	}
+
+	// samples are traced back to their original source
+	if source, sample := server.SampleSource(1); source != 1 || sample != 1 {
+		t.Errorf("server sample 1 should come from sample 1 of source 1, got %d of %d", sample, source)
+	}
+
+	verifier, err := server.Verifier("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verifier.Reachable("accept", "close") {
+		t.Errorf("accept -> close should be reachable for servers")
+	}
+	if verifier.Reachable("main", "connect") {
+		t.Errorf("main -> connect should not be reachable for servers")
+	}
+
+	// later merges are filtered too
+	server.Merge(pprof)
+	if n := server.NumSamples(); n != 4 {
+		t.Errorf("expected 4 server samples, got %d", n)
+	}
+	if source, sample := server.SampleSource(3); source != 2 || sample != 1 {
+		t.Errorf("server sample 3 should come from sample 1 of source 2, got %d of %d", sample, source)
+	}
+
+	// p itself is unchanged
+	if n := p.NumSamples(); n != 4 {
+		t.Errorf("expected 4 samples, got %d", n)
+	}
+}
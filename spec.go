@@ -0,0 +1,550 @@
+package pprofsv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Spec is a declarative state-transition model: a set of assertions to be
+// verified on the functions of a profile matching a name pattern.
+//
+// A Spec is usually written in YAML (or JSON, which is a subset of YAML):
+//
+//	pattern: dummy
+//	prefix: github.com/gaukas/pprofsv/dummy.(*Dummy).
+//	assertions:
+//	  - kind: reachable
+//	    from: DeepFunc
+//	    to: deepFuncLv5
+//	  - kind: not-reachable
+//	    from: multiFuncA
+//	    to: multiFuncB
+//	  - kind: reachable-avoiding
+//	    from: MultiFunc
+//	    to: final
+//	    avoid: [multiFuncA, multiFuncB, multiFuncC]
+//	  - kind: share
+//	    from: BranchFunc
+//	    to: branchA
+//	    min: 0.4
+//	    max: 0.6
+type Spec struct {
+	// Pattern is the regular expression selecting the functions to
+	// build the Verifier with. See NewVerifier.
+	Pattern string `yaml:"pattern" json:"pattern"`
+
+	// Prefix is prepended to every function name in the assertions.
+	Prefix string `yaml:"prefix,omitempty" json:"prefix,omitempty"`
+
+	// Labels optionally restricts the samples to those whose pprof labels
+	// match the selector, e.g. "role=server". See LabelSelector.
+	Labels string `yaml:"labels,omitempty" json:"labels,omitempty"`
+
+	// Granularity optionally collapses the functions into packages, types
+	// or files, e.g. "package", so that the assertions are about them.
+	// See WithGranularity.
+	Granularity Granularity `yaml:"granularity,omitempty" json:"granularity,omitempty"`
+
+	Assertions []Assertion `yaml:"assertions" json:"assertions"`
+}
+
+// AssertionKind is the operator of an Assertion.
+type AssertionKind string
+
+const (
+	// AssertReachable asserts that To is reachable from From, without
+	// passing through any function in Avoid if given.
+	AssertReachable AssertionKind = "reachable"
+
+	// AssertNotReachable asserts that To is not reachable from From,
+	// without passing through any function in Avoid if given.
+	AssertNotReachable AssertionKind = "not-reachable"
+
+	// AssertNext asserts that From directly calls (or inlines) To.
+	AssertNext AssertionKind = "next"
+
+	// AssertNotNext asserts that From never directly calls (nor inlines) To.
+	AssertNotNext AssertionKind = "not-next"
+
+	// AssertReachableAvoiding is like AssertReachable, but requires Avoid
+	// to be non-empty.
+	AssertReachableAvoiding AssertionKind = "reachable-avoiding"
+
+	// AssertCTL asserts that the CTL Formula holds at From. See Formula.
+	AssertCTL AssertionKind = "ctl"
+
+	// AssertShare asserts that the share of the weight of From that goes
+	// through its direct path to To, in SampleType, is within [Min, Max].
+	// See Verifier.Share.
+	AssertShare AssertionKind = "share"
+
+	// AssertNoRecursion asserts that no function whose full name matches
+	// the regular expression Match may call itself, either directly or
+	// through other functions. See Verifier.FindRecursion.
+	AssertNoRecursion AssertionKind = "no-recursion"
+
+	// AssertMustPassThrough asserts that every path from From to To passes
+	// through Via. See Verifier.MustPassThrough.
+	AssertMustPassThrough AssertionKind = "must-pass-through"
+
+	// AssertBefore asserts that, on every goroutine where To is observed,
+	// From is observed before it. See Verifier.Before.
+	AssertBefore AssertionKind = "before"
+
+	// AssertEventually asserts that, on every goroutine, every observation
+	// of From is eventually followed by one of To. See
+	// Verifier.Eventually.
+	AssertEventually AssertionKind = "eventually"
+
+	// AssertNeverAfter asserts that From is never observed after To on
+	// the same goroutine. See Verifier.NeverAfter.
+	AssertNeverAfter AssertionKind = "never-after"
+)
+
+// Assertion is a single property in a Spec.
+type Assertion struct {
+	// Name optionally identifies the assertion in a Report.
+	Name string `yaml:"name,omitempty" json:"name,omitempty"`
+
+	Kind  AssertionKind `yaml:"kind" json:"kind"`
+	From  string        `yaml:"from,omitempty" json:"from,omitempty"`
+	To    string        `yaml:"to,omitempty" json:"to,omitempty"`
+	Avoid []string      `yaml:"avoid,omitempty" json:"avoid,omitempty"`
+
+	// Formula is the CTL formula of an AssertCTL assertion.
+	Formula string `yaml:"formula,omitempty" json:"formula,omitempty"`
+
+	// Min and Max bound the share, between 0 and 1, of an AssertShare
+	// assertion. At least one of them is required.
+	Min *float64 `yaml:"min,omitempty" json:"min,omitempty"`
+	Max *float64 `yaml:"max,omitempty" json:"max,omitempty"`
+
+	// SampleType selects the sample type of an AssertShare assertion, e.g.
+	// "cpu" or "alloc_space". It defaults to the profile's default.
+	SampleType string `yaml:"sample-type,omitempty" json:"sample-type,omitempty"`
+
+	// Match is the regular expression of an AssertNoRecursion assertion.
+	Match string `yaml:"match,omitempty" json:"match,omitempty"`
+
+	// Via is the function every path must pass through in an
+	// AssertMustPassThrough assertion.
+	Via string `yaml:"via,omitempty" json:"via,omitempty"`
+}
+
+// String returns a short human-readable representation of the Assertion,
+// e.g. "reachable(A, B, avoid=[C])".
+func (a Assertion) String() string {
+	var b strings.Builder
+	if a.Name != "" {
+		fmt.Fprintf(&b, "%s: ", a.Name)
+	}
+	switch a.Kind {
+	case AssertCTL:
+		fmt.Fprintf(&b, "%s(%s, %s)", a.Kind, a.From, a.Formula)
+		return b.String()
+	case AssertNoRecursion:
+		fmt.Fprintf(&b, "%s(%s)", a.Kind, a.Match)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "%s(%s, %s", a.Kind, a.From, a.To)
+	if len(a.Avoid) > 0 {
+		fmt.Fprintf(&b, ", avoid=[%s]", strings.Join(a.Avoid, ", "))
+	}
+	if a.Via != "" {
+		fmt.Fprintf(&b, ", via=%s", a.Via)
+	}
+	if a.Min != nil {
+		fmt.Fprintf(&b, ", min=%g", *a.Min)
+	}
+	if a.Max != nil {
+		fmt.Fprintf(&b, ", max=%g", *a.Max)
+	}
+	if a.SampleType != "" {
+		fmt.Fprintf(&b, ", sample-type=%s", a.SampleType)
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+// ParseAssertion parses an Assertion from its compact form: the kind,
+// followed by from and to, followed by any functions to avoid, separated
+// by whitespace. For a ctl assertion, the rest after from is the formula.
+// For a share assertion, to is followed by min, max and optionally the
+// sample type, where "-" leaves min or max unbounded. For a
+// must-pass-through assertion, to is followed by via. A no-recursion
+// assertion only takes the regular expression. For example:
+//
+//	reachable DeepFunc deepFuncLv5
+//	reachable-avoiding MultiFunc final multiFuncA multiFuncB
+//	ctl MultiFunc AF final
+//	share BranchFunc branchA 0.4 0.6 cpu
+//	must-pass-through DeepFunc deepFuncLv5 deepFuncLv3
+//	before handshake write
+//	no-recursion ^parser\.
+func ParseAssertion(s string) (Assertion, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 2 && AssertionKind(fields[0]) == AssertNoRecursion {
+		a := Assertion{
+			Kind:  AssertNoRecursion,
+			Match: fields[1],
+		}
+		return a, a.Validate()
+	}
+	if len(fields) < 3 {
+		return Assertion{}, fmt.Errorf("assertion %q: expected kind, from and to", s)
+	}
+
+	if AssertionKind(fields[0]) == AssertCTL {
+		a := Assertion{
+			Kind:    AssertCTL,
+			From:    fields[1],
+			Formula: strings.Join(fields[2:], " "),
+		}
+		return a, a.Validate()
+	}
+
+	a := Assertion{
+		Kind: AssertionKind(fields[0]),
+		From: fields[1],
+		To:   fields[2],
+	}
+	if a.Kind == AssertShare {
+		if len(fields) < 5 || len(fields) > 6 {
+			return Assertion{}, fmt.Errorf("assertion %q: expected from, to, min, max and an optional sample type", s)
+		}
+		var err error
+		if a.Min, err = parseBound(fields[3]); err != nil {
+			return Assertion{}, fmt.Errorf("assertion %q: min: %w", s, err)
+		}
+		if a.Max, err = parseBound(fields[4]); err != nil {
+			return Assertion{}, fmt.Errorf("assertion %q: max: %w", s, err)
+		}
+		if len(fields) == 6 {
+			a.SampleType = fields[5]
+		}
+		return a, a.Validate()
+	}
+	if a.Kind == AssertMustPassThrough {
+		if len(fields) != 4 {
+			return Assertion{}, fmt.Errorf("assertion %q: expected from, to and via", s)
+		}
+		a.Via = fields[3]
+		return a, a.Validate()
+	}
+	if len(fields) > 3 {
+		a.Avoid = fields[3:]
+	}
+	return a, a.Validate()
+}
+
+// Bound returns a pointer to f, for the Min and Max bounds of an Assertion
+// literal, e.g. Assertion{Kind: AssertShare, From: "Serve", To: "Query", Max: Bound(0.5)}.
+func Bound(f float64) *float64 {
+	return &f
+}
+
+// parseBound parses a bound of a share assertion, or "-" for none.
+func parseBound(s string) (*float64, error) {
+	if s == "-" {
+		return nil, nil
+	}
+	bound, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &bound, nil
+}
+
+// Validate checks that the Assertion is well-formed.
+func (a Assertion) Validate() error {
+	if a.Kind != AssertShare && (a.Min != nil || a.Max != nil || a.SampleType != "") {
+		return fmt.Errorf("%s: min, max and sample-type are only supported by %s", a, AssertShare)
+	}
+
+	if a.Kind == AssertNoRecursion {
+		if a.Match == "" || a.From != "" || a.To != "" || a.Via != "" || len(a.Avoid) > 0 || a.Formula != "" {
+			return fmt.Errorf("%s: exactly match is required", a)
+		}
+		if _, err := regexp.Compile(a.Match); err != nil {
+			return fmt.Errorf("%s: %w", a, err)
+		}
+		return nil
+	}
+	if a.Match != "" {
+		return fmt.Errorf("%s: match is only supported by %s", a, AssertNoRecursion)
+	}
+	if (a.Kind == AssertMustPassThrough) != (a.Via != "") {
+		return fmt.Errorf("%s: via is required by, and only supported by, %s", a, AssertMustPassThrough)
+	}
+
+	if a.Kind == AssertCTL {
+		if a.From == "" || a.Formula == "" || a.To != "" || len(a.Avoid) > 0 {
+			return fmt.Errorf("%s: exactly from and formula are required", a)
+		}
+		if _, err := ParseCTL(a.Formula); err != nil {
+			return fmt.Errorf("%s: %w", a, err)
+		}
+		return nil
+	}
+
+	if a.From == "" || a.To == "" {
+		return fmt.Errorf("%s: both from and to are required", a)
+	}
+	if a.Formula != "" {
+		return fmt.Errorf("%s: formula is not supported", a)
+	}
+
+	switch a.Kind {
+	case AssertReachable, AssertNotReachable:
+	case AssertReachableAvoiding:
+		if len(a.Avoid) == 0 {
+			return fmt.Errorf("%s: avoid is required", a)
+		}
+	case AssertNext, AssertNotNext, AssertMustPassThrough, AssertBefore, AssertEventually, AssertNeverAfter:
+		if len(a.Avoid) > 0 {
+			return fmt.Errorf("%s: avoid is not supported", a)
+		}
+	case AssertShare:
+		if len(a.Avoid) > 0 {
+			return fmt.Errorf("%s: avoid is not supported", a)
+		}
+		if a.Min == nil && a.Max == nil {
+			return fmt.Errorf("%s: min or max is required", a)
+		}
+		if (a.Min != nil && (*a.Min < 0 || *a.Min > 1)) || (a.Max != nil && (*a.Max < 0 || *a.Max > 1)) {
+			return fmt.Errorf("%s: min and max must be between 0 and 1", a)
+		}
+		if a.Min != nil && a.Max != nil && *a.Min > *a.Max {
+			return fmt.Errorf("%s: min is greater than max", a)
+		}
+	default:
+		return fmt.Errorf("%s: unknown assertion kind %q", a, a.Kind)
+	}
+	return nil
+}
+
+// LoadSpec reads a Spec in YAML or JSON from r and validates it.
+func LoadSpec(r io.Reader) (*Spec, error) {
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+
+	var spec Spec
+	if err := dec.Decode(&spec); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("empty spec")
+		}
+		return nil, err
+	}
+
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// LoadSpecFile reads a Spec in YAML or JSON from the named file.
+func LoadSpecFile(name string) (*Spec, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	spec, err := LoadSpec(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return spec, nil
+}
+
+// Validate checks that the label selector, the granularity and every
+// assertion in the Spec are well-formed.
+func (s *Spec) Validate() error {
+	if _, err := ParseLabelSelector(s.Labels); err != nil {
+		return err
+	}
+	if _, err := ParseGranularity(string(s.Granularity)); err != nil {
+		return err
+	}
+	for _, a := range s.Assertions {
+		if err := a.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Verify builds a Verifier from p with the Spec's pattern, prefix, label
+// selector and granularity and evaluates all the assertions with it.
+func (s *Spec) Verify(p *Profile) (*Report, error) {
+	labels, err := ParseLabelSelector(s.Labels)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := p.Verifier(s.Pattern, WithLabelSelector(labels), WithGranularity(s.Granularity))
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, fmt.Errorf("no call stacks match pattern %q", s.Pattern)
+	}
+	v.SetFunctionPrefix(s.Prefix)
+
+	return Evaluate(v, s.Assertions), nil
+}
+
+// Report is the outcome of evaluating a list of assertions.
+type Report struct {
+	Results []Result `yaml:"results" json:"results"`
+}
+
+// Result is the outcome of evaluating a single Assertion.
+type Result struct {
+	Assertion Assertion `yaml:"assertion" json:"assertion"`
+	Passed    bool      `yaml:"passed" json:"passed"`
+
+	// Error is set if the assertion could not be evaluated, e.g. because
+	// a function could not be resolved. Such an assertion never passes.
+	Error string `yaml:"error,omitempty" json:"error,omitempty"`
+
+	// Witness is the path found for a reachability or next assertion: the
+	// proof if the assertion passed, or the counterexample if it failed.
+	// For a no-recursion or must-pass-through assertion, it is the cycle
+	// or the path that made it fail.
+	Witness *Witness `yaml:"witness,omitempty" json:"witness,omitempty"`
+
+	// Share is the observed share of a share assertion.
+	Share *float64 `yaml:"share,omitempty" json:"share,omitempty"`
+
+	// Order is the counterexample of a failed before, eventually or
+	// never-after assertion.
+	Order *OrderWitness `yaml:"order,omitempty" json:"order,omitempty"`
+}
+
+// String returns a one-line summary of the Result.
+func (r Result) String() string {
+	status := "PASS"
+	if !r.Passed {
+		status = "FAIL"
+	}
+
+	switch {
+	case r.Error != "":
+		return fmt.Sprintf("%s %s: %s", status, r.Assertion, r.Error)
+	case r.Witness != nil && !r.Passed:
+		return fmt.Sprintf("%s %s: counterexample %s", status, r.Assertion, r.Witness)
+	case r.Order != nil && !r.Passed:
+		return fmt.Sprintf("%s %s: counterexample %s", status, r.Assertion, r.Order)
+	case r.Share != nil:
+		return fmt.Sprintf("%s %s: observed %.3f", status, r.Assertion, *r.Share)
+	default:
+		return fmt.Sprintf("%s %s", status, r.Assertion)
+	}
+}
+
+// Passed returns true if every assertion in the Report passed.
+func (r *Report) Passed() bool {
+	for _, result := range r.Results {
+		if !result.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// Failures returns the results of the assertions that did not pass.
+func (r *Report) Failures() []Result {
+	var failures []Result
+	for _, result := range r.Results {
+		if !result.Passed {
+			failures = append(failures, result)
+		}
+	}
+	return failures
+}
+
+// Evaluate checks every assertion against v and collects the results in
+// a Report. Function names in the assertions are resolved with v's
+// function prefix.
+func Evaluate(v *Verifier, assertions []Assertion) *Report {
+	report := &Report{Results: make([]Result, 0, len(assertions))}
+	for _, a := range assertions {
+		report.Results = append(report.Results, evaluate(v, a))
+	}
+	return report
+}
+
+func evaluate(v *Verifier, a Assertion) Result {
+	result := Result{Assertion: a}
+	if err := a.Validate(); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	switch a.Kind {
+	case AssertReachable, AssertNotReachable, AssertReachableAvoiding:
+		witness, err := v.CheckReachablePath(a.From, a.To, a.Avoid...)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.Witness = witness
+		result.Passed = (witness != nil) == (a.Kind != AssertNotReachable)
+	case AssertNext, AssertNotNext:
+		witness, err := v.nextWitness(a.From, a.To)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.Witness = witness
+		result.Passed = (witness != nil) == (a.Kind == AssertNext)
+	case AssertCTL:
+		holds, err := v.CheckCTL(a.From, MustParseCTL(a.Formula))
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.Passed = holds
+	case AssertShare:
+		share, err := v.Share(a.From, a.To, a.SampleType)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.Share = &share
+		result.Passed = (a.Min == nil || share >= *a.Min) && (a.Max == nil || share <= *a.Max)
+	case AssertMustPassThrough:
+		witness, err := v.MustPassThrough(a.From, a.To, a.Via)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.Witness = witness
+		result.Passed = witness == nil
+	case AssertBefore, AssertEventually, AssertNeverAfter:
+		witness, err := v.orderWitness(a.Kind, a.From, a.To)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.Order = witness
+		result.Passed = witness == nil
+	case AssertNoRecursion:
+		witness, err := v.FindRecursion(a.Match)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.Witness = witness
+		result.Passed = witness == nil
+	}
+	return result
+}
@@ -0,0 +1,156 @@
+package pprofsv_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gaukas/pprofsv"
+)
+
+func TestSpec(t *testing.T) {
+	pprof := loadProfile(t, "testdata/pprof.profile")
+	p := pprofsv.NewProfile(pprof)
+
+	t.Run("YAML", func(t *testing.T) {
+		testSpecYAML(t, p)
+	})
+	t.Run("JSON", func(t *testing.T) {
+		testSpecJSON(t, p)
+	})
+	t.Run("Granularity", func(t *testing.T) {
+		testSpecGranularity(t, p)
+	})
+	t.Run("Invalid", testSpecInvalid)
+}
+
+func testSpecYAML(t *testing.T, p *pprofsv.Profile) {
+	spec, err := pprofsv.LoadSpecFile("testdata/dummy.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := spec.Verify(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Results) != len(spec.Assertions) {
+		t.Fatalf("expected %d results, got %d", len(spec.Assertions), len(report.Results))
+	}
+
+	for _, result := range report.Results {
+		if !result.Passed {
+			t.Errorf("%s", result)
+		}
+	}
+
+	if !report.Passed() {
+		t.Errorf("report should pass")
+	}
+
+	// a passing reachability assertion carries its proof
+	if report.Results[0].Witness == nil {
+		t.Errorf("%s should carry a witness", report.Results[0].Assertion)
+	}
+}
+
+func testSpecJSON(t *testing.T, p *pprofsv.Profile) {
+	spec, err := pprofsv.LoadSpec(strings.NewReader(`{
+		"pattern": "dummy",
+		"prefix": "github.com/gaukas/pprofsv/dummy.(*Dummy).",
+		"assertions": [
+			{"kind": "not-reachable", "from": "DeepFunc", "to": "deepFuncLv5"},
+			{"kind": "next", "from": "DeepFunc", "to": "deepFuncLv9"},
+			{"kind": "reachable", "from": "DeepFunc", "to": "deepFuncLv1"},
+			{"kind": "ctl", "from": "DeepFunc", "formula": "AX deepFuncLv1"},
+			{"kind": "share", "from": "DeepFunc", "to": "deepFuncLv1", "max": 0.5}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := spec.Verify(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Passed() {
+		t.Errorf("report should not pass")
+	}
+
+	failures := report.Failures()
+	if len(failures) != 3 {
+		t.Fatalf("expected 3 failures, got %d", len(failures))
+	}
+
+	// a failing not-reachable assertion carries its counterexample
+	if failures[0].Witness == nil || failures[0].Error != "" {
+		t.Errorf("%s should fail with a counterexample", failures[0])
+	}
+
+	// a typo is an error, not a plain failure
+	if failures[1].Error == "" {
+		t.Errorf("%s should fail with an error", failures[1])
+	}
+
+	// a failing share assertion carries the observed share
+	if failures[2].Share == nil || *failures[2].Share <= 0.5 {
+		t.Errorf("%s should fail with the observed share", failures[2])
+	}
+}
+
+func testSpecGranularity(t *testing.T, p *pprofsv.Profile) {
+	spec, err := pprofsv.LoadSpec(strings.NewReader(`
+pattern: dummy
+prefix: github.com/gaukas/pprofsv/
+granularity: package
+assertions:
+  - {kind: next, from: dummy_test, to: dummy}
+  - {kind: not-reachable, from: dummy, to: dummy_test}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := spec.Verify(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range report.Results {
+		if !result.Passed {
+			t.Errorf("%s", result)
+		}
+	}
+}
+
+func testSpecInvalid(t *testing.T) {
+	for name, input := range map[string]string{
+		"Empty":          ``,
+		"UnknownKind":    `{"assertions": [{"kind": "always", "from": "A", "to": "B"}]}`,
+		"UnknownField":   `{"assertions": [{"kind": "next", "from": "A", "to": "B", "through": "C"}]}`,
+		"MissingTo":      `{"assertions": [{"kind": "next", "from": "A"}]}`,
+		"MissingAvoid":   `{"assertions": [{"kind": "reachable-avoiding", "from": "A", "to": "B"}]}`,
+		"AvoidWithNext":  `{"assertions": [{"kind": "next", "from": "A", "to": "B", "avoid": ["C"]}]}`,
+		"CTLWithTo":      `{"assertions": [{"kind": "ctl", "from": "A", "to": "B", "formula": "EF B"}]}`,
+		"BadCTL":         `{"assertions": [{"kind": "ctl", "from": "A", "formula": "EF"}]}`,
+		"FormulaOnNext":  `{"assertions": [{"kind": "next", "from": "A", "to": "B", "formula": "EF B"}]}`,
+		"ShareNoBounds":  `{"assertions": [{"kind": "share", "from": "A", "to": "B"}]}`,
+		"ShareMinOver":   `{"assertions": [{"kind": "share", "from": "A", "to": "B", "min": 0.6, "max": 0.4}]}`,
+		"SharePercent":   `{"assertions": [{"kind": "share", "from": "A", "to": "B", "min": 40}]}`,
+		"MinOnNext":      `{"assertions": [{"kind": "next", "from": "A", "to": "B", "min": 0.4}]}`,
+		"NoRecursionTo":  `{"assertions": [{"kind": "no-recursion", "match": "A", "to": "B"}]}`,
+		"NoRecursionVia": `{"assertions": [{"kind": "no-recursion", "match": "A", "via": "B"}]}`,
+		"BadMatch":       `{"assertions": [{"kind": "no-recursion", "match": "("}]}`,
+		"MatchOnNext":    `{"assertions": [{"kind": "next", "from": "A", "to": "B", "match": "A"}]}`,
+		"MissingVia":     `{"assertions": [{"kind": "must-pass-through", "from": "A", "to": "B"}]}`,
+		"ViaOnNext":      `{"assertions": [{"kind": "next", "from": "A", "to": "B", "via": "C"}]}`,
+		"AvoidOnBefore":  `{"assertions": [{"kind": "before", "from": "A", "to": "B", "avoid": ["C"]}]}`,
+		"BadLabels":      `{"labels": "role", "assertions": [{"kind": "next", "from": "A", "to": "B"}]}`,
+		"BadGranularity": `{"granularity": "module", "assertions": [{"kind": "next", "from": "A", "to": "B"}]}`,
+	} {
+		if _, err := pprofsv.LoadSpec(strings.NewReader(input)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package pprofsv
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// stackBuilder builds a pprof profile out of call stacks parsed from text,
+// such as goroutine dumps, folded stacks or perf script output, interning
+// their functions, mappings and locations.
+type stackBuilder struct {
+	p *profile.Profile
+
+	functions map[[2]string]*profile.Function // name and file name
+	mappings  map[string]*profile.Mapping     // file name
+	locations map[string]*profile.Location
+}
+
+func newStackBuilder(sampleTypes ...*profile.ValueType) *stackBuilder {
+	return &stackBuilder{
+		p: &profile.Profile{
+			SampleType: sampleTypes,
+			PeriodType: sampleTypes[0],
+			Period:     1,
+		},
+		functions: make(map[[2]string]*profile.Function),
+		mappings:  make(map[string]*profile.Mapping),
+		locations: make(map[string]*profile.Location),
+	}
+}
+
+// function returns the function with the given name and file name.
+func (b *stackBuilder) function(name, file string) *profile.Function {
+	function, ok := b.functions[[2]string{name, file}]
+	if !ok {
+		function = &profile.Function{
+			ID:         uint64(len(b.p.Function) + 1),
+			Name:       name,
+			SystemName: name,
+			Filename:   file,
+		}
+		b.p.Function = append(b.p.Function, function)
+		b.functions[[2]string{name, file}] = function
+	}
+	return function
+}
+
+// mapping returns the mapping of the binary or shared library with the
+// given file name, or nil if the name is empty.
+func (b *stackBuilder) mapping(file string) *profile.Mapping {
+	if file == "" {
+		return nil
+	}
+	mapping, ok := b.mappings[file]
+	if !ok {
+		mapping = &profile.Mapping{
+			ID:   uint64(len(b.p.Mapping) + 1),
+			File: file,
+		}
+		b.p.Mapping = append(b.p.Mapping, mapping)
+		b.mappings[file] = mapping
+	}
+	return mapping
+}
+
+// location returns the location at the address in the mapping, if any,
+// with the given lines: as in pprof, the lines before the last one have
+// been inlined into the line that follows.
+func (b *stackBuilder) location(mapping *profile.Mapping, address uint64, lines ...profile.Line) *profile.Location {
+	var key strings.Builder
+	if mapping != nil {
+		fmt.Fprintf(&key, "%d", mapping.ID)
+	}
+	fmt.Fprintf(&key, "@%x", address)
+	for _, line := range lines {
+		fmt.Fprintf(&key, ";%d:%d", line.Function.ID, line.Line)
+	}
+
+	location, ok := b.locations[key.String()]
+	if !ok {
+		location = &profile.Location{
+			ID:      uint64(len(b.p.Location) + 1),
+			Mapping: mapping,
+			Address: address,
+			Line:    lines,
+		}
+		b.p.Location = append(b.p.Location, location)
+		b.locations[key.String()] = location
+	}
+	return location
+}
@@ -0,0 +1,298 @@
+package pprofsv
+
+import (
+	"cmp"
+	"slices"
+	"sort"
+)
+
+// Labels set on the samples of an execution trace, besides
+// GoroutineIDLabel, by the github.com/gaukas/pprofsv/trace module.
+const (
+	// TraceTimeLabel is the numeric timestamp of the event, in
+	// nanoseconds since an arbitrary point in time.
+	TraceTimeLabel = "time"
+
+	// TraceEventLabel is the kind of the event, e.g. "StateTransition"
+	// for a goroutine blocking or "StackSample" for a CPU sample.
+	TraceEventLabel = "event"
+)
+
+// Timeline is the time-ordered counterpart of Path. Where Path only knows
+// which functions called which, Timeline knows in which order functions
+// were observed on every goroutine, e.g. from the events of an execution
+// trace, so that it can answer happens-before questions such as "is the
+// handshake always done before the first write?".
+//
+// Like Path, it represents functions by their pseudoIDs. An observation is
+// a set of functions seen together at one point in time, e.g. the
+// functions on the stack of a goroutine when it blocked.
+type Timeline struct {
+	n int
+
+	// goroutines[g] lists the observations on goroutine g, sorted by
+	// time and then by sample.
+	goroutines map[int64][]observation
+}
+
+type observation struct {
+	time      int64
+	sample    int
+	functions bitset
+}
+
+// NewTimeline returns a new empty Timeline with size n.
+func NewTimeline(n int) *Timeline {
+	return &Timeline{
+		n:          n,
+		goroutines: make(map[int64][]observation),
+	}
+}
+
+// Observe records that the functions were observed together on the
+// goroutine at the given time, in the given sample. Observations may be
+// recorded in any order.
+func (t *Timeline) Observe(goroutine, time int64, sample int, functions ...int) {
+	o := observation{time: time, sample: sample, functions: newBitset(t.n)}
+	for _, function := range functions {
+		o.functions.set(function)
+	}
+
+	observations := t.goroutines[goroutine]
+	i, _ := slices.BinarySearchFunc(observations, o, compareObservations)
+	t.goroutines[goroutine] = slices.Insert(observations, i, o)
+}
+
+func compareObservations(a, b observation) int {
+	if c := cmp.Compare(a.time, b.time); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.sample, b.sample)
+}
+
+// Before reports whether, on every goroutine where j is observed, i is
+// observed strictly earlier than the first observation of j.
+func (t *Timeline) Before(i, j int) bool {
+	return t.findBefore(i, j) == nil
+}
+
+// Eventually reports whether, on every goroutine, every observation of i
+// is followed by an observation of j, at the same time or later.
+func (t *Timeline) Eventually(i, j int) bool {
+	return t.findEventually(i, j) == nil
+}
+
+// NeverAfter reports whether, on every goroutine, i is never observed
+// strictly after an observation of j.
+func (t *Timeline) NeverAfter(i, j int) bool {
+	return t.findNeverAfter(i, j) == nil
+}
+
+// violation is a counterexample to an ordering property: the observations
+// of a goroutine, and the function of each that breaks the property.
+type violation struct {
+	goroutine    int64
+	observations []observation
+	functions    []int
+}
+
+// findBefore returns the first observation of j not preceded by i.
+func (t *Timeline) findBefore(i, j int) *violation {
+	for _, g := range t.goroutineIds() {
+		for _, o := range t.goroutines[g] {
+			if o.functions.has(j) {
+				return &violation{goroutine: g, observations: []observation{o}, functions: []int{j}}
+			}
+			if o.functions.has(i) {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// findEventually returns the first observation of i not followed by j.
+func (t *Timeline) findEventually(i, j int) *violation {
+	for _, g := range t.goroutineIds() {
+		pending := -1 // the first observation of i not yet followed by j
+		for k, o := range t.goroutines[g] {
+			if o.functions.has(i) && pending < 0 {
+				pending = k
+			}
+			if o.functions.has(j) {
+				pending = -1
+			}
+		}
+		if pending >= 0 {
+			return &violation{goroutine: g, observations: []observation{t.goroutines[g][pending]}, functions: []int{i}}
+		}
+	}
+	return nil
+}
+
+// findNeverAfter returns the first observation of j followed by an
+// observation of i.
+func (t *Timeline) findNeverAfter(i, j int) *violation {
+	for _, g := range t.goroutineIds() {
+		first := -1 // the first observation of j
+		for k, o := range t.goroutines[g] {
+			if o.functions.has(i) && first >= 0 && o.time > t.goroutines[g][first].time {
+				return &violation{goroutine: g, observations: []observation{t.goroutines[g][first], o}, functions: []int{j, i}}
+			}
+			if o.functions.has(j) && first < 0 {
+				first = k
+			}
+		}
+	}
+	return nil
+}
+
+// goroutineIds returns the sorted IDs of the goroutines, so that
+// counterexamples are deterministic.
+func (t *Timeline) goroutineIds() []int64 {
+	ids := make([]int64, 0, len(t.goroutines))
+	for g := range t.goroutines {
+		ids = append(ids, g)
+	}
+	sort.Slice(ids, func(a, b int) bool {
+		return ids[a] < ids[b]
+	})
+	return ids
+}
+
+// buildTimeline records the reduced call stack of every sample labeled
+// with a goroutine and a time, as in the profiles of execution traces, in
+// the timeline. It leaves the timeline nil if there is no such sample.
+func (v *Verifier) buildTimeline() {
+	if !v.weighted {
+		return
+	}
+
+	for s, callStack := range v.callStacks {
+		numLabels := v.masterProfile.numLabels[v.samples[s]]
+		goroutine, time := numLabels[GoroutineIDLabel], numLabels[TraceTimeLabel]
+		if len(goroutine) == 0 || len(time) == 0 {
+			continue
+		}
+
+		if v.timeline == nil {
+			v.timeline = NewTimeline(len(v.pseudoFunctionIdMap))
+		}
+		functions := make([]int, 0, len(callStack))
+		for _, id := range callStack {
+			functions = append(functions, int(v.functionIdPseudoMap[id]))
+		}
+		v.timeline.Observe(goroutine[0], time[0], v.samples[s], functions...)
+	}
+}
+
+// Before checks if, on every goroutine where function `to` is observed,
+// function `from` is observed before it, e.g. that a connection is always
+// handshaken before it is first written to. It requires a profile with
+// timestamps, such as those of execution traces.
+//
+// If a function cannot be found, or the order of the observations is
+// unknown, Before logs the error and returns false, or panics in strict
+// mode. Use CheckBefore to tell the outcomes apart.
+func (v *Verifier) Before(from, to string) bool {
+	holds, err := v.CheckBefore(from, to)
+	if err != nil {
+		v.lookupFailed(err)
+	}
+	return holds
+}
+
+// CheckBefore is like Before, but returns an error if either function
+// cannot be resolved or the Verifier has no timeline.
+func (v *Verifier) CheckBefore(from, to string) (bool, error) {
+	witness, err := v.orderWitness(AssertBefore, from, to)
+	return witness == nil && err == nil, err
+}
+
+// Eventually checks if, on every goroutine, every observation of function
+// `from` is eventually followed by an observation of function `to`, e.g.
+// that every opened file is closed. It requires a profile with timestamps,
+// such as those of execution traces.
+//
+// If a function cannot be found, or the order of the observations is
+// unknown, Eventually logs the error and returns false, or panics in
+// strict mode. Use CheckEventually to tell the outcomes apart.
+func (v *Verifier) Eventually(from, to string) bool {
+	holds, err := v.CheckEventually(from, to)
+	if err != nil {
+		v.lookupFailed(err)
+	}
+	return holds
+}
+
+// CheckEventually is like Eventually, but returns an error if either
+// function cannot be resolved or the Verifier has no timeline.
+func (v *Verifier) CheckEventually(from, to string) (bool, error) {
+	witness, err := v.orderWitness(AssertEventually, from, to)
+	return witness == nil && err == nil, err
+}
+
+// NeverAfter checks if function `from` is never observed after function
+// `to` on the same goroutine, e.g. that a connection is never written to
+// after it is closed. It requires a profile with timestamps, such as those
+// of execution traces.
+//
+// If a function cannot be found, or the order of the observations is
+// unknown, NeverAfter logs the error and returns false, or panics in
+// strict mode. Use CheckNeverAfter to tell the outcomes apart.
+func (v *Verifier) NeverAfter(from, to string) bool {
+	holds, err := v.CheckNeverAfter(from, to)
+	if err != nil {
+		v.lookupFailed(err)
+	}
+	return holds
+}
+
+// CheckNeverAfter is like NeverAfter, but returns an error if either
+// function cannot be resolved or the Verifier has no timeline.
+func (v *Verifier) CheckNeverAfter(from, to string) (bool, error) {
+	witness, err := v.orderWitness(AssertNeverAfter, from, to)
+	return witness == nil && err == nil, err
+}
+
+// orderWitness returns the counterexample to the ordering assertion of the
+// given kind between function `from` and function `to`, or nil if it
+// holds.
+func (v *Verifier) orderWitness(kind AssertionKind, from, to string) (*OrderWitness, error) {
+	fromId, err := v.lookup(from)
+	if err != nil {
+		return nil, err
+	}
+
+	toId, err := v.lookup(to)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.timeline == nil {
+		return nil, ErrNoTimeline
+	}
+
+	var found *violation
+	switch kind {
+	case AssertBefore:
+		found = v.timeline.findBefore(fromId, toId)
+	case AssertEventually:
+		found = v.timeline.findEventually(fromId, toId)
+	case AssertNeverAfter:
+		found = v.timeline.findNeverAfter(fromId, toId)
+	}
+	if found == nil {
+		return nil, nil
+	}
+
+	w := &OrderWitness{Goroutine: found.goroutine}
+	for k, o := range found.observations {
+		w.Events = append(w.Events, OrderEvent{
+			Function: v.name(found.functions[k]),
+			Time:     o.time,
+			Sample:   o.sample,
+		})
+	}
+	return w, nil
+}
@@ -0,0 +1,65 @@
+package pprofsv_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gaukas/pprofsv"
+)
+
+func TestTimeline(t *testing.T) {
+	const (
+		open = iota
+		handshake
+		write
+		close
+	)
+
+	tl := pprofsv.NewTimeline(4)
+	// goroutine 1: open, handshake, write, write, close
+	tl.Observe(1, 10, 0, open)
+	tl.Observe(1, 20, 1, handshake)
+	tl.Observe(1, 30, 2, write)
+	tl.Observe(1, 50, 4, close)
+	tl.Observe(1, 40, 3, write) // out of order
+	// goroutine 2: open, then write while handshaking
+	tl.Observe(2, 15, 5, open)
+	tl.Observe(2, 25, 6, handshake, write)
+
+	for _, tc := range []struct {
+		name                           string
+		i, j                           int
+		before, eventually, neverAfter bool
+	}{
+		{name: "OpenHandshake", i: open, j: handshake, before: true, eventually: true, neverAfter: true},
+		{name: "HandshakeWrite", i: handshake, j: write, before: false, eventually: true, neverAfter: true},
+		{name: "OpenClose", i: open, j: close, before: true, eventually: false, neverAfter: true},
+		{name: "WriteClose", i: write, j: close, before: true, eventually: false, neverAfter: true},
+		{name: "CloseWrite", i: close, j: write, before: false, eventually: false, neverAfter: false},
+		{name: "WriteHandshake", i: write, j: handshake, before: false, eventually: false, neverAfter: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if before := tl.Before(tc.i, tc.j); before != tc.before {
+				t.Errorf("Before(%d, %d) = %v, expected %v", tc.i, tc.j, before, tc.before)
+			}
+			if eventually := tl.Eventually(tc.i, tc.j); eventually != tc.eventually {
+				t.Errorf("Eventually(%d, %d) = %v, expected %v", tc.i, tc.j, eventually, tc.eventually)
+			}
+			if notAfter := tl.NeverAfter(tc.i, tc.j); notAfter != tc.neverAfter {
+				t.Errorf("NeverAfter(%d, %d) = %v, expected %v", tc.i, tc.j, notAfter, tc.neverAfter)
+			}
+		})
+	}
+}
+
+func TestTimelineProfile(t *testing.T) {
+	// a profile has no timeline
+	dummy := loadProfile(t, "testdata/pprof.profile")
+	verifier, err := pprofsv.NewProfile(dummy).Verifier("dummy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.CheckBefore("github.com/gaukas/pprofsv/dummy.(*Dummy).DeepFunc", "github.com/gaukas/pprofsv/dummy.(*Dummy).deepFuncLv5"); !errors.Is(err, pprofsv.ErrNoTimeline) {
+		t.Errorf("expected ErrNoTimeline, got %v", err)
+	}
+}
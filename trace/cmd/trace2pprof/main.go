@@ -0,0 +1,85 @@
+// Command trace2pprof converts Go execution traces into pprof profiles, so
+// that the pprofsv command can check the before, eventually and
+// never-after assertions on them.
+//
+// Usage:
+//
+//	trace2pprof [-o profile.pb.gz] trace.out
+//
+// The profile is written to standard output unless -o is given. Every
+// event with a stack becomes a sample labeled with its goroutine, time and
+// kind: see trace.Parse. For example:
+//
+//	go test -trace trace.out ./conn
+//	trace2pprof -o trace.pb.gz trace.out && pprofsv check -spec conn.yaml trace.pb.gz
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gaukas/pprofsv/trace"
+)
+
+const (
+	exitOK    = 0
+	exitError = 2
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("trace2pprof", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: trace2pprof [-o profile.pb.gz] trace.out")
+		fs.PrintDefaults()
+	}
+	output := fs.String("o", "", "`file` to write the profile to instead of standard output")
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+
+	if err := convert(fs.Args(), *output, stdout); err != nil {
+		fmt.Fprintf(stderr, "trace2pprof: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// convert parses the named execution trace and writes it as a gzipped
+// pprof profile to the output file, or to stdout if there is none.
+func convert(args []string, output string, stdout io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("expected exactly one trace")
+	}
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	pprof, err := trace.Parse(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+
+	if output == "" {
+		return pprof.Write(stdout)
+	}
+	out, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if err := pprof.Write(out); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime/trace"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gaukas/pprofsv"
+	"github.com/google/pprof/profile"
+)
+
+//go:noinline
+func open() { time.Sleep(time.Millisecond) }
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "trace.out")
+	file, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := trace.Start(file); err != nil {
+		t.Fatal(err)
+	}
+	open()
+	trace.Stop()
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	output := filepath.Join(dir, "trace.pb.gz")
+	if exitCode := run([]string{"-o", output, name}, &stdout, &stderr); exitCode != exitOK {
+		t.Fatalf("expected exit code %d, got %d\nstderr:\n%s", exitOK, exitCode, stderr.String())
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pprof, err := profile.ParseData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := pprofsv.NewProfile(pprof).Verifier(`trace2pprof\.`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifier == nil || !verifier.Reachable("github.com/gaukas/pprofsv/trace/cmd/trace2pprof.TestRun", "github.com/gaukas/pprofsv/trace/cmd/trace2pprof.open") {
+		t.Error("TestRun -> open should be reachable in the converted trace")
+	}
+
+	stderr.Reset()
+	if exitCode := run([]string{filepath.Join(dir, "missing.out")}, &stdout, &stderr); exitCode != exitError || !strings.HasPrefix(stderr.String(), "trace2pprof: ") {
+		t.Errorf("expected exit code %d and an error, got %d\nstderr:\n%s", exitError, exitCode, stderr.String())
+	}
+}
@@ -0,0 +1,14 @@
+module github.com/gaukas/pprofsv/trace
+
+go 1.25.0
+
+require (
+	github.com/gaukas/pprofsv v0.0.0-20261018045334-dff267e16645
+	github.com/google/pprof v0.0.0-20231101202521-4ca4178f5c7a
+	golang.org/x/exp v0.0.0-20260611194520-c48552f49976
+)
+
+require (
+	github.com/crillab/gophersat v1.3.1 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
@@ -0,0 +1,121 @@
+// Package trace parses Go execution traces, as written by runtime/trace or
+// with go test -trace, into pprof profiles that pprofsv.NewProfile accepts,
+// for the ordering assertions (before, eventually and never-after) that
+// profiles cannot answer.
+//
+// It is a module of its own, since golang.org/x/exp/trace, which reads the
+// traces, requires a newer Go than the rest of pprofsv.
+package trace
+
+import (
+	"errors"
+	"io"
+
+	"github.com/gaukas/pprofsv"
+	"github.com/google/pprof/profile"
+	exptrace "golang.org/x/exp/trace"
+)
+
+// Parse parses an execution trace into a pprof profile.
+//
+// Every event with a stack, e.g. a goroutine being created, blocking or
+// sampled by the CPU profiler, becomes a sample with the events/count
+// sample type, labeled with the goroutine it was observed on
+// (pprofsv.GoroutineIDLabel), its time (pprofsv.TraceTimeLabel) and its
+// kind (pprofsv.TraceEventLabel). So a Verifier built from the profile
+// answers the same reachability queries as on a pprof profile, and can
+// also tell the order in which functions were observed on every
+// goroutine: see Verifier.Before, Verifier.Eventually and
+// Verifier.NeverAfter.
+//
+// Unlike in a goroutine dump, frames inlined by the compiler are not
+// marked: the runtime gives every frame of a trace its own address, a
+// virtual one within their caller for inlined calls, and does not record
+// which frames share a physical one, so all the edges are calls.
+func Parse(r io.Reader) (*profile.Profile, error) {
+	reader, err := exptrace.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "events", Unit: "count"}},
+		PeriodType: &profile.ValueType{Type: "events", Unit: "count"},
+		Period:     1,
+	}
+	type locationKey struct {
+		pc       uint64
+		function *profile.Function
+		line     int64
+	}
+	functions := make(map[[2]string]*profile.Function) // name and file name
+	locations := make(map[locationKey]*profile.Location)
+	location := func(frame exptrace.StackFrame) *profile.Location {
+		function, ok := functions[[2]string{frame.Func, frame.File}]
+		if !ok {
+			function = &profile.Function{
+				ID:         uint64(len(p.Function) + 1),
+				Name:       frame.Func,
+				SystemName: frame.Func,
+				Filename:   frame.File,
+			}
+			p.Function = append(p.Function, function)
+			functions[[2]string{frame.Func, frame.File}] = function
+		}
+
+		key := locationKey{pc: frame.PC, function: function, line: int64(frame.Line)}
+		location, ok := locations[key]
+		if !ok {
+			location = &profile.Location{
+				ID:      uint64(len(p.Location) + 1),
+				Address: frame.PC,
+				Line:    []profile.Line{{Function: function, Line: int64(frame.Line)}},
+			}
+			p.Location = append(p.Location, location)
+			locations[key] = location
+		}
+		return location
+	}
+
+	add := func(ev *exptrace.Event, goroutine exptrace.GoID, stack exptrace.Stack) {
+		if goroutine == exptrace.NoGoroutine || stack == exptrace.NoStack {
+			return
+		}
+
+		sample := &profile.Sample{
+			Value: []int64{1},
+			Label: map[string][]string{pprofsv.TraceEventLabel: {ev.Kind().String()}},
+			NumLabel: map[string][]int64{
+				pprofsv.GoroutineIDLabel: {int64(goroutine)},
+				pprofsv.TraceTimeLabel:   {int64(ev.Time())},
+			},
+		}
+		for frame := range stack.Frames() {
+			sample.Location = append(sample.Location, location(frame))
+		}
+		p.Sample = append(p.Sample, sample)
+	}
+
+	for {
+		ev, err := reader.ReadEvent()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		add(&ev, ev.Goroutine(), ev.Stack())
+		if ev.Kind() == exptrace.EventStateTransition {
+			// the stack of the goroutine transitioning, e.g. the start
+			// of a goroutine being created, if not the one above
+			if st := ev.StateTransition(); st.Resource.Kind == exptrace.ResourceGoroutine && st.Stack != ev.Stack() {
+				add(&ev, st.Resource.Goroutine(), st.Stack)
+			}
+		}
+	}
+	if len(p.Sample) == 0 {
+		return nil, pprofsv.ErrNoStacks
+	}
+	return p, nil
+}
@@ -0,0 +1,105 @@
+package trace_test
+
+import (
+	"bytes"
+	"runtime/trace"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gaukas/pprofsv"
+	pprofsvtrace "github.com/gaukas/pprofsv/trace"
+)
+
+// traceConn is a connection whose transitions block, so that they are on
+// the stack of a trace event.
+type traceConn struct{}
+
+//go:noinline
+func (c *traceConn) handshake() { time.Sleep(time.Millisecond) }
+
+//go:noinline
+func (c *traceConn) write() { time.Sleep(time.Millisecond) }
+
+//go:noinline
+func (c *traceConn) close() { time.Sleep(time.Millisecond) }
+
+//go:noinline
+func (c *traceConn) serve(writes int, done chan<- struct{}) {
+	defer close(done)
+	c.handshake()
+	for i := 0; i < writes; i++ {
+		c.write()
+	}
+	c.close()
+}
+
+//go:noinline
+func (c *traceConn) serveBroken(done chan<- struct{}) {
+	defer close(done)
+	c.write()
+	c.close()
+	c.write()
+}
+
+func TestParse(t *testing.T) {
+	var buf bytes.Buffer
+	if err := trace.Start(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var c traceConn
+	done1, done2 := make(chan struct{}), make(chan struct{})
+	go c.serve(2, done1)
+	go c.serveBroken(done2)
+	<-done1
+	<-done2
+	trace.Stop()
+
+	pprof, err := pprofsvtrace.Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sample := range pprof.Sample {
+		if len(sample.NumLabel[pprofsv.GoroutineIDLabel]) != 1 || len(sample.NumLabel[pprofsv.TraceTimeLabel]) != 1 || len(sample.Label[pprofsv.TraceEventLabel]) != 1 {
+			t.Fatalf("expected goroutine, time and event labels, got %v and %v", sample.Label, sample.NumLabel)
+		}
+	}
+
+	p := pprofsv.NewProfile(pprof)
+	verifier, err := p.Verifier(`trace_test\.\(\*traceConn\)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier.SetFunctionPrefix("github.com/gaukas/pprofsv/trace_test.(*traceConn).")
+
+	// reachability works as on a profile
+	if !verifier.Reachable("serve", "handshake") || !verifier.Next("serveBroken", "write") {
+		t.Error("serve -> handshake and serveBroken -> write should be reachable")
+	}
+	if verifier.Reachable("serveBroken", "handshake") {
+		t.Error("serveBroken -> handshake should not be reachable")
+	}
+
+	// ordering only works on a trace
+	if !verifier.Before("write", "close") || verifier.Before("handshake", "write") {
+		t.Error("both write before closing, but only serve after a handshake")
+	}
+	if !verifier.Eventually("handshake", "close") || verifier.Eventually("write", "close") {
+		t.Error("every handshake, but not every write, should be followed by a close")
+	}
+	if !verifier.NeverAfter("handshake", "write") || verifier.NeverAfter("write", "close") {
+		t.Error("only serveBroken writes after closing")
+	}
+
+	report := pprofsv.Evaluate(verifier, []pprofsv.Assertion{
+		{Kind: pprofsv.AssertNeverAfter, From: "write", To: "close"},
+	})
+	order := report.Results[0].Order
+	if report.Passed() || order == nil || len(order.Events) != 2 || !strings.HasSuffix(order.Events[0].Function, ".close") || !strings.HasSuffix(order.Events[1].Function, ".write") || order.Events[0].Time >= order.Events[1].Time {
+		t.Errorf("expected serveBroken to write after closing, got %v", report.Results[0])
+	}
+
+	if _, err := pprofsvtrace.Parse(strings.NewReader("not a trace")); err == nil {
+		t.Error("expected an error parsing an invalid trace")
+	}
+}
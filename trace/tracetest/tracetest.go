@@ -0,0 +1,45 @@
+// Package tracetest captures execution traces in Go tests, as pprofsvtest
+// captures profiles, so that the helpers of pprofsvtest can verify the
+// order of their transitions:
+//
+//	func TestHandshake(t *testing.T) {
+//		p := tracetest.Capture(t, func() {
+//			c.serve()
+//		})
+//		v := pprofsvtest.Verifier(t, p, `\(\*conn\)`, "example.com/server.(*conn).")
+//		pprofsvtest.Assert(t, v, pprofsv.Assertion{Kind: pprofsv.AssertBefore, From: "handshake", To: "write"})
+//	}
+package tracetest
+
+import (
+	"bytes"
+	"runtime/trace"
+	"testing"
+
+	"github.com/gaukas/pprofsv"
+	pprofsvtrace "github.com/gaukas/pprofsv/trace"
+)
+
+// Capture runs f while recording an execution trace with runtime/trace.
+// Only the events with a stack, e.g. a goroutine blocking, are recorded,
+// so the functions to order must be on the stack when their goroutine
+// blocks or is created. It cannot be used while another trace is
+// recorded, e.g. with go test -trace.
+func Capture(tb testing.TB, f func()) *pprofsv.Profile {
+	tb.Helper()
+
+	var buf bytes.Buffer
+	if err := trace.Start(&buf); err != nil {
+		tb.Fatalf("tracetest: %v", err)
+	}
+	func() {
+		defer trace.Stop()
+		f()
+	}()
+
+	pprof, err := pprofsvtrace.Parse(&buf)
+	if err != nil {
+		tb.Fatalf("tracetest: %v", err)
+	}
+	return pprofsv.NewProfile(pprof)
+}
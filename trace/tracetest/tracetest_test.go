@@ -0,0 +1,40 @@
+package tracetest_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gaukas/pprofsv"
+	"github.com/gaukas/pprofsv/pprofsvtest"
+	"github.com/gaukas/pprofsv/trace/tracetest"
+)
+
+//go:noinline
+func handshake() { time.Sleep(time.Millisecond) }
+
+//go:noinline
+func write() { time.Sleep(time.Millisecond) }
+
+func TestCapture(t *testing.T) {
+	p := tracetest.Capture(t, func() {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			handshake()
+			write()
+		}()
+		<-done
+	})
+
+	v := pprofsvtest.Verifier(t, p, `tracetest_test\.(handshake|write)$`, "github.com/gaukas/pprofsv/trace/tracetest_test.")
+	pprofsvtest.Assert(t, v,
+		pprofsv.Assertion{Kind: pprofsv.AssertBefore, From: "handshake", To: "write"},
+		pprofsv.Assertion{Kind: pprofsv.AssertNeverAfter, From: "handshake", To: "write"},
+	)
+
+	report := pprofsv.Evaluate(v, []pprofsv.Assertion{{Kind: pprofsv.AssertBefore, From: "write", To: "handshake"}})
+	if failures := report.Failures(); len(failures) != 1 || !strings.HasPrefix(failures[0].String(), "FAIL before(write, handshake): counterexample goroutine ") {
+		t.Errorf("expected a counterexample, got %v", failures)
+	}
+}
@@ -1,5 +1,10 @@
 package pprofsv
 
+import (
+	"cmp"
+	"slices"
+)
+
 func contains(s []int, e int) bool {
 	if len(s) == 0 {
 		return false
@@ -12,3 +17,13 @@ func contains(s []int, e int) bool {
 	}
 	return false
 }
+
+// sortedKeys returns the keys of m in increasing order.
+func sortedKeys[K cmp.Ordered, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -1,8 +1,13 @@
 package pprofsv
 
 import (
+	"encoding/binary"
+	"errors"
 	"log"
-	"regexp"
+	"runtime"
+	"slices"
+	"sort"
+	"sync"
 )
 
 type Verifier struct {
@@ -15,17 +20,44 @@ type Verifier struct {
 	// Note: it does not use the pseudoID.
 	callStacks [][]uint64
 
+	// inlined[i][k] is true if the edge from callStacks[i][k+1] to
+	// callStacks[i][k] is an inline expansion. It may be nil, in which
+	// case all edges are considered real calls.
+	inlined [][]bool
+
+	// samples[i] is the index of the sample in masterProfile.callStacks
+	// (or in the base call stacks the Verifier was built from) that
+	// callStacks[i] was reduced from.
+	samples []int
+
+	// weighted is true if samples index the samples of masterProfile, so
+	// that their values are known.
+	weighted bool
+
+	// nodeValues[p][t] is the accumulated value of sample type t over the
+	// samples containing the function with pseudoID p. It is nil if the
+	// Verifier is not weighted.
+	nodeValues [][]int64
+
 	// path describes the reachability between functions.
 	//
 	// It uses pseudoID to represent functions in order to save memory.
 	path *Path
 
+	// timeline describes the order in which functions were observed on
+	// every goroutine. It is nil unless the samples have timestamps, see
+	// TraceTimeLabel.
+	timeline *Timeline
+
 	// functionIdPseudoMap is a map from real function ID to pseudoID.
 	// It is used to convert real function ID to pseudoID, so that
 	// the path can be built with a minimal memory footprint while
 	// including only the functions that are interesting.
 	functionIdPseudoMap map[uint64]uint64
 
+	// pseudoFunctionIdMap is the reverse of functionIdPseudoMap.
+	pseudoFunctionIdMap map[uint64]uint64
+
 	// masterProfile links back to the root profile where all functions
 	// are included.
 	masterProfile *Profile
@@ -33,6 +65,35 @@ type Verifier struct {
 	// prefix of the function name helps to reduce the length of
 	// input per each verification request.
 	functionPrefix string
+
+	// strict makes lookup failures panic instead of being logged.
+	strict bool
+}
+
+// VerifierOption configures how NewVerifier builds a Verifier.
+type VerifierOption func(*verifierConfig)
+
+type verifierConfig struct {
+	filters     []Filter
+	granularity Granularity
+}
+
+// WithLabelSelector keeps only the samples whose pprof labels match the
+// selector. It requires the Verifier to be built from the samples of the
+// profile rather than from base call stacks. See also Profile.WithLabels.
+func WithLabelSelector(selector LabelSelector) VerifierOption {
+	return WithFilter(Labels(selector))
+}
+
+// WithFilter keeps only the functions the filter selects, in addition to
+// the name pattern. Filters on labels, such as Labels, require the
+// Verifier to be built from the samples of the profile rather than from
+// base call stacks. The option may be given more than once, in which case
+// the functions must pass every filter.
+func WithFilter(filter Filter) VerifierOption {
+	return func(c *verifierConfig) {
+		c.filters = append(c.filters, filter)
+	}
 }
 
 // NewVerifier returns a new Verifier for functions matching a
@@ -40,138 +101,692 @@ type Verifier struct {
 // include only functions that match the name pattern.
 //
 // If baseCallStacks is nil, then the Verifier will build based on
-// masterProfile.callStacks.
+// masterProfile.callStacks. Otherwise, edges in baseCallStacks are all
+// considered real calls, since they carry no inlining information.
 //
 // If namePattern is empty, then the Verifier will use all functions
 // in masterProfile. This may result in a very slow verification or
 // even a memory overflow.
-func NewVerifier(masterProfile *Profile, baseCallStacks [][]uint64, namePattern string) (*Verifier, error) {
-	// filter call stacks
+//
+// Options such as WithFilter and WithLabelSelector further restrict the
+// functions and the samples the Verifier is built from.
+func NewVerifier(masterProfile *Profile, baseCallStacks [][]uint64, namePattern string, opts ...VerifierOption) (*Verifier, error) {
+	callStacks, inlined := masterProfile.callStacks, masterProfile.inlined
+	if baseCallStacks != nil {
+		callStacks, inlined = baseCallStacks, nil
+	}
+	samples := make([]int, len(callStacks))
+	for i := range samples {
+		samples[i] = i
+	}
+
+	return newFilteredVerifier(masterProfile, callStacks, inlined, samples, baseCallStacks == nil,
+		sortedKeys(masterProfile.functionIdMap), namePattern, opts)
+}
+
+// newFilteredVerifier returns a Verifier over the given call stacks,
+// keeping only the candidate functions that match the name pattern and
+// pass the filters of the options, collapsed to the granularity of the
+// options, or nil if none of them appears in any call stack. It is shared
+// by NewVerifier and SubVerifier.
+func newFilteredVerifier(masterProfile *Profile, callStacks [][]uint64, inlined [][]bool, samples []int, weighted bool, candidates []uint64, namePattern string, opts []VerifierOption) (*Verifier, error) {
+	var config verifierConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+	granularity, err := ParseGranularity(string(config.granularity))
+	if err != nil {
+		return nil, err
+	}
+	filters := config.filters
+	if namePattern != "" {
+		filters = append([]Filter{Match(namePattern)}, filters...)
+	}
+
+	var filter Filter
+	switch len(filters) {
+	case 0:
+	case 1:
+		filter = filters[0]
+	default:
+		filter = And(filters...)
+	}
+
+	var interestingFunctionIds []uint64
 	var finalCallStacks [][]uint64
-	var originalCallStacks [][]uint64
-	if baseCallStacks == nil {
-		originalCallStacks = masterProfile.callStacks
+	var finalInlined [][]bool
+	var finalSamples []int
+	if filter == nil {
+		interestingFunctionIds = candidates
+		finalCallStacks, finalInlined, finalSamples = callStacks, inlined, samples
+	} else if compiled, err := compileFilter(masterProfile, filter, candidates); err != nil {
+		return nil, err
+	} else if compiled.sampleIndependent() {
+		selected := compiled.functions(nil, nil)
+		selected.forEach(func(id int) {
+			interestingFunctionIds = append(interestingFunctionIds, uint64(id))
+		})
+		finalCallStacks, finalInlined, finalSamples = reduceCallStacks(callStacks, inlined, samples, func(int) bitset {
+			return selected
+		})
 	} else {
-		originalCallStacks = baseCallStacks
-	}
+		if !weighted {
+			return nil, errors.New("label selector requires the call stacks of the profile")
+		}
 
-	var interestingFunctionIds []uint64 // function IDs that match the name pattern
-	if namePattern == "" {
-		finalCallStacks = originalCallStacks
-		interestingFunctionIds = make([]uint64, 0, len(masterProfile.functionNameMap))
-		for f := range masterProfile.functionIdMap {
-			interestingFunctionIds = append(interestingFunctionIds, f)
+		// samples matching the same label selectors share their
+		// selection, so there are few distinct ones
+		selections := make([]bitset, len(callStacks))
+		encountered := newBitset(len(masterProfile.functionIdMap) + 1)
+		for i := range callStacks {
+			selections[i] = compiled.functions(masterProfile.Labels(samples[i]))
+			encountered.or(selections[i])
 		}
-	} else {
-		finalCallStacks = make([][]uint64, 0, len(originalCallStacks))
-		for name, function := range masterProfile.functionNameMap {
-			// regex match
-			if match, err := regexp.Match(namePattern, []byte(name)); match {
-				// fmt.Printf("Matched: %s\n", name)
-				interestingFunctionIds = append(interestingFunctionIds, function)
-			} else if err != nil {
-				return nil, err
-			}
+		encountered.forEach(func(id int) {
+			interestingFunctionIds = append(interestingFunctionIds, uint64(id))
+		})
+		finalCallStacks, finalInlined, finalSamples = reduceCallStacks(callStacks, inlined, samples, func(i int) bitset {
+			return selections[i]
+		})
+	}
+
+	if len(finalCallStacks) == 0 {
+		return nil, nil
+	}
+
+	if granularity != GranularityFunction {
+		var groups []uint64
+		masterProfile, groups = masterProfile.groupFunctions(granularity, interestingFunctionIds)
+		finalCallStacks, finalInlined = groupCallStacks(finalCallStacks, finalInlined, groups)
+		interestingFunctionIds = sortedKeys(masterProfile.functionIdMap)
+	}
+
+	return newReducedVerifier(masterProfile, finalCallStacks, finalInlined, finalSamples, interestingFunctionIds, weighted), nil
+}
+
+// functionSet returns the set of the given function IDs.
+func functionSet(functionIds []uint64) bitset {
+	var maxId uint64
+	for _, id := range functionIds {
+		maxId = max(maxId, id)
+	}
+	set := newBitset(int(maxId) + 1)
+	for _, id := range functionIds {
+		set.set(int(id))
+	}
+	return set
+}
+
+// minStacksPerWorker is the number of call stacks below which reducing
+// them in another goroutine costs more than it saves.
+const minStacksPerWorker = 4096
+
+// reduceCallStacks keeps only the interesting functions in every call
+// stack, dropping the call stacks left empty. interesting(i) returns the
+// set of the function IDs interesting in the i-th call stack; it is called
+// concurrently. An edge between two kept functions is an inline expansion
+// only if every edge between them was.
+//
+// Call stacks are reduced in parallel, in contiguous chunks so that the
+// order of the samples is kept. Identical reduced call stacks (and their
+// inlined flags) share the same backing arrays, which saves memory since
+// most samples share their call stack with many others once reduced, and
+// lets buildPath walk each of them once.
+func reduceCallStacks(originalCallStacks [][]uint64, originalInlined [][]bool, originalSamples []int, interesting func(i int) bitset) ([][]uint64, [][]bool, []int) {
+	workers := min(runtime.GOMAXPROCS(0), len(originalCallStacks)/minStacksPerWorker)
+	if workers <= 1 {
+		return reduceCallStackChunk(originalCallStacks, originalInlined, originalSamples, interesting)
+	}
+
+	type chunk struct {
+		callStacks [][]uint64
+		inlined    [][]bool
+		samples    []int
+	}
+	chunks := make([]chunk, workers)
+	size := (len(originalCallStacks) + workers - 1) / workers
+	var wg sync.WaitGroup
+	for w := range chunks {
+		start, end := w*size, min((w+1)*size, len(originalCallStacks))
+		var inlined [][]bool
+		if originalInlined != nil {
+			inlined = originalInlined[start:end]
 		}
+		wg.Add(1)
+		go func(c *chunk) {
+			defer wg.Done()
+			c.callStacks, c.inlined, c.samples = reduceCallStackChunk(originalCallStacks[start:end], inlined, originalSamples[start:end], func(i int) bitset {
+				return interesting(start + i)
+			})
+		}(&chunks[w])
+	}
+	wg.Wait()
+
+	finalCallStacks := make([][]uint64, 0, len(originalCallStacks))
+	finalInlined := make([][]bool, 0, len(originalCallStacks))
+	finalSamples := make([]int, 0, len(originalCallStacks))
+	for _, c := range chunks {
+		finalCallStacks = append(finalCallStacks, c.callStacks...)
+		finalInlined = append(finalInlined, c.inlined...)
+		finalSamples = append(finalSamples, c.samples...)
+	}
+	return finalCallStacks, finalInlined, finalSamples
+}
 
-		for _, callStack := range originalCallStacks {
-			reducedCallStack := make([]uint64, 0, len(callStack))
-		LOOP_FUNC_IN_CALLSTACK:
-			for _, function := range callStack {
-				for _, interestingFunction := range interestingFunctionIds {
-					if function == interestingFunction {
-						// fmt.Printf("Function %d is interesting\n", function)
-						reducedCallStack = append(reducedCallStack, function)
-						continue LOOP_FUNC_IN_CALLSTACK
-					}
+// reduceCallStackChunk is the sequential part of reduceCallStacks.
+func reduceCallStackChunk(originalCallStacks [][]uint64, originalInlined [][]bool, originalSamples []int, interesting func(i int) bitset) ([][]uint64, [][]bool, []int) {
+	finalCallStacks := make([][]uint64, 0, len(originalCallStacks))
+	finalInlined := make([][]bool, 0, len(originalCallStacks))
+	finalSamples := make([]int, 0, len(originalCallStacks))
+
+	// reduced call stacks are built in scratch slices, and only copied if
+	// they were not seen before
+	var interner stackInterner
+	var reducedCallStack []uint64
+	var reducedInlined []bool
+	for i, callStack := range originalCallStacks {
+		var inlined []bool
+		if originalInlined != nil {
+			inlined = originalInlined[i]
+		}
+		kept := interesting(i)
+
+		reducedCallStack, reducedInlined = reducedCallStack[:0], reducedInlined[:0]
+		// pendingInline is true if every edge since the last retained
+		// frame is an inline expansion.
+		pendingInline := true
+		for k, function := range callStack {
+			frameInlined := inlined != nil && inlined[k]
+			if function < uint64(len(kept))*64 && kept.has(int(function)) {
+				if len(reducedInlined) > 0 {
+					reducedInlined[len(reducedInlined)-1] = pendingInline
 				}
+				reducedCallStack = append(reducedCallStack, function)
+				reducedInlined = append(reducedInlined, false)
+				pendingInline = frameInlined
+				continue
 			}
-			if len(reducedCallStack) > 0 {
-				finalCallStacks = append(finalCallStacks, reducedCallStack)
-			}
+			pendingInline = pendingInline && frameInlined
 		}
+		if len(reducedCallStack) == 0 {
+			continue
+		}
+
+		stack, stackInlined := interner.intern(reducedCallStack, reducedInlined)
+		finalCallStacks = append(finalCallStacks, stack)
+		finalInlined = append(finalInlined, stackInlined)
+		finalSamples = append(finalSamples, originalSamples[i])
 	}
 
-	if len(finalCallStacks) == 0 {
-		return nil, nil
+	return finalCallStacks, finalInlined, finalSamples
+}
+
+// stackInterner interns call stacks and their inlined flags, so that
+// identical ones share the same backing arrays.
+type stackInterner struct {
+	stacks map[string]internedStack
+	key    []byte
+}
+
+type internedStack struct {
+	callStack []uint64
+	inlined   []bool
+}
+
+// intern returns the interned copy of the call stack and its inlined
+// flags, which may be scratch slices.
+func (in *stackInterner) intern(callStack []uint64, inlined []bool) ([]uint64, []bool) {
+	if in.stacks == nil {
+		in.stacks = make(map[string]internedStack)
 	}
 
+	in.key = in.key[:0]
+	for k, function := range callStack {
+		in.key = binary.AppendUvarint(in.key, function)
+		if inlined[k] {
+			in.key = append(in.key, 1)
+		} else {
+			in.key = append(in.key, 0)
+		}
+	}
+	stack, ok := in.stacks[string(in.key)]
+	if !ok {
+		stack = internedStack{
+			callStack: slices.Clone(callStack),
+			inlined:   slices.Clone(inlined),
+		}
+		in.stacks[string(in.key)] = stack
+	}
+	return stack.callStack, stack.inlined
+}
+
+// newReducedVerifier returns a Verifier over the given reduced call
+// stacks, assigning a pseudoID to each interesting function.
+func newReducedVerifier(masterProfile *Profile, callStacks [][]uint64, inlined [][]bool, samples []int, interestingFunctionIds []uint64, weighted bool) *Verifier {
 	// build pseudoID <-> inProfileID relationship
-	// pseudoFunctionIdMap := make(map[uint64]uint64, len(interestingFunctionIds)) // pseudoID -> realID
+	pseudoFunctionIdMap := make(map[uint64]uint64, len(interestingFunctionIds)) // pseudoID -> realID
 	functionIdPseudoMap := make(map[uint64]uint64, len(interestingFunctionIds)) // realID -> pseudoID
 	for i, function := range interestingFunctionIds {
-		// pseudoFunctionIdMap[uint64(i)] = function
+		pseudoFunctionIdMap[uint64(i)] = function
 		functionIdPseudoMap[function] = uint64(i)
 	}
 
-	// build path
-	path := NewPath(len(interestingFunctionIds))
-	for _, callStack := range finalCallStacks {
-		for i := 0; i < len(callStack)-1; i++ {
-			// convert realID to pseudoID
-			to := functionIdPseudoMap[callStack[i]]
-			from := functionIdPseudoMap[callStack[i+1]]
-			path.Set(int(from), int(to))
-		}
-	}
+	v := &Verifier{
+		callStacks: callStacks,
+		inlined:    inlined,
+		samples:    samples,
+		weighted:   weighted,
 
-	return &Verifier{
-		callStacks: finalCallStacks,
-		path:       path,
-		// pseudoFunctionIdMap: pseudoFunctionIdMap,
 		functionIdPseudoMap: functionIdPseudoMap,
+		pseudoFunctionIdMap: pseudoFunctionIdMap,
 		masterProfile:       masterProfile,
-	}, nil
+	}
+	v.buildPath()
+	v.buildTimeline()
+	return v
+}
+
+// buildPath builds the path from the reduced call stacks. If the Verifier
+// is weighted, it also accumulates the values of each sample on every
+// function and edge in its call stack, counting each of them once per
+// sample even if it appears more than once, e.g. through recursion.
+//
+// Call stacks sharing their backing arrays, as interned by
+// reduceCallStacks, are walked once with the values of all their samples.
+func (v *Verifier) buildPath() {
+	n := len(v.pseudoFunctionIdMap)
+	v.path = NewPath(n)
+	if v.weighted {
+		v.nodeValues = make([][]int64, n)
+		for i := range v.nodeValues {
+			v.nodeValues[i] = make([]int64, len(v.masterProfile.sampleTypes))
+		}
+	}
+
+	type stackKey struct {
+		callStack *uint64
+		inlined   *bool
+	}
+	type uniqueStack struct {
+		s      int     // the first sample with the call stack
+		values []int64 // the values of all the samples with the call stack
+	}
+	var uniqueStacks []uniqueStack
+	uniqueIndex := make(map[stackKey]int)
+	for s, callStack := range v.callStacks {
+		if len(callStack) == 0 {
+			continue
+		}
+		key := stackKey{callStack: &callStack[0]}
+		if v.inlined != nil {
+			key.inlined = &v.inlined[s][0]
+		}
+		u, ok := uniqueIndex[key]
+		if !ok {
+			u = len(uniqueStacks)
+			uniqueIndex[key] = u
+			uniqueStacks = append(uniqueStacks, uniqueStack{s: s, values: make([]int64, len(v.masterProfile.sampleTypes))})
+		}
+		if v.weighted {
+			for t, value := range v.masterProfile.values[v.samples[s]] {
+				uniqueStacks[u].values[t] += value
+			}
+		}
+	}
+
+	seenNodes := newBitset(n)
+	seenEdges := make(map[[2]int]bool)
+	for _, unique := range uniqueStacks {
+		s, callStack, values := unique.s, v.callStacks[unique.s], unique.values
+		if v.weighted {
+			clear(seenNodes)
+			clear(seenEdges)
+		}
+
+		for i := range callStack {
+			// convert realID to pseudoID
+			to := int(v.functionIdPseudoMap[callStack[i]])
+			if v.weighted && !seenNodes.has(to) {
+				seenNodes.set(to)
+				for t, value := range values {
+					v.nodeValues[to][t] += value
+				}
+			}
+			if i == len(callStack)-1 {
+				break
+			}
+
+			from := int(v.functionIdPseudoMap[callStack[i+1]])
+			kind := EdgeCall
+			if v.inlined != nil && v.inlined[s][i] {
+				kind = EdgeInline
+			}
+			v.path.SetKind(from, to, kind)
+			if v.weighted && !seenEdges[[2]int{from, to}] {
+				seenEdges[[2]int{from, to}] = true
+				v.path.AddValues(from, to, values)
+			}
+		}
+	}
 }
 
 // Reachable checks if there's a path from function `from` to function `to`.
+//
+// If a function cannot be found, Reachable logs the error and returns
+// false, or panics in strict mode. Use CheckReachable to tell the two
+// outcomes apart.
 func (v *Verifier) Reachable(from, to string, skipped ...string) bool {
-	fromName := v.functionPrefix + from
-	toName := v.functionPrefix + to
+	reachable, err := v.CheckReachable(from, to, skipped...)
+	if err != nil {
+		v.lookupFailed(err)
+	}
+	return reachable
+}
 
-	fromId, ok := v.masterProfile.functionNameMap[fromName]
-	if !ok {
-		log.Printf("function %s not found", fromName)
-		return false
+// CheckReachable is like Reachable, but returns an error if any of the
+// functions cannot be resolved. A skipped function filtered out by the
+// Verifier is ignored, as no path could pass through it anyway.
+func (v *Verifier) CheckReachable(from, to string, skipped ...string) (bool, error) {
+	fromId, toId, skippedIds, err := v.lookupPath(from, to, skipped)
+	if err != nil {
+		return false, err
 	}
 
-	toId, ok := v.masterProfile.functionNameMap[toName]
-	if !ok {
-		log.Printf("function %s not found", toName)
-		return false
+	return v.path.HasPath(fromId, toId, skippedIds...), nil
+}
+
+// ReachablePath is like Reachable, but returns a shortest chain of
+// functions from function `from` to function `to` as a Witness, or nil if
+// `to` is not reachable.
+func (v *Verifier) ReachablePath(from, to string, skipped ...string) *Witness {
+	witness, err := v.CheckReachablePath(from, to, skipped...)
+	if err != nil {
+		v.lookupFailed(err)
 	}
+	return witness
+}
 
-	var skippedIds []int
-	for _, skippedName := range skipped {
-		skippedId, ok := v.masterProfile.functionNameMap[v.functionPrefix+skippedName]
-		if !ok {
-			log.Printf("function %s not found", skippedName)
+// CheckReachablePath is like ReachablePath, but returns an error if any of
+// the functions cannot be resolved.
+func (v *Verifier) CheckReachablePath(from, to string, skipped ...string) (*Witness, error) {
+	fromId, toId, skippedIds, err := v.lookupPath(from, to, skipped)
+	if err != nil {
+		return nil, err
+	}
+
+	pseudoPath := v.path.FindPath(fromId, toId, skippedIds...)
+	if pseudoPath == nil {
+		return nil, nil
+	}
+
+	return v.witness(pseudoPath), nil
+}
+
+// witness converts a path of pseudoIDs into a Witness.
+func (v *Verifier) witness(pseudoPath []int) *Witness {
+	w := &Witness{
+		Functions: make([]string, 0, len(pseudoPath)),
+		Edges:     make([]WitnessEdge, 0, len(pseudoPath)-1),
+	}
+	for i, pseudoId := range pseudoPath {
+		w.Functions = append(w.Functions, v.masterProfile.functionIdMap[v.pseudoFunctionIdMap[uint64(pseudoId)]])
+		if i == 0 {
 			continue
 		}
-		skippedIds = append(skippedIds, int(v.functionIdPseudoMap[skippedId]))
+		w.Edges = append(w.Edges, WitnessEdge{
+			From:    w.Functions[i-1],
+			To:      w.Functions[i],
+			Kind:    v.path.DirectPathKind(pseudoPath[i-1], pseudoId),
+			Samples: v.edgeSamples(v.pseudoFunctionIdMap[uint64(pseudoPath[i-1])], v.pseudoFunctionIdMap[uint64(pseudoId)]),
+		})
+	}
+	return w
+}
+
+// nextWitness returns the direct path from function `from` to function `to`
+// as a Witness, or nil if there is none.
+func (v *Verifier) nextWitness(from, to string) (*Witness, error) {
+	fromId, err := v.lookup(from)
+	if err != nil {
+		return nil, err
+	}
+
+	toId, err := v.lookup(to)
+	if err != nil {
+		return nil, err
+	}
+
+	if !v.path.HasDirectPath(fromId, toId) {
+		return nil, nil
+	}
+	return v.witness([]int{fromId, toId}), nil
+}
+
+// edgeSamples returns the indices of the samples containing a direct path
+// between the two real function IDs.
+func (v *Verifier) edgeSamples(from, to uint64) []int {
+	var samples []int
+	for i, callStack := range v.callStacks {
+		for k := 0; k < len(callStack)-1; k++ {
+			if callStack[k+1] == from && callStack[k] == to {
+				samples = append(samples, v.samples[i])
+				break
+			}
+		}
 	}
+	return samples
+}
 
-	return v.path.HasPath(int(v.functionIdPseudoMap[fromId]), int(v.functionIdPseudoMap[toId]), skippedIds...)
+// edgeSampleCounts returns the number of samples containing each direct
+// path, keyed by the real function IDs of its ends, in a single pass over
+// the call stacks.
+func (v *Verifier) edgeSampleCounts() map[[2]uint64]int {
+	counts := make(map[[2]uint64]int)
+	seen := make(map[[2]uint64]bool)
+	for _, callStack := range v.callStacks {
+		clear(seen)
+		for k := 0; k < len(callStack)-1; k++ {
+			edge := [2]uint64{callStack[k+1], callStack[k]}
+			if !seen[edge] {
+				seen[edge] = true
+				counts[edge]++
+			}
+		}
+	}
+	return counts
 }
 
 // Next checks if there's a direct path from function `from` to function `to`.
+//
+// If a function cannot be found, Next logs the error and returns false, or
+// panics in strict mode. Use CheckNext to tell the two outcomes apart.
 func (v *Verifier) Next(from, to string) bool {
-	fromName := v.functionPrefix + from
-	toName := v.functionPrefix + to
+	return v.NextKind(from, to) != 0
+}
 
-	fromId, ok := v.masterProfile.functionNameMap[fromName]
-	if !ok {
-		log.Printf("function %s not found", fromName)
-		return false
+// CheckNext is like Next, but returns an error if either function cannot
+// be resolved.
+func (v *Verifier) CheckNext(from, to string) (bool, error) {
+	kind, err := v.CheckNextKind(from, to)
+	return kind != 0, err
+}
+
+// NextKind reports how function `to` directly follows function `from`:
+// EdgeCall if `from` calls `to`, EdgeInline if `to` was inlined into
+// `from`, both if both were observed, or 0 if there is no direct path.
+func (v *Verifier) NextKind(from, to string) EdgeKind {
+	kind, err := v.CheckNextKind(from, to)
+	if err != nil {
+		v.lookupFailed(err)
 	}
+	return kind
+}
 
-	toId, ok := v.masterProfile.functionNameMap[toName]
+// CheckNextKind is like NextKind, but returns an error if either function
+// cannot be resolved.
+func (v *Verifier) CheckNextKind(from, to string) (EdgeKind, error) {
+	fromId, err := v.lookup(from)
+	if err != nil {
+		return 0, err
+	}
+
+	toId, err := v.lookup(to)
+	if err != nil {
+		return 0, err
+	}
+
+	return v.path.DirectPathKind(fromId, toId), nil
+}
+
+// SampleTypes returns the names of the sample types that functions and
+// edges can be weighed with, or nil if the Verifier has no sample values.
+func (v *Verifier) SampleTypes() []string {
+	if !v.weighted {
+		return nil
+	}
+	return v.masterProfile.SampleTypes()
+}
+
+// sampleTypeIndex resolves the named sample type, or the default one if
+// the name is empty.
+func (v *Verifier) sampleTypeIndex(sampleType string) (int, error) {
+	if !v.weighted {
+		return 0, ErrNoSampleValues
+	}
+	return v.masterProfile.sampleTypeIndex(sampleType)
+}
+
+// Weight returns the total value, of the named sample type, of the samples
+// in which the function appears, i.e., its inclusive (cumulative) value.
+// An empty sample type selects the profile's default sample type.
+func (v *Verifier) Weight(function, sampleType string) (int64, error) {
+	t, err := v.sampleTypeIndex(sampleType)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := v.lookup(function)
+	if err != nil {
+		return 0, err
+	}
+
+	return v.nodeValues[id][t], nil
+}
+
+// EdgeWeight returns the total value, of the named sample type, of the
+// samples in which function `from` directly calls (or inlines) function
+// `to`. An empty sample type selects the profile's default sample type.
+func (v *Verifier) EdgeWeight(from, to, sampleType string) (int64, error) {
+	t, err := v.sampleTypeIndex(sampleType)
+	if err != nil {
+		return 0, err
+	}
+
+	fromId, err := v.lookup(from)
+	if err != nil {
+		return 0, err
+	}
+
+	toId, err := v.lookup(to)
+	if err != nil {
+		return 0, err
+	}
+
+	return v.path.DirectPathValue(fromId, toId, t), nil
+}
+
+// Share returns the fraction, between 0 and 1, of the weight of function
+// `from` that goes through its direct path to function `to`, e.g. 0.5 if
+// `from` spends half of its CPU time in calls to `to`. It returns 0 if
+// `from` has no weight.
+func (v *Verifier) Share(from, to, sampleType string) (float64, error) {
+	edge, err := v.EdgeWeight(from, to, sampleType)
+	if err != nil {
+		return 0, err
+	}
+
+	total, err := v.Weight(from, sampleType)
+	if err != nil || total == 0 {
+		return 0, err
+	}
+
+	return float64(edge) / float64(total), nil
+}
+
+// SetStrict enables or disables the strict mode. In strict mode, the
+// methods returning only a bool panic with a *FunctionError instead of
+// logging it when a function cannot be resolved.
+func (v *Verifier) SetStrict(strict bool) {
+	v.strict = strict
+}
+
+func (v *Verifier) lookupFailed(err error) {
+	if v.strict {
+		panic(err)
+	}
+	log.Print(err)
+}
+
+// lookup resolves a function name (without the function prefix), or a
+// fully qualified key (see FunctionKey), to its pseudoID in the Verifier.
+func (v *Verifier) lookup(name string) (int, error) {
+	return v.lookupFullName(v.functionPrefix + name)
+}
+
+// lookupFullName is like lookup, but ignores the function prefix.
+func (v *Verifier) lookupFullName(fullName string) (int, error) {
+	id, err := v.masterProfile.lookup(fullName)
+	var functionErr *FunctionError
+	if errors.As(err, &functionErr) && errors.Is(err, ErrAmbiguousFunction) {
+		// a name shared by several functions is still unambiguous if
+		// only one of them survived the Verifier's filter.
+		var candidates []uint64
+		for _, id := range v.masterProfile.duplicateNames[fullName] {
+			if _, ok := v.functionIdPseudoMap[id]; ok {
+				candidates = append(candidates, id)
+			}
+		}
+		switch len(candidates) {
+		case 0:
+			return 0, &FunctionError{Name: fullName, Err: ErrFunctionFiltered}
+		case 1:
+			return int(v.functionIdPseudoMap[candidates[0]]), nil
+		default:
+			return 0, v.masterProfile.ambiguousError(fullName, candidates)
+		}
+	} else if err != nil {
+		return 0, err
+	}
+
+	pseudoId, ok := v.functionIdPseudoMap[id]
 	if !ok {
-		log.Printf("function %s not found", toName)
-		return false
+		return 0, &FunctionError{Name: fullName, Err: ErrFunctionFiltered}
 	}
 
-	return v.path.HasDirectPath(int(v.functionIdPseudoMap[fromId]), int(v.functionIdPseudoMap[toId]))
+	return int(pseudoId), nil
+}
+
+// lookupPath resolves the arguments of a path query.
+func (v *Verifier) lookupPath(from, to string, skipped []string) (fromId, toId int, skippedIds []int, err error) {
+	if fromId, err = v.lookup(from); err != nil {
+		return 0, 0, nil, err
+	}
+
+	if toId, err = v.lookup(to); err != nil {
+		return 0, 0, nil, err
+	}
+
+	for _, skippedName := range skipped {
+		skippedId, err := v.lookup(skippedName)
+		if errors.Is(err, ErrFunctionFiltered) {
+			continue
+		} else if err != nil {
+			return 0, 0, nil, err
+		}
+		skippedIds = append(skippedIds, skippedId)
+	}
+
+	return fromId, toId, skippedIds, nil
 }
 
 func (v *Verifier) Callstack() [][]uint64 {
@@ -190,87 +805,36 @@ func (v *Verifier) DumpCallstack() [][]string {
 	return result
 }
 
+// Functions returns the sorted full names of the functions the Verifier
+// was built with.
+func (v *Verifier) Functions() []string {
+	names := make([]string, 0, len(v.functionIdPseudoMap))
+	for id := range v.functionIdPseudoMap {
+		names = append(names, v.masterProfile.functionIdMap[id])
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (v *Verifier) SetFunctionPrefix(prefix string) {
 	v.functionPrefix = prefix
 }
 
+// FunctionPrefix returns the prefix prepended to every function name. See
+// SetFunctionPrefix.
+func (v *Verifier) FunctionPrefix() string {
+	return v.functionPrefix
+}
+
 // SubVerifier returns a new Verifier that is a subset of the current Verifier.
 //
-// If the name pattern is empty, then the new Verifier will be identical to
-// the current Verifier.
+// If the name pattern is empty and there is no option, then the new
+// Verifier will be identical to the current Verifier. Options such as
+// WithFilter further restrict it, as in NewVerifier.
 //
 // If the name pattern contradicts with the current Verifier (no match when
 // combined), then the new Verifier will be nil.
-func (v *Verifier) SubVerifier(namePattern string) (*Verifier, error) {
-	// filter call stacks
-	var finalCallStacks [][]uint64
-	var originalCallStacks [][]uint64 = v.callStacks
-
-	var interestingFunctionIds []uint64 // function IDs that match the name pattern
-	if namePattern == "" {
-		finalCallStacks = originalCallStacks
-		interestingFunctionIds = make([]uint64, 0, len(v.functionIdPseudoMap))
-		for f := range v.functionIdPseudoMap {
-			interestingFunctionIds = append(interestingFunctionIds, f)
-		}
-	} else {
-		finalCallStacks = make([][]uint64, 0, len(originalCallStacks))
-		for fid := range v.functionIdPseudoMap {
-			// regex match
-			if match, err := regexp.Match(namePattern, []byte(v.masterProfile.functionIdMap[fid])); match {
-				// fmt.Printf("Matched: %s\n", name)
-				interestingFunctionIds = append(interestingFunctionIds, fid)
-			} else if err != nil {
-				return nil, err
-			}
-		}
-
-		for _, callStack := range originalCallStacks {
-			reducedCallStack := make([]uint64, 0, len(callStack))
-		LOOP_FUNC_IN_CALLSTACK:
-			for _, function := range callStack {
-				for _, interestingFunction := range interestingFunctionIds {
-					if function == interestingFunction {
-						// fmt.Printf("Function %d is interesting\n", function)
-						reducedCallStack = append(reducedCallStack, function)
-						continue LOOP_FUNC_IN_CALLSTACK
-					}
-				}
-			}
-			if len(reducedCallStack) > 0 {
-				finalCallStacks = append(finalCallStacks, reducedCallStack)
-			}
-		}
-	}
-
-	if len(finalCallStacks) == 0 {
-		return nil, nil
-	}
-
-	// build pseudoID <-> inProfileID relationship
-	// pseudoFunctionIdMap := make(map[uint64]uint64, len(interestingFunctionIds)) // pseudoID -> realID
-	functionIdPseudoMap := make(map[uint64]uint64, len(interestingFunctionIds)) // realID -> pseudoID
-	for i, function := range interestingFunctionIds {
-		// pseudoFunctionIdMap[uint64(i)] = function
-		functionIdPseudoMap[function] = uint64(i)
-	}
-
-	// build path
-	path := NewPath(len(interestingFunctionIds))
-	for _, callStack := range finalCallStacks {
-		for i := 0; i < len(callStack)-1; i++ {
-			// convert realID to pseudoID
-			to := functionIdPseudoMap[callStack[i]]
-			from := functionIdPseudoMap[callStack[i+1]]
-			path.Set(int(from), int(to))
-		}
-	}
-
-	return &Verifier{
-		callStacks: finalCallStacks,
-		path:       path,
-		// pseudoFunctionIdMap: pseudoFunctionIdMap,
-		functionIdPseudoMap: functionIdPseudoMap,
-		masterProfile:       v.masterProfile,
-	}, nil
+func (v *Verifier) SubVerifier(namePattern string, opts ...VerifierOption) (*Verifier, error) {
+	return newFilteredVerifier(v.masterProfile, v.callStacks, v.inlined, v.samples, v.weighted,
+		sortedKeys(v.functionIdPseudoMap), namePattern, opts)
 }
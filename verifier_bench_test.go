@@ -0,0 +1,73 @@
+package pprofsv_test
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/gaukas/pprofsv"
+	"github.com/google/pprof/profile"
+)
+
+// The benchmarks only use the API of NewVerifier and SubVerifier, so that
+// this file can be copied into an older tree to measure a baseline. Run
+// them with several values of -cpu, e.g. with make bench, since call
+// stacks are only reduced in parallel with several Ps.
+
+// largeProfile returns a pprof profile of the given number of samples,
+// each with a call stack of the given depth drawn from the given number of
+// functions, named "pkgN.fM" so that patterns can select a subset of them.
+// Stacks are drawn from a fixed set of paths, as in real profiles where
+// many samples share a call stack.
+func largeProfile(functions, samples, depth int) *profile.Profile {
+	pprof := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+	}
+	for i := 0; i < functions; i++ {
+		function := &profile.Function{ID: uint64(i + 1), Name: fmt.Sprintf("pkg%d.f%d", i%10, i)}
+		pprof.Function = append(pprof.Function, function)
+		pprof.Location = append(pprof.Location, &profile.Location{ID: uint64(i + 1), Line: []profile.Line{{Function: function}}})
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	paths := make([][]*profile.Location, 1000)
+	for i := range paths {
+		paths[i] = make([]*profile.Location, depth)
+		for k := range paths[i] {
+			paths[i][k] = pprof.Location[rng.Intn(functions)]
+		}
+	}
+	for i := 0; i < samples; i++ {
+		pprof.Sample = append(pprof.Sample, &profile.Sample{
+			Location: paths[rng.Intn(len(paths))],
+			Value:    []int64{1},
+		})
+	}
+	return pprof
+}
+
+func BenchmarkNewVerifier(b *testing.B) {
+	p := pprofsv.NewProfile(largeProfile(2000, 20000, 32))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.Verifier(`^pkg[13]\.`); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSubVerifier(b *testing.B) {
+	p := pprofsv.NewProfile(largeProfile(2000, 20000, 32))
+	v, err := p.Verifier(`^pkg[13]\.`)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := v.SubVerifier(`^pkg1\.`); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package pprofsv_test
 
 import (
-	"os"
+	"errors"
+	"runtime"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/gaukas/pprofsv"
@@ -9,49 +12,41 @@ import (
 )
 
 func TestVerifierReachable(t *testing.T) {
-	file, err := os.Open("testdata/pprof.profile")
+	pprof := loadProfile(t, "testdata/pprof.profile")
+
+	profile := pprofsv.NewProfile(pprof)
+	if profile == nil {
+		t.Fatal("profile is nil")
+	}
+
+	verifier, err := profile.Verifier("dummy")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	pprof, err := profile.Parse(file)
+	vBranch, err := verifier.SubVerifier("")
 	if err != nil {
 		t.Fatal(err)
 	}
+	t.Run("Branch", func(t *testing.T) {
+		testVerifierBranchReachable(t, vBranch)
+	})
 
-	profile := pprofsv.NewProfile(pprof)
-	if profile == nil {
-		t.Fatal("profile is nil")
+	vDeep, err := verifier.SubVerifier("")
+	if err != nil {
+		t.Fatal(err)
 	}
+	t.Run("Deep", func(t *testing.T) {
+		testVerifierDeepReachable(t, vDeep)
+	})
 
-	verifier, err := profile.Verifier("dummy")
+	vLoop, err := verifier.SubVerifier("")
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	// vBranch, err := verifier.SubVerifier("")
-	// if err != nil {
-	// 	t.Fatal(err)
-	// }
-	// t.Run("Branch", func(t *testing.T) {
-	// 	testVerifierBranchReachable(t, vBranch)
-	// })
-
-	// vDeep, err := verifier.SubVerifier("")
-	// if err != nil {
-	// 	t.Fatal(err)
-	// }
-	// t.Run("Deep", func(t *testing.T) {
-	// 	testVerifierDeepReachable(t, vDeep)
-	// })
-
-	// vLoop, err := verifier.SubVerifier("")
-	// if err != nil {
-	// 	t.Fatal(err)
-	// }
-	// t.Run("Loop", func(t *testing.T) {
-	// 	testVerifierLoopReachable(t, vLoop)
-	// })
+	t.Run("Loop", func(t *testing.T) {
+		testVerifierLoopReachable(t, vLoop)
+	})
 
 	vMulti, err := verifier.SubVerifier("")
 	if err != nil {
@@ -61,13 +56,13 @@ func TestVerifierReachable(t *testing.T) {
 		testVerifierMultiReachable(t, vMulti)
 	})
 
-	// vRecursive, err := verifier.SubVerifier("")
-	// if err != nil {
-	// 	t.Fatal(err)
-	// }
-	// t.Run("Recursive", func(t *testing.T) {
-	// 	testVerifierRecursive(t, vRecursive)
-	// })
+	vRecursive, err := verifier.SubVerifier("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("Recursive", func(t *testing.T) {
+		testVerifierRecursive(t, vRecursive)
+	})
 }
 
 func testVerifierBranchReachable(t *testing.T, v *pprofsv.Verifier) {
@@ -273,3 +268,326 @@ func testVerifierRecursive(t *testing.T, v *pprofsv.Verifier) {
 		t.Errorf("recursiveFuncInnerA -> final should be next")
 	}
 }
+
+func TestVerifierNextKind(t *testing.T) {
+	pprof := loadProfile(t, "testdata/pprof.profile")
+
+	verifier, err := pprofsv.NewProfile(pprof).Verifier(`^runtime\.`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier.SetFunctionPrefix("runtime.")
+
+	// acquirem is always inlined into mallocgc
+	if kind := verifier.NextKind("mallocgc", "acquirem"); kind != pprofsv.EdgeInline {
+		t.Errorf("mallocgc -> acquirem should be inline, got %s", kind)
+	}
+	if !verifier.Next("mallocgc", "acquirem") {
+		t.Errorf("mallocgc -> acquirem should be next")
+	}
+
+	// makeslice really calls mallocgc
+	if kind := verifier.NextKind("makeslice", "mallocgc"); kind != pprofsv.EdgeCall {
+		t.Errorf("makeslice -> mallocgc should be call, got %s", kind)
+	}
+
+	// an inline expansion still counts towards reachability
+	if !verifier.Reachable("makeslice", "acquirem") {
+		t.Errorf("makeslice -> acquirem should be reachable")
+	}
+
+	// no direct path in the opposite direction
+	if kind := verifier.NextKind("acquirem", "mallocgc"); kind != 0 {
+		t.Errorf("acquirem -> mallocgc should not be next, got %s", kind)
+	}
+}
+
+func TestVerifierReachablePath(t *testing.T) {
+	pprof := loadProfile(t, "testdata/pprof.profile")
+
+	verifier, err := pprofsv.NewProfile(pprof).Verifier("dummy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const prefix = "github.com/gaukas/pprofsv/dummy.(*Dummy)."
+	verifier.SetFunctionPrefix(prefix)
+
+	witness := verifier.ReachablePath("DeepFunc", "deepFuncLv3")
+	if witness == nil {
+		t.Fatal("DeepFunc -> deepFuncLv3 should be reachable")
+	}
+
+	expected := []string{prefix + "DeepFunc", prefix + "deepFuncLv1", prefix + "deepFuncLv2", prefix + "deepFuncLv3"}
+	if !slices.Equal(witness.Functions, expected) {
+		t.Errorf("DeepFunc -> deepFuncLv3 should go through %v, got %v", expected, witness.Functions)
+	}
+
+	if len(witness.Edges) != len(expected)-1 {
+		t.Fatalf("expected %d edges, got %d", len(expected)-1, len(witness.Edges))
+	}
+
+	// every edge must point at real samples containing it
+	for _, edge := range witness.Edges {
+		if edge.Kind != pprofsv.EdgeCall {
+			t.Errorf("%s -> %s should be call, got %s", edge.From, edge.To, edge.Kind)
+		}
+
+		if len(edge.Samples) == 0 {
+			t.Errorf("%s -> %s has no samples", edge.From, edge.To)
+		}
+
+		for _, i := range edge.Samples {
+			if !sampleHasEdge(pprof.Sample[i], edge.From, edge.To) {
+				t.Errorf("sample %d does not contain %s -> %s", i, edge.From, edge.To)
+			}
+		}
+	}
+
+	if s := witness.String(); !strings.HasPrefix(s, prefix+"DeepFunc -call-> ") {
+		t.Errorf("unexpected witness string %q", s)
+	}
+
+	if witness := verifier.ReachablePath("deepFuncLv5", "DeepFunc"); witness != nil {
+		t.Errorf("deepFuncLv5 -> DeepFunc should not be reachable, got %s", witness)
+	}
+
+	if witness := verifier.ReachablePath("DeepFunc", "deepFuncLv3", "deepFuncLv2"); witness != nil {
+		t.Errorf("DeepFunc -> deepFuncLv3 avoiding deepFuncLv2 should not be reachable, got %s", witness)
+	}
+}
+
+// sampleHasEdge reports whether caller directly calls callee somewhere in
+// the sample, skipping no frames.
+func sampleHasEdge(sample *profile.Sample, caller, callee string) bool {
+	var callStack []string
+	for _, location := range sample.Location {
+		for _, line := range location.Line {
+			callStack = append(callStack, line.Function.Name)
+		}
+	}
+
+	for i := 0; i < len(callStack)-1; i++ {
+		if callStack[i+1] == caller && callStack[i] == callee {
+			return true
+		}
+	}
+	return false
+}
+
+func TestVerifierCheck(t *testing.T) {
+	pprof := loadProfile(t, "testdata/pprof.profile")
+
+	verifier, err := pprofsv.NewProfile(pprof).Verifier("dummy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier.SetFunctionPrefix("github.com/gaukas/pprofsv/dummy.(*Dummy).")
+
+	if reachable, err := verifier.CheckReachable("DeepFunc", "deepFuncLv5"); err != nil || !reachable {
+		t.Errorf("DeepFunc -> deepFuncLv5 should be reachable, got %t, %v", reachable, err)
+	}
+
+	if reachable, err := verifier.CheckReachable("deepFuncLv5", "DeepFunc"); err != nil || reachable {
+		t.Errorf("deepFuncLv5 -> DeepFunc should not be reachable, got %t, %v", reachable, err)
+	}
+
+	// typo
+	if _, err := verifier.CheckReachable("DeepFunc", "deepFuncLv9"); !errors.Is(err, pprofsv.ErrFunctionNotFound) {
+		t.Errorf("deepFuncLv9 should not be found, got %v", err)
+	}
+
+	// in the profile, but not matching the pattern
+	sub, err := verifier.SubVerifier("deepFunc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.SetFunctionPrefix("github.com/gaukas/pprofsv/dummy.(*Dummy).")
+
+	if _, err := sub.CheckNext("DeepFunc", "deepFuncLv1"); !errors.Is(err, pprofsv.ErrFunctionFiltered) {
+		t.Errorf("DeepFunc should be filtered out, got %v", err)
+	}
+
+	var functionErr *pprofsv.FunctionError
+	if _, err := sub.CheckNext("deepFuncLv1", "DeepFunc"); !errors.As(err, &functionErr) || functionErr.Name != "github.com/gaukas/pprofsv/dummy.(*Dummy).DeepFunc" {
+		t.Errorf("expected a FunctionError for DeepFunc, got %v", err)
+	}
+
+	// filtered skipped functions are ignored
+	if reachable, err := sub.CheckReachable("deepFuncLv1", "deepFuncLv5", "sleep"); err != nil || !reachable {
+		t.Errorf("deepFuncLv1 -> deepFuncLv5 should be reachable, got %t, %v", reachable, err)
+	}
+
+	// strict mode panics instead of returning false
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("strict mode should panic on unknown function")
+			} else if err, ok := r.(error); !ok || !errors.Is(err, pprofsv.ErrFunctionNotFound) {
+				t.Errorf("strict mode should panic with ErrFunctionNotFound, got %v", r)
+			}
+		}()
+		verifier.SetStrict(true)
+		defer verifier.SetStrict(false)
+		verifier.Next("DeepFunc", "deepFuncLv9")
+	}()
+}
+
+func TestVerifierAmbiguous(t *testing.T) {
+	// two distinct functions share the name "pkg.A"
+	a1 := &profile.Function{ID: 1, Name: "pkg.A", Filename: "a1.go"}
+	a2 := &profile.Function{ID: 2, Name: "pkg.A", Filename: "a2.go"}
+	b := &profile.Function{ID: 3, Name: "pkg.B", Filename: "b.go"}
+	c := &profile.Function{ID: 4, Name: "other.C", Filename: "c.go"}
+	l1 := &profile.Location{ID: 1, Line: []profile.Line{{Function: a1}}}
+	l2 := &profile.Location{ID: 2, Line: []profile.Line{{Function: a2}}}
+	l3 := &profile.Location{ID: 3, Line: []profile.Line{{Function: b}}}
+	l4 := &profile.Location{ID: 4, Line: []profile.Line{{Function: c}}}
+	pprof := &profile.Profile{
+		Function: []*profile.Function{a1, a2, b, c},
+		Location: []*profile.Location{l1, l2, l3, l4},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{l3, l1}},
+			{Location: []*profile.Location{l2, l4}},
+		},
+	}
+
+	verifier, err := pprofsv.NewProfile(pprof).Verifier("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := verifier.CheckNext("pkg.A", "pkg.B"); !errors.Is(err, pprofsv.ErrAmbiguousFunction) {
+		t.Errorf("pkg.A should be ambiguous, got %v", err)
+	}
+
+	if _, err := verifier.CheckReachable("other.C", "pkg.B", "pkg.A"); !errors.Is(err, pprofsv.ErrAmbiguousFunction) {
+		t.Errorf("skipped pkg.A should be ambiguous, got %v", err)
+	}
+
+	// unambiguous names are unaffected
+	if next, err := verifier.CheckNext("pkg.B", "other.C"); err != nil || next {
+		t.Errorf("pkg.B -> other.C should not be next, got %t, %v", next, err)
+	}
+}
+
+func TestVerifierWeight(t *testing.T) {
+	t.Run("Synthetic", func(t *testing.T) {
+		verifier, err := pprofsv.NewProfile(syntheticProfile("main;a;a;b", "main;a;c", "main;b")).Verifier("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		testVerifierWeight(t, verifier)
+
+		// a sub-verifier keeps the sample values
+		sub, err := verifier.SubVerifier("^(main|a|c)$")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if share, err := sub.Share("a", "c", ""); err != nil || share != 0.5 {
+			t.Errorf("share of a -> c should be 0.5, got %v, %v", share, err)
+		}
+	})
+
+	t.Run("Dummy", func(t *testing.T) {
+		pprof := loadProfile(t, "testdata/pprof.profile")
+
+		verifier, err := pprofsv.NewProfile(pprof).Verifier("dummy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifier.SetFunctionPrefix("github.com/gaukas/pprofsv/dummy.(*Dummy).")
+
+		// BranchFunc takes each branch half of the time
+		for _, branch := range []string{"branchA", "branchB"} {
+			share, err := verifier.Share("BranchFunc", branch, "cpu")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if share < 0.4 || share > 0.6 {
+				t.Errorf("share of BranchFunc -> %s should be about 0.5, got %.3f", branch, share)
+			}
+		}
+	})
+}
+
+func testVerifierWeight(t *testing.T, verifier *pprofsv.Verifier) {
+	// a recursive function counts once per sample
+	if weight, err := verifier.Weight("a", "samples"); err != nil || weight != 2 {
+		t.Errorf("weight of a should be 2, got %d, %v", weight, err)
+	}
+	if weight, err := verifier.EdgeWeight("a", "a", "samples"); err != nil || weight != 1 {
+		t.Errorf("weight of a -> a should be 1, got %d, %v", weight, err)
+	}
+
+	if share, err := verifier.Share("main", "a", ""); err != nil || share != 2.0/3 {
+		t.Errorf("share of main -> a should be 2/3, got %v, %v", share, err)
+	}
+	if share, err := verifier.Share("main", "c", ""); err != nil || share != 0 {
+		t.Errorf("share of main -> c should be 0, got %v, %v", share, err)
+	}
+
+	if _, err := verifier.Weight("a", "cpu"); !errors.Is(err, pprofsv.ErrSampleTypeNotFound) {
+		t.Errorf("cpu should not be found, got %v", err)
+	}
+	if _, err := verifier.Weight("d", ""); !errors.Is(err, pprofsv.ErrFunctionNotFound) {
+		t.Errorf("d should not be found, got %v", err)
+	}
+}
+
+func TestVerifierLabelSelector(t *testing.T) {
+	pprof := syntheticProfile("main;accept;close", "main;connect;close")
+	pprof.Sample[0].Label = map[string][]string{"role": {"server"}}
+	pprof.Sample[1].Label = map[string][]string{"role": {"client"}}
+	p := pprofsv.NewProfile(pprof)
+
+	verifier, err := p.Verifier("", pprofsv.WithLabelSelector(pprofsv.MustParseLabelSelector("role!=server")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verifier.Reachable("connect", "close") {
+		t.Errorf("connect -> close should be reachable for clients")
+	}
+	if verifier.Reachable("main", "accept") {
+		t.Errorf("main -> accept should not be reachable for clients")
+	}
+
+	// no sample matches
+	verifier, err = p.Verifier("", pprofsv.WithLabelSelector(pprofsv.MustParseLabelSelector("role=proxy")))
+	if err != nil || verifier != nil {
+		t.Errorf("expected no verifier, got %v, %v", verifier, err)
+	}
+
+	// base call stacks carry no labels
+	if _, err := pprofsv.NewVerifier(p, [][]uint64{{1}}, "", pprofsv.WithLabelSelector(pprofsv.MustParseLabelSelector("role=server"))); err == nil {
+		t.Errorf("expected an error with base call stacks")
+	}
+}
+
+func TestVerifierLargeProfile(t *testing.T) {
+	p := pprofsv.NewProfile(largeProfile(200, 50000, 16))
+
+	// the call stacks are reduced in parallel only with several Ps
+	reduce := func(procs int) *pprofsv.Verifier {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+		v, err := p.Verifier(`^pkg[13]\.`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	sequential, parallel := reduce(1), reduce(4)
+
+	if !slices.EqualFunc(sequential.DumpCallstack(), parallel.DumpCallstack(), slices.Equal[[]string]) {
+		t.Fatal("call stacks reduced in parallel differ")
+	}
+	for _, function := range sequential.Functions() {
+		want, err := sequential.Weight(function, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := parallel.Weight(function, ""); err != nil || got != want {
+			t.Errorf("weight of %s: expected %d, got %d (%v)", function, want, got, err)
+		}
+	}
+}
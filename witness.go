@@ -0,0 +1,85 @@
+package pprofsv
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Witness is a concrete chain of functions proving that one function can
+// reach another. When an assertion expecting a function to be unreachable
+// fails, the Witness is its counterexample.
+type Witness struct {
+	// Functions lists the full names of the functions on the path, from
+	// the caller to the final callee.
+	Functions []string `yaml:"functions" json:"functions"`
+
+	// Edges lists every direct path between two consecutive Functions.
+	Edges []WitnessEdge `yaml:"edges" json:"edges"`
+}
+
+// WitnessEdge is a single direct path in a Witness.
+type WitnessEdge struct {
+	From string   `yaml:"from" json:"from"`
+	To   string   `yaml:"to" json:"to"`
+	Kind EdgeKind `yaml:"kind" json:"kind"`
+
+	// Samples holds the indices of the samples containing this edge.
+	// Indices follow the order of the samples in the pprof profile (or of
+	// the base call stacks the Verifier was built from), so they can be
+	// used to locate the original stacks.
+	Samples []int `yaml:"samples" json:"samples"`
+}
+
+// String formats the Witness as a single line, e.g.
+// "A -call-> B -inline-> C".
+func (w *Witness) String() string {
+	if w == nil || len(w.Functions) == 0 {
+		return "<no path>"
+	}
+
+	var b strings.Builder
+	b.WriteString(w.Functions[0])
+	for _, edge := range w.Edges {
+		fmt.Fprintf(&b, " -%s-> %s", edge.Kind, edge.To)
+	}
+	return b.String()
+}
+
+// OrderWitness is the counterexample to an ordering assertion, such as
+// before, eventually or never-after: the observations, on a single
+// goroutine, that break it.
+type OrderWitness struct {
+	Goroutine int64        `yaml:"goroutine" json:"goroutine"`
+	Events    []OrderEvent `yaml:"events" json:"events"`
+}
+
+// OrderEvent is a single observation of a function in an OrderWitness.
+type OrderEvent struct {
+	Function string `yaml:"function" json:"function"`
+
+	// Time is the timestamp of the observation, in nanoseconds. See
+	// TraceTimeLabel.
+	Time int64 `yaml:"time" json:"time"`
+
+	// Sample is the index of the sample of the observation, as in
+	// WitnessEdge.Samples.
+	Sample int `yaml:"sample" json:"sample"`
+}
+
+// String formats the OrderWitness as a single line, e.g.
+// "goroutine 7: B at 1200, then A at 1500".
+func (w *OrderWitness) String() string {
+	if w == nil || len(w.Events) == 0 {
+		return "<no events>"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "goroutine %d: ", w.Goroutine)
+	for i, event := range w.Events {
+		if i > 0 {
+			b.WriteString(", then ")
+		}
+		fmt.Fprintf(&b, "%s at %d", event.Function, event.Time)
+	}
+	return b.String()
+}